@@ -0,0 +1,64 @@
+package primitive
+
+import (
+	"image"
+	"io"
+	"strings"
+)
+
+// asciiRamp runs from the darkest character to the brightest, as seen on a
+// dark terminal.
+const asciiRamp = " .:-=+*#%@"
+
+// ASCIIArt returns the canvas as text cols characters wide, one character
+// per cell of the image, chosen by the cell's mean luminance from a ramp
+// that runs from a space for black to @ for white, for terminals with a
+// dark background. Cells are twice as tall as they are wide, as terminal
+// characters are, so the art keeps the image's proportions. It is drawn
+// from the working canvas, so RenderScale, the output size and Border have
+// no effect.
+func (model *Model) ASCIIArt(cols int) string {
+	im := imageToRGBA(model.outputImage(model.Context.Image()))
+	w, h := im.Rect.Dx(), im.Rect.Dy()
+	cols = clampInt(cols, 1, w)
+	rows := clampInt(int(float64(cols)*float64(h)/float64(w)/2+0.5), 1, h)
+	var b strings.Builder
+	for row := 0; row < rows; row++ {
+		y0, y1 := row*h/rows, (row+1)*h/rows
+		for col := 0; col < cols; col++ {
+			x0, x1 := col*w/cols, (col+1)*w/cols
+			l := meanLuminance(im, image.Rect(x0, y0, x1, y1))
+			b.WriteByte(asciiRamp[minInt(int(l*float64(len(asciiRamp))), len(asciiRamp)-1)])
+		}
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+// meanLuminance returns the mean Rec. 601 luma of the pixels of im in r,
+// in [0, 1].
+func meanLuminance(im *image.RGBA, r image.Rectangle) float64 {
+	var sum float64
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		i := im.PixOffset(im.Rect.Min.X+r.Min.X, im.Rect.Min.Y+y)
+		for x := r.Min.X; x < r.Max.X; x++ {
+			sum += 0.299*float64(im.Pix[i]) + 0.587*float64(im.Pix[i+1]) + 0.114*float64(im.Pix[i+2])
+			i += 4
+		}
+	}
+	return sum / 255 / float64(r.Dx()*r.Dy())
+}
+
+// ASCIIEncoder writes ASCIIArt Columns characters wide.
+type ASCIIEncoder struct {
+	Columns int
+}
+
+func (e ASCIIEncoder) Encode(w io.Writer, m *Model) error {
+	_, err := io.WriteString(w, m.ASCIIArt(e.Columns))
+	return err
+}
+
+func (e ASCIIEncoder) ContentType() string {
+	return "text/plain; charset=utf-8"
+}
@@ -0,0 +1,64 @@
+package primitive
+
+import (
+	"image"
+	"image/draw"
+)
+
+// OptimizeBackground hill climbs the background color to lower the score of
+// the bare canvas, starting from the current one, and returns the color it
+// settles on. Each of up to iterations rounds tries moving each channel up
+// and down by a step and takes the best move, halving the step when none
+// helps, so it usually stops early. Under the plain squared error the mean
+// color is already close to the best, but weight masks, channel weights,
+// transparent pixels and linear light move the optimum away from it. Call
+// it before adding shapes; with shapes already added it returns the
+// background unchanged.
+func (model *Model) OptimizeBackground(iterations int) Color {
+	if len(model.Shapes) > 0 {
+		return model.Background
+	}
+	best := model.Background
+	bestScore := model.backgroundScore(best)
+	step := 64
+	for i := 0; i < iterations && step > 0; i++ {
+		improved := false
+		next := best
+		for ch := 0; ch < 3; ch++ {
+			for _, d := range []int{-step, step} {
+				c := best
+				p := [3]*int{&c.R, &c.G, &c.B}[ch]
+				*p = clampInt(*p+d, 0, 255)
+				if c == best {
+					continue
+				}
+				if score := model.backgroundScore(c); score < bestScore {
+					next, bestScore, improved = c, score, true
+				}
+			}
+		}
+		if improved {
+			best = next
+		} else {
+			step /= 2
+		}
+	}
+	model.Background = best
+	model.repaint()
+	return best
+}
+
+// backgroundScore returns the score of a bare canvas in background c. It
+// leaves Current filled with c.
+func (model *Model) backgroundScore(c Color) float64 {
+	canvas := model.canvasColor(c)
+	draw.Draw(model.Current, model.Current.Rect, &image.Uniform{canvas.NRGBA()}, image.ZP, draw.Src)
+	return model.differenceFull()
+}
+
+// repaint fills Current and Context with the background and rescores. It
+// is for setting changes made before any shapes are added.
+func (model *Model) repaint() {
+	model.Score = model.backgroundScore(model.Background)
+	model.clearContext(model.Context, model.Scale, model.Scale)
+}
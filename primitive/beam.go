@@ -0,0 +1,102 @@
+package primitive
+
+import (
+	"context"
+	"image"
+	"sort"
+)
+
+// beamState returns the candidate for the next shape that, among the
+// BeamWidth best the workers find, leaves the lowest error once the best
+// shape after it is added too. Each is added speculatively and taken out
+// again, so the model is unchanged. Candidates outside the shape bounds are
+// passed over; if every one is, the best is returned for StepContext to
+// reject. It returns nil if every worker failed.
+func (model *Model) beamState(ctx context.Context, t ShapeType, alpha int) *State {
+	candidates := model.runWorkersTop(t, alpha, 1000, 100, model.candidates(), model.BeamWidth)
+	if len(candidates) == 0 {
+		return nil
+	}
+	// the bounds depend on the canvas, so check them all before any is
+	// added
+	var allowed []*State
+	for _, state := range candidates {
+		if state.Worker.shapeAllowed(state.Shape.Rasterize()) {
+			allowed = append(allowed, state)
+		}
+	}
+	if len(allowed) < 2 {
+		if len(allowed) == 1 {
+			return allowed[0]
+		}
+		return candidates[0]
+	}
+
+	mark := model.mark()
+	best, bestScore := allowed[0], 0.0
+	for i, state := range allowed {
+		if ctx.Err() != nil {
+			break
+		}
+		model.Add(state.Shape, state.Alpha)
+		score := model.Score
+		if next := model.runWorkers(t, alpha, 1000, 100, model.candidates()); next != nil && next.Worker.shapeAllowed(next.Shape.Rasterize()) {
+			score = next.Energy()
+		}
+		model.undo(mark)
+		if i == 0 || score < bestScore {
+			best, bestScore = state, score
+		}
+	}
+	// the look ahead left the workers on the speculative canvases
+	for _, worker := range model.Workers {
+		model.initWorker(worker)
+	}
+	return best
+}
+
+// sortStates sorts states by energy, lowest first, keeping the order of
+// equal ones.
+func sortStates(states []*State) {
+	sort.SliceStable(states, func(i, j int) bool {
+		return states[i].Energy() < states[j].Energy()
+	})
+}
+
+// A modelMark is what Add changes, saved by mark so that undo can take the
+// shapes added since out again.
+type modelMark struct {
+	n        int
+	score    float64
+	coverage float64
+	current  []uint8
+	context  []uint8
+	paints   []uint16
+}
+
+func (model *Model) mark() modelMark {
+	return modelMark{
+		n:        len(model.Shapes),
+		score:    model.Score,
+		coverage: model.coverage,
+		current:  append([]uint8(nil), model.Current.Pix...),
+		context:  append([]uint8(nil), model.Context.Image().(*image.RGBA).Pix...),
+		paints:   append([]uint16(nil), model.paints...),
+	}
+}
+
+// undo restores the model to the mark. The canvases are restored in place,
+// since the workers share them.
+func (model *Model) undo(m modelMark) {
+	model.Shapes = model.Shapes[:m.n]
+	model.Colors = model.Colors[:m.n]
+	model.Scores = model.Scores[:m.n]
+	model.Deltas = model.Deltas[:m.n]
+	model.Gradients = model.Gradients[:m.n]
+	model.centers = model.centers[:m.n]
+	model.Score = m.score
+	model.coverage = m.coverage
+	copy(model.Current.Pix, m.current)
+	copy(model.Context.Image().(*image.RGBA).Pix, m.context)
+	copy(model.paints, m.paints)
+}
@@ -0,0 +1,129 @@
+package primitive
+
+import (
+	"image"
+
+	"github.com/fogleman/gg"
+)
+
+// BlendMode controls how a shape's color combines with the canvas beneath
+// it. The search composites candidates with the same mode that is used for
+// the output, so colors are fit to what is actually rendered. Non-normal
+// modes treat the canvas as opaque.
+type BlendMode int
+
+const (
+	BlendNormal BlendMode = iota
+	BlendAdd
+	BlendMultiply
+	BlendScreen
+)
+
+// svgBlendModes maps blend modes to CSS mix-blend-mode values.
+var svgBlendModes = map[BlendMode]string{
+	BlendAdd:      "plus-lighter",
+	BlendMultiply: "multiply",
+	BlendScreen:   "screen",
+}
+
+func blendChannel(mode BlendMode, s, d int) int {
+	switch mode {
+	case BlendAdd:
+		return minInt(s+d, 255)
+	case BlendMultiply:
+		return s * d / 255
+	case BlendScreen:
+		return s + d - s*d/255
+	}
+	return s
+}
+
+// computeColorBlend is computeColor for non-normal blend modes. Each mode is
+// linear in the source color (ignoring clipping), so the least squares fit
+// has a closed form.
+func computeColorBlend(target, current *image.RGBA, lines []Scanline, alpha int, mode BlendMode) Color {
+	if mode == BlendNormal {
+		return computeColor(target, current, lines, alpha)
+	}
+	a := float64(alpha) / 255
+	var num, den [3]float64
+	for _, line := range lines {
+		i := target.PixOffset(line.X1, line.Y)
+		for x := line.X1; x <= line.X2; x++ {
+			for j := 0; j < 3; j++ {
+				t := float64(target.Pix[i+j]) / 255
+				d := float64(current.Pix[i+j]) / 255
+				// the result is d + a*k*s + a*b, find the s that best
+				// matches t
+				var k, b float64
+				switch mode {
+				case BlendAdd:
+					k, b = 1, 0
+				case BlendMultiply:
+					k, b = d, -d
+				case BlendScreen:
+					k, b = 1-d, 0
+				}
+				num[j] += a * k * (t - d - a*b)
+				den[j] += a * a * k * k
+			}
+			i += 4
+		}
+	}
+	var c [3]int
+	for j := range c {
+		if den[j] > 0 {
+			c[j] = clampInt(int(num[j]/den[j]*255+0.5), 0, 255)
+		}
+	}
+	return Color{c[0], c[1], c[2], alpha}
+}
+
+// drawLinesBlend is drawLines for non-normal blend modes.
+func drawLinesBlend(im *image.RGBA, c Color, lines []Scanline, mode BlendMode) {
+	if mode == BlendNormal {
+		drawLines(im, c, lines)
+		return
+	}
+	src := [3]int{c.R, c.G, c.B}
+	for _, line := range lines {
+		a := int(uint32(c.A) * line.Alpha / 0xffff)
+		i := im.PixOffset(line.X1, line.Y)
+		for x := line.X1; x <= line.X2; x++ {
+			for j := 0; j < 3; j++ {
+				d := int(im.Pix[i+j])
+				b := blendChannel(mode, src[j], d)
+				im.Pix[i+j] = uint8(d + (b-d)*a/255)
+			}
+			i += 4
+		}
+	}
+}
+
+// drawShapeBlend draws a shape onto dc with a non-normal blend mode. gg only
+// composites with source-over, so the shape is drawn into a coverage mask
+// and blended into the canvas by hand.
+func drawShapeBlend(dc *gg.Context, shape Shape, c Color, sx, sy float64, mode BlendMode, join StrokeJoin) {
+	mask := gg.NewContext(dc.Width(), dc.Height())
+	mask.SetLineJoin(join.lineJoin())
+	mask.Scale(sx, sy)
+	mask.Translate(0.5, 0.5)
+	mask.SetRGB(1, 1, 1)
+	shape.Draw(mask, (sx+sy)/2)
+	mask.Fill()
+	cover := mask.Image().(*image.RGBA)
+	im := dc.Image().(*image.RGBA)
+	src := [3]int{c.R, c.G, c.B}
+	for i := 0; i < len(im.Pix); i += 4 {
+		m := int(cover.Pix[i+3])
+		if m == 0 {
+			continue
+		}
+		a := m * c.A / 255
+		for j := 0; j < 3; j++ {
+			d := int(im.Pix[i+j])
+			b := blendChannel(mode, src[j], d)
+			im.Pix[i+j] = uint8(d + (b-d)*a/255)
+		}
+	}
+}
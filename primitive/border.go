@@ -0,0 +1,91 @@
+package primitive
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"math"
+)
+
+// Border frames the output in a solid border width output pixels wide, for
+// framed prints. Render, RenderTopK and the SVG keep their size and draw the
+// shapes scaled into the inset area, so the frame covers none of them. It
+// has no effect on the search. A width of zero or less removes the border.
+func (model *Model) Border(width int, color Color) {
+	model.borderWidth = maxInt(width, 0)
+	model.borderColor = color
+}
+
+// borderSize returns the border width for a w x h output, narrowed so that
+// at least one pixel is left inside it.
+func (model *Model) borderSize(w, h int) int {
+	return clampInt(model.borderWidth, 0, (minInt(w, h)-1)/2)
+}
+
+// insetSize returns the size of the area inside the border of a w x h
+// output.
+func (model *Model) insetSize(w, h int) (int, int) {
+	b := model.borderSize(w, h)
+	return w - 2*b, h - 2*b
+}
+
+// addBorder places im, drawn at insetSize(w, h), in the middle of a w x h
+// image framed in the border color. Without a border im is returned as is.
+func (model *Model) addBorder(im image.Image, w, h int) image.Image {
+	b := model.borderSize(w, h)
+	if b == 0 {
+		return im
+	}
+	c := model.borderColor
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(dst, dst.Rect, &image.Uniform{c.NRGBA()}, image.ZP, draw.Src)
+	inner := image.Rect(b, b, w-b, h-b)
+	draw.Draw(dst, inner, im, im.Bounds().Min, draw.Src)
+	return dst
+}
+
+// svgBorder returns the SVG viewBox for a w x h output and, with a border,
+// the path that draws it. The viewBox grows by the border, in working
+// coordinates, so the shapes fill the same inset area as in Render; the
+// path covers the ring between the viewBox and the working area, over any
+// shapes that overflow it.
+func (model *Model) svgBorder(w, h int) (string, string) {
+	size := model.Target.Bounds().Size()
+	b := model.borderSize(w, h)
+	if b == 0 {
+		return fmt.Sprintf("0 0 %d %d", size.X, size.Y), ""
+	}
+	iw, ih := model.insetSize(w, h)
+	bx := float64(b*size.X) / float64(iw)
+	by := float64(b*size.Y) / float64(ih)
+	x0, y0 := -bx, -by
+	x1, y1 := float64(size.X)+bx, float64(size.Y)+by
+	viewBox := fmt.Sprintf("%f %f %f %f", x0, y0, x1-x0, y1-y0)
+	c := model.borderColor
+	path := fmt.Sprintf("<path d=\"M %f %f H %f V %f H %f Z M 0 0 V %d H %d V 0 Z\" fill=\"#%02x%02x%02x\" fill-opacity=\"%f\" fill-rule=\"evenodd\" />",
+		x0, y0, x1, y1, x0, size.Y, size.X, c.R, c.G, c.B, float64(c.A)/255)
+	return viewBox, path
+}
+
+// LetterboxTo returns Render's output fitted inside a w x h image, keeping
+// the target's aspect ratio, centered and padded with the background color,
+// so that outputs of any aspect come out the same size. The shapes are
+// redrawn at the fitted size, and the output size set on the model is not
+// used. The search is not affected.
+func (model *Model) LetterboxTo(w, h int) image.Image {
+	w, h = maxInt(w, 1), maxInt(h, 1)
+	size := model.Target.Bounds().Size()
+	fw, fh := w, h
+	if w*size.Y > h*size.X {
+		fw = clampInt(int(math.Round(float64(h*size.X)/float64(size.Y))), 1, w)
+	} else {
+		fh = clampInt(int(math.Round(float64(w*size.Y)/float64(size.X))), 1, h)
+	}
+	im := model.renderAt(fw, fh)
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	bg := model.Background
+	draw.Draw(dst, dst.Rect, &image.Uniform{bg.NRGBA()}, image.ZP, draw.Src)
+	x, y := (w-fw)/2, (h-fh)/2
+	draw.Draw(dst, image.Rect(x, y, x+fw, y+fh), im, im.Bounds().Min, draw.Src)
+	return dst
+}
@@ -0,0 +1,119 @@
+package primitive
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"sort"
+
+	"github.com/fogleman/gg"
+	xdraw "golang.org/x/image/draw"
+)
+
+// ComparisonImage places original, scaled to fit the render's size, to the
+// left of Render's output with a white separator gap pixels wide. When the
+// two differ in height the canvas takes the taller one and the shorter is
+// centered vertically.
+func (model *Model) ComparisonImage(original image.Image, gap int) image.Image {
+	render := model.Render()
+	rw, rh := render.Bounds().Dx(), render.Bounds().Dy()
+
+	ow, oh := original.Bounds().Dx(), original.Bounds().Dy()
+	if ow*rh > oh*rw {
+		ow, oh = rw, maxInt(oh*rw/ow, 1)
+	} else {
+		ow, oh = maxInt(ow*rh/oh, 1), rh
+	}
+	gap = maxInt(gap, 0)
+
+	h := maxInt(oh, rh)
+	dst := image.NewRGBA(image.Rect(0, 0, ow+gap+rw, h))
+	draw.Draw(dst, dst.Rect, &image.Uniform{color.White}, image.ZP, draw.Src)
+	left := image.Rect(0, (h-oh)/2, ow, (h-oh)/2+oh)
+	xdraw.BiLinear.Scale(dst, left, original, original.Bounds(), xdraw.Src, nil)
+	right := image.Rect(ow+gap, (h-rh)/2, ow+gap+rw, (h-rh)/2+rh)
+	draw.Draw(dst, right, render, render.Bounds().Min, draw.Src)
+	return dst
+}
+
+// errorOverlayPercentile is the share of pixels whose error ErrorOverlay
+// draws below full heat, so a few outliers do not wash out the rest.
+const errorOverlayPercentile = 0.99
+
+// ErrorOverlay shows where the render still differs from target, which is
+// usually the original image: the shapes are redrawn at target's size and
+// each pixel's error, its RMS difference over the color channels, is
+// colored on a hot scale from dark red through yellow to white and blended
+// over target at opacity times that error, so matching areas show target
+// untouched. The error is scaled so that the worst one percent of pixels
+// are at full heat. opacity is clamped to [0, 1].
+func (model *Model) ErrorOverlay(target image.Image, opacity float64) image.Image {
+	dst := imageToRGBA(target)
+	w, h := dst.Rect.Dx(), dst.Rect.Dy()
+	render := imageToRGBA(model.RenderSize(w, h))
+	errs := make([]float64, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			i, j := dst.PixOffset(dst.Rect.Min.X+x, dst.Rect.Min.Y+y), render.PixOffset(x, y)
+			var sum float64
+			for c := 0; c < 3; c++ {
+				d := float64(dst.Pix[i+c]) - float64(render.Pix[j+c])
+				sum += d * d
+			}
+			errs[y*w+x] = math.Sqrt(sum / 3)
+		}
+	}
+	sorted := append([]float64(nil), errs...)
+	sort.Float64s(sorted)
+	level := sorted[int(float64(len(sorted)-1)*errorOverlayPercentile)]
+	if level == 0 {
+		level = sorted[len(sorted)-1]
+	}
+	if level == 0 {
+		return dst
+	}
+	opacity = clamp(opacity, 0, 1)
+	for k, e := range errs {
+		t := math.Min(e/level, 1)
+		a := opacity * t
+		heat := [3]float64{clamp(3*t, 0, 1), clamp(3*t-1, 0, 1), clamp(3*t-2, 0, 1)}
+		i := dst.PixOffset(dst.Rect.Min.X+k%w, dst.Rect.Min.Y+k/w)
+		alpha := float64(dst.Pix[i+3])
+		for c := 0; c < 3; c++ {
+			// the image is premultiplied, so the heat is too
+			v := float64(dst.Pix[i+c])*(1-a) + heat[c]*alpha*a
+			dst.Pix[i+c] = uint8(math.Round(v))
+		}
+	}
+	return dst
+}
+
+// ContactSheet tiles images into a grid columns wide, each cell the size of
+// the largest image with its label centered beneath it, on white. Smaller
+// images are centered in their cells.
+func ContactSheet(images []image.Image, labels []string, columns int) image.Image {
+	columns = clampInt(columns, 1, maxInt(len(images), 1))
+	rows := (len(images) + columns - 1) / columns
+	cw, ch := 0, 0
+	for _, im := range images {
+		cw = maxInt(cw, im.Bounds().Dx())
+		ch = maxInt(ch, im.Bounds().Dy())
+	}
+	const pad, labelHeight = 8, 20
+	cellW, cellH := cw+pad, ch+labelHeight
+	dc := gg.NewContext(columns*cellW+pad, rows*cellH+pad)
+	dc.SetColor(color.White)
+	dc.Clear()
+	dc.SetColor(color.Black)
+	for i, im := range images {
+		x := pad + i%columns*cellW
+		y := pad + i/columns*cellH
+		b := im.Bounds()
+		dc.DrawImage(im, x+(cw-b.Dx())/2-b.Min.X, y+(ch-b.Dy())/2-b.Min.Y)
+		if i < len(labels) {
+			dc.DrawStringAnchored(labels[i], float64(x+cw/2), float64(y+ch+labelHeight/2), 0.5, 0.35)
+		}
+	}
+	return dc.Image()
+}
@@ -33,6 +33,114 @@ func computeColor(target, current *image.RGBA, lines []Scanline, alpha int) Colo
 	return Color{r, g, b, alpha}
 }
 
+// computeColorCoverage is computeColor with each pixel weighted by its
+// line's coverage, the color that best matches the target where the shape
+// is drawn with that coverage.
+func computeColorCoverage(target, current *image.RGBA, lines []Scanline, alpha int) Color {
+	// a pixel with coverage k becomes d + (c - d) * a * k, which is least
+	// squares against t for c = sum(k*(t-d))/(a*sum(k*k)) + sum(k*k*d)/sum(k*k)
+	a := float64(alpha) / 255
+	var num, mean [3]float64
+	var den float64
+	for _, line := range lines {
+		k := float64(line.Alpha) / 0xffff
+		i := target.PixOffset(line.X1, line.Y)
+		for x := line.X1; x <= line.X2; x++ {
+			for j := 0; j < 3; j++ {
+				t := float64(target.Pix[i+j])
+				d := float64(current.Pix[i+j])
+				num[j] += k * (t - d)
+				mean[j] += k * k * d
+			}
+			den += k * k
+			i += 4
+		}
+	}
+	if den == 0 {
+		return Color{}
+	}
+	var c [3]int
+	for j := range c {
+		c[j] = clampInt(int(num[j]/(a*den)+mean[j]/den), 0, 255)
+	}
+	return Color{c[0], c[1], c[2], alpha}
+}
+
+// jointAlphaMin is the lowest alpha computeColorAlpha picks.
+const jointAlphaMin = 16
+
+// computeColorAlpha returns the color and alpha that together best match the
+// target where lines are drawn over current. A pixel d drawn with c at alpha
+// a becomes d + (c - d) * a, which is linear in d with a slope of 1 - a
+// shared by the channels, so least squares gives a = 1 - sum(cov(d, t)) /
+// sum(var(d)) over the channels, and then c = mean(d) + mean(t - d) / a.
+// Where that color is out of range, or the canvas under the lines is flat
+// so that the fit leaves a free, every alpha from jointAlphaMin up is tried
+// instead with its best color in range, and the one with the lowest error
+// kept. Coverage is not weighted.
+func computeColorAlpha(target, current *image.RGBA, lines []Scanline) Color {
+	var sd, st, sdd, sdt, stt [3]float64
+	var n float64
+	for _, line := range lines {
+		i := target.PixOffset(line.X1, line.Y)
+		for x := line.X1; x <= line.X2; x++ {
+			for j := 0; j < 3; j++ {
+				t := float64(target.Pix[i+j])
+				d := float64(current.Pix[i+j])
+				sd[j] += d
+				st[j] += t
+				sdd[j] += d * d
+				sdt[j] += d * t
+				stt[j] += t * t
+			}
+			n++
+			i += 4
+		}
+	}
+	if n == 0 {
+		return Color{}
+	}
+	// fit returns the best color in range for alpha a, and its error
+	fit := func(a float64) ([3]int, float64) {
+		var c [3]int
+		var e float64
+		for j := range c {
+			c[j] = clampInt(int(math.Round((sd[j]+(st[j]-sd[j])/a)/n)), 0, 255)
+			v := float64(c[j])
+			e += sdd[j] - 2*sdt[j] + stt[j] +
+				2*a*(v*sd[j]-sdd[j]-v*st[j]+sdt[j]) +
+				a*a*(n*v*v-2*v*sd[j]+sdd[j])
+		}
+		return c, e
+	}
+	var cov, variance float64
+	for j := 0; j < 3; j++ {
+		cov += sdt[j] - sd[j]*st[j]/n
+		variance += sdd[j] - sd[j]*sd[j]/n
+	}
+	if variance > 1e-9*n {
+		alpha := clampInt(int(math.Round((1-cov/variance)*255)), jointAlphaMin, 255)
+		a := float64(alpha) / 255
+		inRange := true
+		for j := 0; j < 3; j++ {
+			if v := (sd[j] + (st[j]-sd[j])/a) / n; v < -0.5 || v > 255.5 {
+				inRange = false
+			}
+		}
+		if inRange {
+			c, _ := fit(a)
+			return Color{c[0], c[1], c[2], alpha}
+		}
+	}
+	best, bestAlpha, bestError := [3]int{}, 0, math.Inf(1)
+	for alpha := jointAlphaMin; alpha <= 255; alpha++ {
+		if c, e := fit(float64(alpha) / 255); e < bestError {
+			best, bestAlpha, bestError = c, alpha, e
+		}
+	}
+	return Color{best[0], best[1], best[2], bestAlpha}
+}
+
 func copyLines(dst, src *image.RGBA, lines []Scanline) {
 	for _, line := range lines {
 		a := dst.PixOffset(line.X1, line.Y)
@@ -122,3 +230,139 @@ func differencePartial(target, before, after *image.RGBA, score float64, lines [
 	}
 	return math.Sqrt(float64(total)/float64(w*h*4)) / 255
 }
+
+// normalizedDifference is the root mean squared difference of a and b's
+// red, green and blue, over 255: sqrt(sum((a - b)^2) / (w*h*3)) / 255.
+func normalizedDifference(a, b *image.RGBA) float64 {
+	size := a.Bounds().Size()
+	w, h := size.X, size.Y
+	var total uint64
+	for y := 0; y < h; y++ {
+		i := a.PixOffset(0, y)
+		for x := 0; x < w; x++ {
+			for j := 0; j < 3; j++ {
+				d := int(a.Pix[i+j]) - int(b.Pix[i+j])
+				total += uint64(d * d)
+			}
+			i += 4
+		}
+	}
+	return math.Sqrt(float64(total)/float64(w*h*3)) / 255
+}
+
+// normalizedPartial is normalizedDifference of target and after, given
+// score, that of target and before, which differ only under lines.
+func normalizedPartial(target, before, after *image.RGBA, score float64, lines []Scanline) float64 {
+	size := target.Bounds().Size()
+	w, h := size.X, size.Y
+	total := math.Pow(score*255, 2) * float64(w*h*3)
+	for _, line := range lines {
+		i := target.PixOffset(line.X1, line.Y)
+		for x := line.X1; x <= line.X2; x++ {
+			for j := 0; j < 3; j++ {
+				d1 := int(target.Pix[i+j]) - int(before.Pix[i+j])
+				d2 := int(target.Pix[i+j]) - int(after.Pix[i+j])
+				total += float64(d2*d2 - d1*d1)
+			}
+			i += 4
+		}
+	}
+	return math.Sqrt(math.Max(total, 0)/float64(w*h*3)) / 255
+}
+
+// differenceFullWeighted is differenceFull with each pixel's squared error
+// scaled by its weight, and each channel's by its weight in channels. norm is
+// the sum of the pixel weights times the sum of the channel weights, so
+// uniform weights of one give the same score as differenceFull.
+func differenceFullWeighted(a, b *image.RGBA, weights []float64, channels [4]float64, norm float64) float64 {
+	size := a.Bounds().Size()
+	w, h := size.X, size.Y
+	var total float64
+	for y := 0; y < h; y++ {
+		i := a.PixOffset(0, y)
+		k := y * w
+		for x := 0; x < w; x++ {
+			ar := int(a.Pix[i])
+			ag := int(a.Pix[i+1])
+			ab := int(a.Pix[i+2])
+			aa := int(a.Pix[i+3])
+			br := int(b.Pix[i])
+			bg := int(b.Pix[i+1])
+			bb := int(b.Pix[i+2])
+			ba := int(b.Pix[i+3])
+			i += 4
+			dr := ar - br
+			dg := ag - bg
+			db := ab - bb
+			da := aa - ba
+			total += weights[k] * (channels[0]*float64(dr*dr) + channels[1]*float64(dg*dg) + channels[2]*float64(db*db) + channels[3]*float64(da*da))
+			k++
+		}
+	}
+	return math.Sqrt(total/norm) / 255
+}
+
+func differencePartialWeighted(target, before, after *image.RGBA, weights []float64, channels [4]float64, norm, score float64, lines []Scanline) float64 {
+	w := target.Bounds().Size().X
+	total := math.Pow(score*255, 2) * norm
+	for _, line := range lines {
+		i := target.PixOffset(line.X1, line.Y)
+		k := line.Y*w + line.X1
+		for x := line.X1; x <= line.X2; x++ {
+			tr := int(target.Pix[i])
+			tg := int(target.Pix[i+1])
+			tb := int(target.Pix[i+2])
+			ta := int(target.Pix[i+3])
+			br := int(before.Pix[i])
+			bg := int(before.Pix[i+1])
+			bb := int(before.Pix[i+2])
+			ba := int(before.Pix[i+3])
+			ar := int(after.Pix[i])
+			ag := int(after.Pix[i+1])
+			ab := int(after.Pix[i+2])
+			aa := int(after.Pix[i+3])
+			i += 4
+			dr1 := tr - br
+			dg1 := tg - bg
+			db1 := tb - bb
+			da1 := ta - ba
+			dr2 := tr - ar
+			dg2 := tg - ag
+			db2 := tb - ab
+			da2 := ta - aa
+			d := channels[0]*float64(dr2*dr2-dr1*dr1) + channels[1]*float64(dg2*dg2-dg1*dg1) +
+				channels[2]*float64(db2*db2-db1*db1) + channels[3]*float64(da2*da2-da1*da1)
+			total += weights[k] * d
+			k++
+		}
+	}
+	return math.Sqrt(math.Max(total, 0)/norm) / 255
+}
+
+// subtractLines returns the parts of lines that are not covered by exclude.
+// w and h are the size of the image both sets of lines belong to.
+func subtractLines(lines, exclude []Scanline, w, h int) []Scanline {
+	if len(exclude) == 0 {
+		return lines
+	}
+	mask := make([]bool, w*h)
+	for _, line := range exclude {
+		for x := line.X1; x <= line.X2; x++ {
+			mask[line.Y*w+x] = true
+		}
+	}
+	var result []Scanline
+	for _, line := range lines {
+		start := -1
+		for x := line.X1; x <= line.X2+1; x++ {
+			free := x <= line.X2 && !mask[line.Y*w+x]
+			if free && start < 0 {
+				start = x
+			} else if !free && start >= 0 {
+				result = append(result, Scanline{line.Y, start, x - 1, line.Alpha})
+				start = -1
+			}
+		}
+	}
+	return result
+}
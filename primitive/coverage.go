@@ -0,0 +1,26 @@
+package primitive
+
+import "errors"
+
+// ErrCoverageLimit is returned by StepContext once the shapes' combined area
+// has passed MaxCumulativeCoverage.
+var ErrCoverageLimit = errors.New("primitive: cumulative coverage limit reached")
+
+// Coverage returns the sum of the shapes' areas, in working pixels, over
+// the canvas's area. It can pass 1 as shapes overlap: 2 means the canvas
+// has been painted over twice on average.
+func (model *Model) Coverage() float64 {
+	return model.coverage
+}
+
+// CoverageLimitReached reports whether the shapes' combined area has passed
+// MaxCumulativeCoverage, so that Step adds no more.
+func (model *Model) CoverageLimitReached() bool {
+	return model.MaxCumulativeCoverage > 0 && model.coverage > model.MaxCumulativeCoverage
+}
+
+// areaFraction returns the area lines cover over the canvas's area.
+func (model *Model) areaFraction(lines []Scanline) float64 {
+	size := model.Target.Bounds().Size()
+	return float64(linesArea(lines)) / float64(size.X*size.Y)
+}
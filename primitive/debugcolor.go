@@ -0,0 +1,68 @@
+package primitive
+
+import "math"
+
+// A DebugColorMode replaces the fitted colors in the output with colors that
+// show how the image was built up.
+type DebugColorMode int
+
+const (
+	DebugColorNone DebugColorMode = iota
+	// DebugColorByIndex colors the shapes along a rainbow, from red for the
+	// first shape added to violet for the last.
+	DebugColorByIndex
+	// DebugColorByType gives each shape type a color of its own.
+	DebugColorByType
+)
+
+var debugTypeColors = map[ShapeType]Color{
+	ShapeTypeTriangle:         {230, 25, 75, 255},
+	ShapeTypeRectangle:        {60, 180, 75, 255},
+	ShapeTypeEllipse:          {0, 130, 200, 255},
+	ShapeTypeCircle:           {255, 225, 25, 255},
+	ShapeTypeRotatedRectangle: {245, 130, 48, 255},
+	ShapeTypeQuadratic:        {145, 30, 180, 255},
+	ShapeTypeRotatedEllipse:   {70, 240, 240, 255},
+	ShapeTypePolygon:          {240, 50, 230, 255},
+}
+
+// shapeFill returns the color and gradient the output draws shape i with:
+// its fitted ones, or a solid debug color with the fitted alpha.
+func (model *Model) shapeFill(i int) (Color, *Gradient) {
+	var c Color
+	switch model.DebugColorMode {
+	case DebugColorByIndex:
+		t := 0.0
+		if n := len(model.Shapes); n > 1 {
+			t = float64(i) / float64(n-1)
+		}
+		c = hueColor(t * 270)
+	case DebugColorByType:
+		c = debugTypeColors[shapeTypeOf(model.Shapes[i])]
+	default:
+		return model.Colors[i], model.Gradients[i]
+	}
+	c.A = model.Colors[i].A
+	return c, nil
+}
+
+// hueColor returns the fully saturated color of hue h, in degrees.
+func hueColor(h float64) Color {
+	x := 1 - math.Abs(math.Mod(h/60, 2)-1)
+	var r, g, b float64
+	switch int(h/60) % 6 {
+	case 0:
+		r, g = 1, x
+	case 1:
+		r, g = x, 1
+	case 2:
+		g, b = 1, x
+	case 3:
+		g, b = x, 1
+	case 4:
+		r, b = x, 1
+	default:
+		r, b = 1, x
+	}
+	return Color{int(r * 255), int(g * 255), int(b * 255), 255}
+}
@@ -0,0 +1,71 @@
+package primitive
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"math"
+)
+
+// The largest density the JFIF header can hold.
+const maxDPI = math.MaxUint16
+
+// EncodeJPEG is jpeg.Encode that also records dpi, in dots per inch, in a
+// JFIF header, so the image opens at the intended physical size. A dpi of 0
+// writes no header, like jpeg.Encode.
+func EncodeJPEG(w io.Writer, im image.Image, quality, dpi int) error {
+	if dpi <= 0 {
+		return jpeg.Encode(w, im, &jpeg.Options{Quality: quality})
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, im, &jpeg.Options{Quality: quality}); err != nil {
+		return err
+	}
+	data := buf.Bytes()
+	d := uint16(clampInt(dpi, 1, maxDPI))
+	// APP0 goes right after the start of image marker
+	app0 := []byte{0xff, 0xe0, 0, 16, 'J', 'F', 'I', 'F', 0, 1, 1, 1, 0, 0, 0, 0, 0, 0}
+	binary.BigEndian.PutUint16(app0[12:], d)
+	binary.BigEndian.PutUint16(app0[14:], d)
+	for _, part := range [][]byte{data[:2], app0, data[2:]} {
+		if _, err := w.Write(part); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// EncodePNG is png.Encode that also records dpi in a pHYs chunk. PNG stores
+// pixels per meter, so the value is converted and rounded. A dpi of 0 writes
+// no chunk, like png.Encode.
+func EncodePNG(w io.Writer, im image.Image, dpi int) error {
+	if dpi <= 0 {
+		return png.Encode(w, im)
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, im); err != nil {
+		return err
+	}
+	data := buf.Bytes()
+	ppm := uint32(math.Round(float64(clampInt(dpi, 1, maxDPI)) / 0.0254))
+	chunk := make([]byte, 4+4+9+4)
+	binary.BigEndian.PutUint32(chunk[0:], 9)
+	copy(chunk[4:], "pHYs")
+	binary.BigEndian.PutUint32(chunk[8:], ppm)
+	binary.BigEndian.PutUint32(chunk[12:], ppm)
+	chunk[16] = 1 // the unit is the meter
+	binary.BigEndian.PutUint32(chunk[17:], crc32.ChecksumIEEE(chunk[4:17]))
+	// pHYs must come before the image data; the encoder always writes the
+	// 8 byte signature and then the 25 byte IHDR chunk first
+	const ihdrEnd = 8 + 25
+	for _, part := range [][]byte{data[:ihdrEnd], chunk, data[ihdrEnd:]} {
+		if _, err := w.Write(part); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package primitive
+
+import (
+	"math"
+	"sort"
+)
+
+const (
+	// detailFloor is the weight DetailWeighting gives flat areas, against 1
+	// in the most textured.
+	detailFloor = 0.25
+	// detailPercentile is the share of pixels whose local variance is below
+	// the level DetailWeighting counts as fully textured.
+	detailPercentile = 0.9
+)
+
+// detailMap returns each target pixel's weight under DetailWeighting: the
+// standard deviation of the luminance in a window around it, about a
+// sixty-fourth of the target's longer side across, scaled so that the most
+// textured tenth of the image gets 1 and flat areas detailFloor. It is
+// computed once per target. A target with no texture at all gets nil.
+func (model *Model) detailMap() []float64 {
+	if model.detailWeights != nil {
+		return model.detailWeights
+	}
+	im := model.Target
+	w, h := im.Rect.Dx(), im.Rect.Dy()
+	r := maxInt(int(math.Round(float64(maxInt(w, h))/128)), 2)
+
+	// integral images of the luminance and its square, with a zero row and
+	// column in front
+	sums := make([]float64, (w+1)*(h+1))
+	squares := make([]float64, (w+1)*(h+1))
+	for y := 0; y < h; y++ {
+		var row, rowSquares float64
+		for x := 0; x < w; x++ {
+			i := im.PixOffset(x, y)
+			v := 0.299*float64(im.Pix[i]) + 0.587*float64(im.Pix[i+1]) + 0.114*float64(im.Pix[i+2])
+			row += v
+			rowSquares += v * v
+			k := (y+1)*(w+1) + x + 1
+			sums[k] = sums[k-w-1] + row
+			squares[k] = squares[k-w-1] + rowSquares
+		}
+	}
+	deviations := make([]float64, w*h)
+	for y := 0; y < h; y++ {
+		y0, y1 := maxInt(y-r, 0), minInt(y+r+1, h)
+		for x := 0; x < w; x++ {
+			x0, x1 := maxInt(x-r, 0), minInt(x+r+1, w)
+			box := func(s []float64) float64 {
+				return s[y1*(w+1)+x1] - s[y0*(w+1)+x1] - s[y1*(w+1)+x0] + s[y0*(w+1)+x0]
+			}
+			n := float64((x1 - x0) * (y1 - y0))
+			mean := box(sums) / n
+			deviations[y*w+x] = math.Sqrt(math.Max(box(squares)/n-mean*mean, 0))
+		}
+	}
+
+	sorted := append([]float64(nil), deviations...)
+	sort.Float64s(sorted)
+	level := sorted[int(float64(len(sorted)-1)*detailPercentile)]
+	if level == 0 {
+		level = sorted[len(sorted)-1]
+	}
+	if level == 0 {
+		return nil
+	}
+	weights := make([]float64, len(deviations))
+	for i, d := range deviations {
+		weights[i] = detailFloor + (1-detailFloor)*math.Min(d/level, 1)
+	}
+	model.detailWeights = weights
+	return weights
+}
+
+// detailWeighting returns the weight masks' combined weights, nil for none,
+// multiplied by the detail map when DetailWeighting is set.
+func (model *Model) detailWeighting() []float64 {
+	model.detailApplied = model.DetailWeighting
+	if !model.DetailWeighting {
+		return model.maskWeights
+	}
+	detail := model.detailMap()
+	switch {
+	case detail == nil:
+		return model.maskWeights
+	case model.maskWeights == nil:
+		return detail
+	}
+	weights := make([]float64, len(detail))
+	for i := range weights {
+		weights[i] = model.maskWeights[i] * detail[i]
+	}
+	return weights
+}
@@ -0,0 +1,60 @@
+package primitive
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+)
+
+const (
+	// determinismSize is the size VerifyDeterminism shrinks its input to.
+	determinismSize = 128
+	// determinismWorkers is how many workers each VerifyDeterminism run
+	// uses, so that results from several workers are combined.
+	determinismWorkers = 4
+)
+
+// VerifyDeterminism runs steps steps of mixed shape types twice, from the
+// same seed, on img shrunk to fit 128 pixels, with four workers each, and
+// reports whether both runs added the same shapes in the same colors, with
+// the same score and canvas. It is for tests and CI, to catch changes that
+// break seeded runs. It returns an error, and false, describing the first
+// difference, or if img is empty or steps is not positive.
+func VerifyDeterminism(img image.Image, seed int64, steps int) (bool, error) {
+	if img == nil || img.Bounds().Empty() {
+		return false, fmt.Errorf("determinism: empty image")
+	}
+	if steps <= 0 {
+		return false, fmt.Errorf("determinism: steps must be positive, got %d", steps)
+	}
+	target := downscaleSample(img, determinismSize)
+	run := func() *Model {
+		model := NewModel(target, MakeColor(AverageImageColor(target)), determinismSize, determinismWorkers)
+		model.Seed(seed)
+		for i := 0; i < steps; i++ {
+			model.Step(ShapeTypeAny, 128, 0)
+		}
+		return model
+	}
+	a, b := run(), run()
+	if len(a.Shapes) != len(b.Shapes) {
+		return false, fmt.Errorf("determinism: %d shapes, then %d", len(a.Shapes), len(b.Shapes))
+	}
+	for i := range a.Shapes {
+		ta, tb := shapeTypeOf(a.Shapes[i]), shapeTypeOf(b.Shapes[i])
+		pa, pb := shapeParams(a.Shapes[i]), shapeParams(b.Shapes[i])
+		if ta != tb || fmt.Sprint(pa) != fmt.Sprint(pb) {
+			return false, fmt.Errorf("determinism: shape %d is %s %v, then %s %v", i, ta, pa, tb, pb)
+		}
+		if a.Colors[i] != b.Colors[i] {
+			return false, fmt.Errorf("determinism: shape %d is %s, then %s", i, hexColor(a.Colors[i]), hexColor(b.Colors[i]))
+		}
+	}
+	if a.Score != b.Score {
+		return false, fmt.Errorf("determinism: score %v, then %v", a.Score, b.Score)
+	}
+	if !bytes.Equal(a.Current.Pix, b.Current.Pix) {
+		return false, fmt.Errorf("determinism: the canvases differ")
+	}
+	return true, nil
+}
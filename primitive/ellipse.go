@@ -17,19 +17,42 @@ type Ellipse struct {
 
 func NewRandomEllipse(worker *Worker) *Ellipse {
 	rnd := worker.Rnd
-	x := rnd.Intn(worker.W)
-	y := rnd.Intn(worker.H)
+	x, y := worker.randomPoint()
 	rx := rnd.Intn(32) + 1
 	ry := rnd.Intn(32) + 1
-	return &Ellipse{worker, x, y, rx, ry, false}
+	if s, ok := worker.fixedSize(); ok {
+		rx = maxInt(int(s/2), 1)
+		s, _ = worker.fixedSize()
+		ry = maxInt(int(s/2), 1)
+	}
+	c := &Ellipse{worker, x, y, rx, ry, false}
+	c.limitAspect()
+	return c
 }
 
 func NewRandomCircle(worker *Worker) *Ellipse {
 	rnd := worker.Rnd
-	x := rnd.Intn(worker.W)
-	y := rnd.Intn(worker.H)
+	x, y := worker.randomPoint()
 	r := rnd.Intn(32) + 1
-	return &Ellipse{worker, x, y, r, r, true}
+	if s, ok := worker.fixedSize(); ok {
+		r = maxInt(int(s/2), 1)
+	}
+	c := &Ellipse{worker, x, y, r, r, true}
+	if worker.gridded() {
+		c.snap()
+	}
+	return c
+}
+
+// snap aligns a circle to the grid: its center on a grid point and its
+// radius a whole number of cells.
+func (c *Ellipse) snap() {
+	worker := c.Worker
+	g := worker.GridSize
+	c.X = worker.snap(c.X, worker.W)
+	c.Y = worker.snap(c.Y, worker.H)
+	c.Rx = maxInt(int(math.Round(float64(c.Rx)/float64(g)))*g, g)
+	c.Ry = c.Rx
 }
 
 func (c *Ellipse) Draw(dc *gg.Context, scale float64) {
@@ -38,6 +61,11 @@ func (c *Ellipse) Draw(dc *gg.Context, scale float64) {
 }
 
 func (c *Ellipse) SVG(attrs string) string {
+	if c.Circle {
+		return fmt.Sprintf(
+			"<circle %s cx=\"%d\" cy=\"%d\" r=\"%d\" />",
+			attrs, c.X, c.Y, c.Rx)
+	}
 	return fmt.Sprintf(
 		"<ellipse %s cx=\"%d\" cy=\"%d\" rx=\"%d\" ry=\"%d\" />",
 		attrs, c.X, c.Y, c.Rx, c.Ry)
@@ -52,27 +80,68 @@ func (c *Ellipse) Mutate() {
 	w := c.Worker.W
 	h := c.Worker.H
 	rnd := c.Worker.Rnd
-	switch rnd.Intn(3) {
+	t := ShapeTypeEllipse
+	if c.Circle {
+		t = ShapeTypeCircle
+	}
+	d := 16 * c.Worker.mutationScale(t)
+	move := func() int {
+		// only circles are snapped to the grid
+		if c.Circle {
+			return c.Worker.offset(d)
+		}
+		return int(rnd.NormFloat64() * d)
+	}
+	switch c.Worker.mutationChoice(t, 3, 0) {
 	case 0:
-		c.X = clampInt(c.X+int(rnd.NormFloat64()*16), 0, w-1)
-		c.Y = clampInt(c.Y+int(rnd.NormFloat64()*16), 0, h-1)
+		c.X = clampInt(c.X+move(), 0, w-1)
+		c.Y = clampInt(c.Y+move(), 0, h-1)
 	case 1:
-		c.Rx = clampInt(c.Rx+int(rnd.NormFloat64()*16), 1, w-1)
+		if s, ok := c.Worker.fixedSize(); ok {
+			c.Rx = clampInt(int(s/2), 1, w-1)
+		} else {
+			c.Rx = clampInt(c.Rx+move(), 1, w-1)
+		}
 		if c.Circle {
 			c.Ry = c.Rx
 		}
 	case 2:
-		c.Ry = clampInt(c.Ry+int(rnd.NormFloat64()*16), 1, h-1)
+		if s, ok := c.Worker.fixedSize(); ok {
+			c.Ry = clampInt(int(s/2), 1, h-1)
+		} else {
+			c.Ry = clampInt(c.Ry+move(), 1, h-1)
+		}
 		if c.Circle {
 			c.Rx = c.Ry
 		}
 	}
+	if !c.Circle {
+		c.limitAspect()
+	}
+	if c.Circle && c.Worker.gridded() {
+		c.snap()
+	}
+}
+
+// limitAspect shortens the ellipse's longer radius to keep it within
+// MaxAspectRatio.
+func (c *Ellipse) limitAspect() {
+	rx, ry := c.Worker.limitAspect(float64(c.Rx), float64(c.Ry))
+	c.Rx, c.Ry = maxInt(int(rx), 1), maxInt(int(ry), 1)
 }
 
 func (c *Ellipse) Rasterize() []Scanline {
 	w := c.Worker.W
 	h := c.Worker.H
 	lines := c.Worker.Lines[:0]
+	if c.Rx < 1 || c.Ry < 1 {
+		return lines
+	}
+	if c.Worker.AntialiasSearch {
+		// the scanlines below reach half a pixel past the radii
+		x, y := float64(c.X)+0.5, float64(c.Y)+0.5
+		return fillPath(c.Worker, ellipsePath(x, y, float64(c.Rx)+0.5, float64(c.Ry)+0.5, 0))
+	}
 	aspect := float64(c.Rx) / float64(c.Ry)
 	for dy := 0; dy < c.Ry; dy++ {
 		y1 := c.Y - dy
@@ -108,10 +177,15 @@ type RotatedEllipse struct {
 
 func NewRandomRotatedEllipse(worker *Worker) *RotatedEllipse {
 	rnd := worker.Rnd
-	x := rnd.Float64() * float64(worker.W)
-	y := rnd.Float64() * float64(worker.H)
+	x, y := worker.randomPointF()
 	rx := rnd.Float64()*32 + 1
 	ry := rnd.Float64()*32 + 1
+	if s, ok := worker.fixedSize(); ok {
+		rx = math.Max(s/2, 1)
+		s, _ = worker.fixedSize()
+		ry = math.Max(s/2, 1)
+	}
+	rx, ry = worker.limitAspect(rx, ry)
 	a := rnd.Float64() * 360
 	return &RotatedEllipse{worker, x, y, rx, ry, a}
 }
@@ -139,19 +213,36 @@ func (c *RotatedEllipse) Mutate() {
 	w := c.Worker.W
 	h := c.Worker.H
 	rnd := c.Worker.Rnd
-	switch rnd.Intn(3) {
+	d := 16 * c.Worker.mutationScale(ShapeTypeRotatedEllipse)
+	switch c.Worker.mutationChoice(ShapeTypeRotatedEllipse, 3, 0) {
 	case 0:
-		c.X = clamp(c.X+rnd.NormFloat64()*16, 0, float64(w-1))
-		c.Y = clamp(c.Y+rnd.NormFloat64()*16, 0, float64(h-1))
+		c.X = clamp(c.X+rnd.NormFloat64()*d, 0, float64(w-1))
+		c.Y = clamp(c.Y+rnd.NormFloat64()*d, 0, float64(h-1))
 	case 1:
-		c.Rx = clamp(c.Rx+rnd.NormFloat64()*16, 1, float64(w-1))
-		c.Ry = clamp(c.Ry+rnd.NormFloat64()*16, 1, float64(w-1))
+		if s, ok := c.Worker.fixedSize(); ok {
+			c.Rx = clamp(s/2, 1, float64(w-1))
+			s, _ = c.Worker.fixedSize()
+			c.Ry = clamp(s/2, 1, float64(w-1))
+			break
+		}
+		c.Rx = clamp(c.Rx+rnd.NormFloat64()*d, 1, float64(w-1))
+		c.Ry = clamp(c.Ry+rnd.NormFloat64()*d, 1, float64(w-1))
 	case 2:
-		c.Angle = c.Angle + rnd.NormFloat64()*32
+		c.Angle = c.Angle + rnd.NormFloat64()*d*2
 	}
+	c.Rx, c.Ry = c.Worker.limitAspect(c.Rx, c.Ry)
 }
 
 func (c *RotatedEllipse) Rasterize() []Scanline {
+	if c.Rx < 1 || c.Ry < 1 {
+		return c.Worker.Lines[:0]
+	}
+	return fillPath(c.Worker, ellipsePath(c.X, c.Y, c.Rx, c.Ry, c.Angle))
+}
+
+// ellipsePath returns an ellipse centered on x, y and turned by angle
+// degrees, as 16 quadratic segments.
+func ellipsePath(x, y, rx, ry, angle float64) raster.Path {
 	var path raster.Path
 	const n = 16
 	for i := 0; i < n; i++ {
@@ -159,21 +250,21 @@ func (c *RotatedEllipse) Rasterize() []Scanline {
 		p2 := float64(i+1) / n
 		a1 := p1 * 2 * math.Pi
 		a2 := p2 * 2 * math.Pi
-		x0 := c.Rx * math.Cos(a1)
-		y0 := c.Ry * math.Sin(a1)
-		x1 := c.Rx * math.Cos(a1+(a2-a1)/2)
-		y1 := c.Ry * math.Sin(a1+(a2-a1)/2)
-		x2 := c.Rx * math.Cos(a2)
-		y2 := c.Ry * math.Sin(a2)
+		x0 := rx * math.Cos(a1)
+		y0 := ry * math.Sin(a1)
+		x1 := rx * math.Cos(a1+(a2-a1)/2)
+		y1 := ry * math.Sin(a1+(a2-a1)/2)
+		x2 := rx * math.Cos(a2)
+		y2 := ry * math.Sin(a2)
 		cx := 2*x1 - x0/2 - x2/2
 		cy := 2*y1 - y0/2 - y2/2
-		x0, y0 = rotate(x0, y0, radians(c.Angle))
-		cx, cy = rotate(cx, cy, radians(c.Angle))
-		x2, y2 = rotate(x2, y2, radians(c.Angle))
+		x0, y0 = rotate(x0, y0, radians(angle))
+		cx, cy = rotate(cx, cy, radians(angle))
+		x2, y2 = rotate(x2, y2, radians(angle))
 		if i == 0 {
-			path.Start(fixp(x0+c.X, y0+c.Y))
+			path.Start(fixp(x0+x, y0+y))
 		}
-		path.Add2(fixp(cx+c.X, cy+c.Y), fixp(x2+c.X, y2+c.Y))
+		path.Add2(fixp(cx+x, cy+y), fixp(x2+x, y2+y))
 	}
-	return fillPath(c.Worker, path)
+	return path
 }
@@ -0,0 +1,25 @@
+package primitive
+
+import (
+	"image"
+	"strings"
+	"testing"
+)
+
+func TestEllipseSVGElements(t *testing.T) {
+	worker := NewWorker(image.NewRGBA(image.Rect(0, 0, 32, 32)))
+	if got, want := (&Ellipse{worker, 5, 6, 3, 3, true}).SVG(`fill="#000"`), `<circle fill="#000" cx="5" cy="6" r="3" />`; got != want {
+		t.Fatalf("circle svg = %s, want %s", got, want)
+	}
+	if got, want := (&Ellipse{worker, 5, 6, 3, 4, false}).SVG(`fill="#000"`), `<ellipse fill="#000" cx="5" cy="6" rx="3" ry="4" />`; got != want {
+		t.Fatalf("ellipse svg = %s, want %s", got, want)
+	}
+	// an ellipse that happens to be round is still an ellipse, and random
+	// circles are circles
+	if got := (&Ellipse{worker, 5, 6, 3, 3, false}).SVG(""); !strings.HasPrefix(got, "<ellipse ") {
+		t.Fatalf("round ellipse svg = %s, want an ellipse element", got)
+	}
+	if got := NewRandomCircle(worker).SVG(""); !strings.HasPrefix(got, "<circle ") {
+		t.Fatalf("random circle svg = %s, want a circle element", got)
+	}
+}
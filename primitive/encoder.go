@@ -0,0 +1,115 @@
+package primitive
+
+import (
+	"io"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// An Encoder writes a model's output in some file format.
+type Encoder interface {
+	Encode(w io.Writer, m *Model) error
+	ContentType() string
+}
+
+// JPEGEncoder writes JPEG at Quality. A positive DPI is recorded in the
+// file, as EncodeJPEG does.
+type JPEGEncoder struct {
+	Quality int
+	DPI     int
+}
+
+func (e JPEGEncoder) Encode(w io.Writer, m *Model) error {
+	return EncodeJPEG(w, m.Render(), e.Quality, e.DPI)
+}
+
+func (e JPEGEncoder) ContentType() string {
+	return "image/jpeg"
+}
+
+// PNGEncoder writes PNG. A positive DPI is recorded in the file, as
+// EncodePNG does.
+type PNGEncoder struct {
+	DPI int
+}
+
+func (e PNGEncoder) Encode(w io.Writer, m *Model) error {
+	return EncodePNG(w, m.Render(), e.DPI)
+}
+
+func (e PNGEncoder) ContentType() string {
+	return "image/png"
+}
+
+type SVGEncoder struct{}
+
+func (e SVGEncoder) Encode(w io.Writer, m *Model) error {
+	if _, err := m.svgShapeRendering(); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, m.SVG())
+	return err
+}
+
+func (e SVGEncoder) ContentType() string {
+	return "image/svg+xml"
+}
+
+type JSONEncoder struct{}
+
+func (e JSONEncoder) Encode(w io.Writer, m *Model) error {
+	data, err := m.MarshalShapes()
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+func (e JSONEncoder) ContentType() string {
+	return "application/json"
+}
+
+var (
+	encodersMu sync.RWMutex
+	encoders   = map[string]Encoder{
+		"jpg":  JPEGEncoder{Quality: 95},
+		"jpeg": JPEGEncoder{Quality: 95},
+		"png":  PNGEncoder{},
+		"svg":  SVGEncoder{},
+		"json": JSONEncoder{},
+		// a 100 shape run plays in about ten seconds
+		"lottie":  LottieEncoder{FadeMs: 100},
+		"ascii":   ASCIIEncoder{Columns: 80},
+		"geojson": GeoJSONEncoder{},
+	}
+)
+
+// RegisterEncoder makes an encoder available under a format name, replacing
+// any encoder already registered under it. Names are case insensitive.
+func RegisterEncoder(name string, e Encoder) {
+	encodersMu.Lock()
+	defer encodersMu.Unlock()
+	encoders[strings.ToLower(name)] = e
+}
+
+// LookupEncoder returns the encoder registered for a format name.
+func LookupEncoder(name string) (Encoder, bool) {
+	encodersMu.RLock()
+	defer encodersMu.RUnlock()
+	e, ok := encoders[strings.ToLower(name)]
+	return e, ok
+}
+
+// EncoderNames returns the registered format names.
+func EncoderNames() []string {
+	encodersMu.RLock()
+	defer encodersMu.RUnlock()
+	names := make([]string, 0, len(encoders))
+	for name := range encoders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
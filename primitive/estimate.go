@@ -0,0 +1,83 @@
+package primitive
+
+import (
+	"image"
+	"image/color"
+	"runtime"
+	"sync"
+	"time"
+)
+
+const (
+	estimateSampleSize = 64
+	estimateSteps      = 2
+	// Step runs this many hill climbs, divided among the workers.
+	climbsPerStep = DefaultCandidatesPerStep
+)
+
+var (
+	estimateMu sync.Mutex
+	stepTimes  = make(map[ShapeType]time.Duration)
+)
+
+// EstimateDuration predicts how long count steps of shape type t take on a
+// model whose target's longer side is imageSize pixels, using workers
+// workers. The first call for each shape type times a couple of single
+// worker steps on a small synthetic image, which takes a fraction of a
+// second; later calls are immediate. The estimate grows linearly with count
+// and with imageSize, and shrinks with workers up to the number of CPUs. It
+// assumes the default CandidatesPerStep and is safe to call from several
+// goroutines.
+func EstimateDuration(imageSize, count, workers int, t ShapeType) time.Duration {
+	if imageSize <= 0 || count <= 0 {
+		return 0
+	}
+	step := calibratedStep(t)
+	workers = clampInt(workers, 1, runtime.NumCPU())
+	// each worker runs its share of the climbs one after another
+	climbs := (climbsPerStep + workers - 1) / workers
+	// step time grows roughly linearly with the side length rather than the
+	// area, since shapes shrink as the search refines them and much of the
+	// cost is per scanline
+	scale := float64(imageSize) / estimateSampleSize
+	d := float64(step) * float64(count) * scale * float64(climbs) / climbsPerStep
+	return time.Duration(d)
+}
+
+// calibratedStep returns the measured time of one single worker step at
+// estimateSampleSize.
+func calibratedStep(t ShapeType) time.Duration {
+	estimateMu.Lock()
+	defer estimateMu.Unlock()
+	if d, ok := stepTimes[t]; ok {
+		return d
+	}
+	target := estimateSample()
+	model := NewModel(target, MakeColor(AverageImageColor(target)), estimateSampleSize, 1)
+	model.Seed(0)
+	start := time.Now()
+	for i := 0; i < estimateSteps; i++ {
+		model.Step(t, 128, 0)
+	}
+	d := time.Since(start) / estimateSteps
+	stepTimes[t] = d
+	return d
+}
+
+// estimateSample is a gradient with a dark disc on it, a rough stand-in for a
+// subject against a smooth background.
+func estimateSample() image.Image {
+	n := estimateSampleSize
+	im := image.NewNRGBA(image.Rect(0, 0, n, n))
+	for y := 0; y < n; y++ {
+		for x := 0; x < n; x++ {
+			c := color.NRGBA{uint8(x * 255 / n), uint8(y * 200 / n), 120, 255}
+			dx, dy := x-n/2, y-n/3
+			if dx*dx+dy*dy < n*n/16 {
+				c = color.NRGBA{40, 30, 20, 255}
+			}
+			im.SetNRGBA(x, y, c)
+		}
+	}
+	return im
+}
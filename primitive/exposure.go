@@ -0,0 +1,39 @@
+package primitive
+
+import "math"
+
+// exposureTable returns the table that applies Exposure to channel values
+// on the canvas, or nil if Exposure leaves them as they are.
+func (model *Model) exposureTable() *[256]uint8 {
+	e := model.Exposure
+	if e <= 0 || e == 1 {
+		return nil
+	}
+	var t [256]uint8
+	for i := range t {
+		v := float64(i) / 255
+		if model.linearLight {
+			v = math.Min(v*e, 1)
+		} else {
+			v = srgbOf(math.Min(linearOf(v)*e, 1))
+		}
+		t[i] = uint8(math.Round(v * 255))
+	}
+	return &t
+}
+
+// exposeFill applies an exposure table to a fill chosen by fitFill.
+func exposeFill(c Color, g *Gradient, t *[256]uint8) (Color, *Gradient) {
+	if t == nil {
+		return c, g
+	}
+	expose := func(c Color) Color {
+		return Color{int(t[c.R]), int(t[c.G]), int(t[c.B]), c.A}
+	}
+	if g != nil {
+		a := *g
+		a.From, a.To = expose(g.From), expose(g.To)
+		return a.mean(), &a
+	}
+	return expose(c), nil
+}
@@ -0,0 +1,174 @@
+package primitive
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"math"
+
+	"github.com/fogleman/gg"
+)
+
+// featherEnabled reports whether shapes are drawn with feathered edges.
+func (model *Model) featherEnabled() bool {
+	return model.EdgeFeather > 0 && model.BlendMode == BlendNormal
+}
+
+// drawFeathered draws a shape onto dc, whose transform scales working
+// coordinates by sx, sy, on a layer of its own whose edges are blurred by
+// EdgeFeather before it is composited.
+func (model *Model) drawFeathered(dc *gg.Context, shape Shape, c Color, g *Gradient, sx, sy float64) {
+	layer := gg.NewContext(dc.Width(), dc.Height())
+	layer.Scale(sx, sy)
+	layer.Translate(0.5, 0.5)
+	layer.SetLineJoin(model.StrokeJoin.lineJoin())
+	if g != nil {
+		p := gradientPattern(g, sx, sy)
+		layer.SetFillStyle(p)
+		layer.SetStrokeStyle(p)
+	} else {
+		layer.SetRGBA255(c.R, c.G, c.B, c.A)
+	}
+	shape.Draw(layer, (sx+sy)/2)
+	layer.Fill()
+	im := layer.Image().(*image.RGBA)
+
+	bounds := opaqueBounds(im)
+	if bounds.Empty() {
+		return
+	}
+	radius := int(math.Round(model.EdgeFeather * (sx + sy) / 2))
+	if radius > 0 {
+		bounds = bounds.Inset(-radius * 3).Intersect(im.Rect)
+		// the layer is premultiplied, so blurring it fades the alpha at
+		// the edges and leaves the color as it was
+		for i := 0; i < 3; i++ {
+			boxBlur(im, bounds, radius)
+		}
+	}
+	draw.Draw(dc.Image().(*image.RGBA), bounds, im, bounds.Min, draw.Over)
+}
+
+// featherRadius returns the blur radius, in working pixels, that the
+// search feathers shapes by, or zero if it does not.
+func (model *Model) featherRadius() int {
+	if !model.FeatherSearch || !model.featherEnabled() {
+		return 0
+	}
+	return int(math.Round(model.EdgeFeather))
+}
+
+// shapeLines returns the scanlines a shape is drawn with on the canvas:
+// its rasterization, feathered if the search feathers.
+func (model *Model) shapeLines(shape Shape) []Scanline {
+	lines := shape.Rasterize()
+	if r := model.featherRadius(); r > 0 {
+		size := model.Target.Bounds().Size()
+		return featherLines(lines, r, size.X, size.Y)
+	}
+	return lines
+}
+
+// featherLines returns lines with their coverage blurred, as drawFeathered
+// blurs the render, by three box blurs of the given radius, clipped to a w
+// x h image. Each run of pixels of equal coverage becomes a scanline.
+func featherLines(lines []Scanline, radius, w, h int) []Scanline {
+	r := scanlineBounds(lines).Inset(-radius * 3).Intersect(image.Rect(0, 0, w, h))
+	if r.Empty() {
+		return nil
+	}
+	bw, bh := r.Dx(), r.Dy()
+	cover := make([]int, bw*bh)
+	for _, line := range lines {
+		i := (line.Y-r.Min.Y)*bw + line.X1 - r.Min.X
+		for x := line.X1; x <= line.X2; x++ {
+			cover[i] = int(line.Alpha)
+			i++
+		}
+	}
+	for i := 0; i < 3; i++ {
+		blurCoverage(cover, bw, bh, radius)
+	}
+	var result []Scanline
+	for y := 0; y < bh; y++ {
+		row := cover[y*bw : (y+1)*bw]
+		for x := 0; x < bw; {
+			a := row[x]
+			end := x + 1
+			for end < bw && row[end] == a {
+				end++
+			}
+			if a > 0 {
+				result = append(result, Scanline{r.Min.Y + y, r.Min.X + x, r.Min.X + end - 1, uint32(a)})
+			}
+			x = end
+		}
+	}
+	return result
+}
+
+// blurCoverage box blurs a w x h grid of coverage in place, horizontally
+// then vertically. Values outside the grid count as zero.
+func blurCoverage(cover []int, w, h, radius int) {
+	// dividing by the window is done as a fixed point multiply, rounding
+	// down so that full coverage stays at most 0xffff
+	scale := (1 << 24) / (2*radius + 1)
+	line := make([]int, w)
+	for y := 0; y < h; y++ {
+		row := cover[y*w : (y+1)*w]
+		copy(line, row)
+		sum := 0
+		for x := 0; x < radius && x < w; x++ {
+			sum += line[x]
+		}
+		for x := range row {
+			if a := x + radius; a < w {
+				sum += line[a]
+			}
+			if b := x - radius - 1; b >= 0 {
+				sum -= line[b]
+			}
+			row[x] = sum * scale >> 24
+		}
+	}
+	// the vertical pass keeps a running sum per column and a copy of the
+	// rows it has overwritten but still needs, so it reads row by row
+	sums := make([]int, w)
+	for y := 0; y < radius && y < h; y++ {
+		for x, v := range cover[y*w : (y+1)*w] {
+			sums[x] += v
+		}
+	}
+	old := make([]int, (radius+1)*w)
+	for y := 0; y < h; y++ {
+		row := cover[y*w : (y+1)*w]
+		saved := old[(y%(radius+1))*w:][:w]
+		if a := y + radius; a < h {
+			for x, v := range cover[a*w : (a+1)*w] {
+				sums[x] += v
+			}
+		}
+		if b := y - radius - 1; b >= 0 {
+			for x, v := range old[(b%(radius+1))*w:][:w] {
+				sums[x] -= v
+			}
+		}
+		copy(saved, row)
+		for x := range row {
+			row[x] = sums[x] * scale >> 24
+		}
+	}
+}
+
+// svgFeatherFilter returns the filter definition that feathers shapes in
+// SVG output, followed by the drop shadow if there is one.
+func (model *Model) svgFeatherFilter() string {
+	shadow := ""
+	if model.shadowEnabled() {
+		s := model.ShadowColor
+		shadow = fmt.Sprintf("<feDropShadow dx=\"%f\" dy=\"%f\" stdDeviation=\"%f\" flood-color=\"#%02x%02x%02x\" flood-opacity=\"%f\" />",
+			model.ShadowOffset.X, model.ShadowOffset.Y, model.ShadowBlur, s.R, s.G, s.B, float64(s.A)/255)
+	}
+	return fmt.Sprintf("<defs><filter id=\"feather\" x=\"-50%%\" y=\"-50%%\" width=\"200%%\" height=\"200%%\">"+
+		"<feGaussianBlur stdDeviation=\"%f\" />%s</filter></defs>", model.EdgeFeather, shadow)
+}
@@ -0,0 +1,17 @@
+package primitive
+
+// canvasFixedColor returns FixedColor as it is drawn on the canvas, or nil
+// if shape colors are fitted.
+func (model *Model) canvasFixedColor() *Color {
+	if model.FixedColor == nil {
+		return nil
+	}
+	c := model.canvasColor(*model.FixedColor)
+	return &c
+}
+
+// withAlpha returns c at alpha.
+func (c Color) withAlpha(alpha int) Color {
+	c.A = alpha
+	return c
+}
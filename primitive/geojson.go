@@ -0,0 +1,185 @@
+package primitive
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+)
+
+const (
+	// geoJSONEllipseVertices is how many vertices approximate an ellipse
+	// or circle.
+	geoJSONEllipseVertices = 64
+	// geoJSONCurveSegments is how many line segments approximate a
+	// quadratic.
+	geoJSONCurveSegments = 32
+)
+
+// geoJSONCollection is a GeoJSON FeatureCollection. Width, Height and
+// Background are foreign members, giving the space the coordinates are in.
+type geoJSONCollection struct {
+	Type       string           `json:"type"`
+	Width      int              `json:"width"`
+	Height     int              `json:"height"`
+	Background string           `json:"background"`
+	Features   []geoJSONFeature `json:"features"`
+}
+
+type geoJSONFeature struct {
+	Type       string                 `json:"type"`
+	Geometry   geoJSONGeometry        `json:"geometry"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+type geoJSONGeometry struct {
+	Type        string      `json:"type"`
+	Coordinates interface{} `json:"coordinates"`
+}
+
+// GeoJSON returns the shapes as a GeoJSON FeatureCollection for GIS and CAD
+// tools, one Feature per shape in the order they are drawn. Coordinates are
+// in the target's pixels, as in ShapeList, with x to the right and y down,
+// from 0 at the top left corner of the image to its width and height at the
+// bottom right, which the collection gives as its width and height.
+// Triangles, rectangles and polygons are Polygons with their own vertices,
+// ellipses and circles Polygons of 64, and quadratics, which are stroked
+// curves, LineStrings of 33. Rings are closed and wound counterclockwise
+// with y taken as up, as RFC 7946 asks. Each Feature's properties are its
+// index, type and paint, named as in the simplestyle spec: fill and
+// fill-opacity for Polygons, and stroke, stroke-opacity and stroke-width
+// for LineStrings. Gradient fills are given as their mean color.
+func (model *Model) GeoJSON() ([]byte, error) {
+	size := model.Target.Bounds().Size()
+	bg := model.Background
+	collection := geoJSONCollection{
+		Type:       "FeatureCollection",
+		Width:      size.X,
+		Height:     size.Y,
+		Background: fmt.Sprintf("#%02x%02x%02x", bg.R, bg.G, bg.B),
+		Features:   make([]geoJSONFeature, 0, len(model.Shapes)),
+	}
+	for _, i := range model.drawOrder() {
+		c, _ := model.shapeFill(i)
+		feature, err := geoJSONShape(model.Shapes[i], c)
+		if err != nil {
+			return nil, err
+		}
+		feature.Properties["index"] = i
+		feature.Properties["type"] = shapeTypeOf(model.Shapes[i]).String()
+		collection.Features = append(collection.Features, feature)
+	}
+	return json.Marshal(collection)
+}
+
+// geoJSONShape returns a shape as a Feature with its geometry and paint.
+func geoJSONShape(shape Shape, c Color) (geoJSONFeature, error) {
+	var points [][2]float64
+	switch s := shape.(type) {
+	case *Triangle:
+		points = [][2]float64{
+			{float64(s.X1), float64(s.Y1)}, {float64(s.X2), float64(s.Y2)}, {float64(s.X3), float64(s.Y3)},
+		}
+	case *Rectangle:
+		x1, y1, x2, y2 := s.bounds()
+		l, t, r, b := float64(x1), float64(y1), float64(x2+1), float64(y2+1)
+		points = [][2]float64{{l, t}, {r, t}, {r, b}, {l, b}}
+	case *Ellipse:
+		points = ellipsePoints(float64(s.X), float64(s.Y), float64(s.Rx), float64(s.Ry), 0)
+	case *RotatedEllipse:
+		points = ellipsePoints(s.X, s.Y, s.Rx, s.Ry, s.Angle)
+	case *RotatedRectangle:
+		sx, sy := float64(s.Sx)/2, float64(s.Sy)/2
+		a := radians(float64(s.Angle))
+		for _, p := range [][2]float64{{-sx, -sy}, {sx, -sy}, {sx, sy}, {-sx, sy}} {
+			x, y := rotate(p[0], p[1], a)
+			points = append(points, [2]float64{float64(s.X) + x, float64(s.Y) + y})
+		}
+	case *Polygon:
+		x, y := s.outline()
+		for i := range x {
+			points = append(points, [2]float64{x[i], y[i]})
+		}
+	case *Quadratic:
+		for i := 0; i <= geoJSONCurveSegments; i++ {
+			t := float64(i) / geoJSONCurveSegments
+			u := 1 - t
+			points = append(points, [2]float64{
+				u*u*s.X1 + 2*u*t*s.X2 + t*t*s.X3,
+				u*u*s.Y1 + 2*u*t*s.Y2 + t*t*s.Y3,
+			})
+		}
+		return geoJSONFeature{
+			Type:     "Feature",
+			Geometry: geoJSONGeometry{"LineString", geoJSONCoordinates(points)},
+			Properties: map[string]interface{}{
+				"stroke":         fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B),
+				"stroke-opacity": float64(c.A) / 255,
+				"stroke-width":   s.Width,
+			},
+		}, nil
+	default:
+		return geoJSONFeature{}, fmt.Errorf("geojson: unsupported shape %T", shape)
+	}
+	// the ring winds counterclockwise, y up, when its signed area is
+	// positive
+	var area float64
+	for i, p := range points {
+		q := points[(i+1)%len(points)]
+		area += p[0]*q[1] - q[0]*p[1]
+	}
+	if area < 0 {
+		for i, j := 0, len(points)-1; i < j; i, j = i+1, j-1 {
+			points[i], points[j] = points[j], points[i]
+		}
+	}
+	ring := geoJSONCoordinates(append(points, points[0]))
+	return geoJSONFeature{
+		Type:     "Feature",
+		Geometry: geoJSONGeometry{"Polygon", [][][2]float64{ring}},
+		Properties: map[string]interface{}{
+			"fill":         fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B),
+			"fill-opacity": float64(c.A) / 255,
+		},
+	}, nil
+}
+
+// ellipsePoints returns geoJSONEllipseVertices points around an ellipse
+// centered at x, y rotated by angle degrees.
+func ellipsePoints(x, y, rx, ry, angle float64) [][2]float64 {
+	a := radians(angle)
+	points := make([][2]float64, geoJSONEllipseVertices)
+	for i := range points {
+		t := 2 * math.Pi * float64(i) / geoJSONEllipseVertices
+		dx, dy := rotate(rx*math.Cos(t), ry*math.Sin(t), a)
+		points[i] = [2]float64{x + dx, y + dy}
+	}
+	return points
+}
+
+// geoJSONCoordinates moves working coordinates, where shapes are drawn
+// offset by half a pixel, to image coordinates, rounded to a thousandth of
+// a pixel.
+func geoJSONCoordinates(points [][2]float64) [][2]float64 {
+	result := make([][2]float64, len(points))
+	for i, p := range points {
+		result[i] = [2]float64{math.Round((p[0]+0.5)*1000) / 1000, math.Round((p[1]+0.5)*1000) / 1000}
+	}
+	return result
+}
+
+// GeoJSONEncoder writes the shapes as GeoJSON.
+type GeoJSONEncoder struct{}
+
+func (e GeoJSONEncoder) Encode(w io.Writer, m *Model) error {
+	data, err := m.GeoJSON()
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+func (e GeoJSONEncoder) ContentType() string {
+	return "application/geo+json"
+}
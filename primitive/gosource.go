@@ -0,0 +1,57 @@
+package primitive
+
+import (
+	"fmt"
+	"go/format"
+	"go/token"
+	"strconv"
+	"strings"
+)
+
+// GoSource returns Go source declaring varName as a *primitive.ShapeList
+// literal of the model's shapes, for embedding a fixed render in a program
+// without shipping JSON. The generated doc comment shows how to draw it:
+// AddShapeList on a model of a blank target of the list's size, which keeps
+// the stored colors, as Add would fit new ones to the target. A varName
+// that is not a Go identifier is replaced by "shapes".
+func (model *Model) GoSource(varName string) string {
+	if !token.IsIdentifier(varName) {
+		varName = "shapes"
+	}
+	list := model.ShapeList()
+	var b strings.Builder
+	fmt.Fprintf(&b, "// %s holds %d shapes for a %dx%d canvas, generated by primitive's\n", varName, len(list.Shapes), list.Width, list.Height)
+	fmt.Fprintf(&b, "// Model.GoSource. Draw it with:\n//\n")
+	fmt.Fprintf(&b, "//\ttarget := image.NewRGBA(image.Rect(0, 0, %d, %d))\n", list.Width, list.Height)
+	fmt.Fprintf(&b, "//\tmodel := primitive.NewModel(target, primitive.MakeHexColor(%s.Background), %d, 1)\n", varName, maxInt(list.Width, list.Height))
+	fmt.Fprintf(&b, "//\tmodel.AddShapeList(%s)\n", varName)
+	fmt.Fprintf(&b, "//\tim := model.Render()\n")
+	fmt.Fprintf(&b, "var %s = &primitive.ShapeList{\n", varName)
+	fmt.Fprintf(&b, "Width: %d, Height: %d, Background: %q,\n", list.Width, list.Height, list.Background)
+	b.WriteString("Shapes: []primitive.ShapeRecord{\n")
+	for _, r := range list.Shapes {
+		fmt.Fprintf(&b, "{Type: %q, Color: %q, Params: []float64{%s}", r.Type, r.Color, goFloats(r.Params...))
+		if g := r.Gradient; g != nil {
+			fmt.Fprintf(&b, ", Gradient: &primitive.GradientRecord{X0: %s, Y0: %s, X1: %s, Y1: %s, From: %q, To: %q}",
+				goFloats(g.X0), goFloats(g.Y0), goFloats(g.X1), goFloats(g.Y1), g.From, g.To)
+		}
+		b.WriteString("},\n")
+	}
+	b.WriteString("},\n}\n")
+	src, err := format.Source([]byte(b.String()))
+	if err != nil {
+		// the source is built to be valid, so this only skips formatting
+		return b.String()
+	}
+	return string(src)
+}
+
+// goFloats formats values as a comma separated list of Go literals that
+// parse back to exactly the same values.
+func goFloats(values ...float64) string {
+	s := make([]string, len(values))
+	for i, v := range values {
+		s[i] = strconv.FormatFloat(v, 'g', -1, 64)
+	}
+	return strings.Join(s, ", ")
+}
@@ -0,0 +1,217 @@
+package primitive
+
+import (
+	"fmt"
+	"image"
+	"math"
+
+	"github.com/fogleman/gg"
+)
+
+// gradientMinArea is the number of pixels a shape must cover before it is
+// given a gradient fill. Smaller shapes gain little from one, and a fit over
+// a handful of pixels is noisy.
+const gradientMinArea = 256
+
+// A Gradient is a two-stop linear gradient fill. It runs from From at
+// (X0, Y0) to To at (X1, Y1), in working coordinates, and is constant beyond
+// either end.
+type Gradient struct {
+	X0, Y0, X1, Y1 float64
+	From, To       Color
+}
+
+// at returns the gradient's color at a point.
+func (g *Gradient) at(x, y float64) Color {
+	dx, dy := g.X1-g.X0, g.Y1-g.Y0
+	t := ((x-g.X0)*dx + (y-g.Y0)*dy) / (dx*dx + dy*dy)
+	t = clamp(t, 0, 1)
+	lerp := func(a, b int) int {
+		return int(float64(a) + float64(b-a)*t + 0.5)
+	}
+	return Color{lerp(g.From.R, g.To.R), lerp(g.From.G, g.To.G), lerp(g.From.B, g.To.B), lerp(g.From.A, g.To.A)}
+}
+
+// mean returns the color halfway along the gradient, which stands in for
+// it where only a solid color can be used.
+func (g *Gradient) mean() Color {
+	return g.at((g.X0+g.X1)/2, (g.Y0+g.Y1)/2)
+}
+
+func linesArea(lines []Scanline) int {
+	area := 0
+	for _, line := range lines {
+		area += line.X2 - line.X1 + 1
+	}
+	return area
+}
+
+// computeGradient fits a gradient to the covered pixels. Its direction is
+// that of the least squares plane through the luminance of the optimal
+// per-pixel colors, and its end colors are the least squares fit of each
+// channel along that direction. It returns nil when the region has no
+// usable direction, in which case a solid fill should be used.
+func computeGradient(target, current *image.RGBA, lines []Scanline, alpha int) *Gradient {
+	if alpha == 0 {
+		return nil
+	}
+	a := 255 / float64(alpha)
+	var n, sx, sy, sxx, sxy, syy float64
+	var sv, sxv, syv [3]float64
+	for _, line := range lines {
+		i := target.PixOffset(line.X1, line.Y)
+		y := float64(line.Y)
+		for px := line.X1; px <= line.X2; px++ {
+			x := float64(px)
+			n++
+			sx += x
+			sy += y
+			sxx += x * x
+			sxy += x * y
+			syy += y * y
+			for j := 0; j < 3; j++ {
+				t := float64(target.Pix[i+j])
+				d := float64(current.Pix[i+j])
+				// the source color that would make this pixel exact
+				v := d + (t-d)*a
+				sv[j] += v
+				sxv[j] += x * v
+				syv[j] += y * v
+			}
+			i += 4
+		}
+	}
+	if n < 2 {
+		return nil
+	}
+	mx, my := sx/n, sy/n
+	cxx := sxx - n*mx*mx
+	cxy := sxy - n*mx*my
+	cyy := syy - n*my*my
+	var cxv, cyv, mv [3]float64
+	for j := 0; j < 3; j++ {
+		mv[j] = sv[j] / n
+		cxv[j] = sxv[j] - n*mx*mv[j]
+		cyv[j] = syv[j] - n*my*mv[j]
+	}
+
+	// plane through the luminance
+	det := cxx*cyy - cxy*cxy
+	if det < 1e-9 {
+		return nil
+	}
+	lum := [3]float64{0.299, 0.587, 0.114}
+	var cxl, cyl float64
+	for j := 0; j < 3; j++ {
+		cxl += lum[j] * cxv[j]
+		cyl += lum[j] * cyv[j]
+	}
+	gx := (cyy*cxl - cxy*cyl) / det
+	gy := (cxx*cyl - cxy*cxl) / det
+	norm := math.Hypot(gx, gy)
+	if norm < 1e-6 {
+		return nil
+	}
+	ux, uy := gx/norm, gy/norm
+
+	// extent of the region along the direction; u is linear in x and y so
+	// the extremes are at the ends of the scanlines
+	umin, umax := math.Inf(1), math.Inf(-1)
+	for _, line := range lines {
+		for _, x := range [2]int{line.X1, line.X2} {
+			u := (float64(x)-mx)*ux + (float64(line.Y)-my)*uy
+			umin = math.Min(umin, u)
+			umax = math.Max(umax, u)
+		}
+	}
+	if umax-umin < 1 {
+		return nil
+	}
+
+	// fit each channel along u
+	cuu := ux*ux*cxx + 2*ux*uy*cxy + uy*uy*cyy
+	var from, to [3]int
+	for j := 0; j < 3; j++ {
+		k := (ux*cxv[j] + uy*cyv[j]) / cuu
+		from[j] = clampInt(int(mv[j]+k*umin+0.5), 0, 255)
+		to[j] = clampInt(int(mv[j]+k*umax+0.5), 0, 255)
+	}
+	return &Gradient{
+		X0: mx + umin*ux, Y0: my + umin*uy,
+		X1: mx + umax*ux, Y1: my + umax*uy,
+		From: Color{from[0], from[1], from[2], alpha},
+		To:   Color{to[0], to[1], to[2], alpha},
+	}
+}
+
+// drawLinesGradient is drawLines with a gradient fill.
+func drawLinesGradient(im *image.RGBA, g *Gradient, lines []Scanline) {
+	const m = 0xffff
+	for _, line := range lines {
+		ma := line.Alpha
+		i := im.PixOffset(line.X1, line.Y)
+		for x := line.X1; x <= line.X2; x++ {
+			c := g.at(float64(x), float64(line.Y))
+			sr, sg, sb, sa := c.NRGBA().RGBA()
+			a := (m - sa*ma/m) * 0x101
+			dr := uint32(im.Pix[i+0])
+			dg := uint32(im.Pix[i+1])
+			db := uint32(im.Pix[i+2])
+			da := uint32(im.Pix[i+3])
+			im.Pix[i+0] = uint8((dr*a + sr*ma) / m >> 8)
+			im.Pix[i+1] = uint8((dg*a + sg*ma) / m >> 8)
+			im.Pix[i+2] = uint8((db*a + sb*ma) / m >> 8)
+			im.Pix[i+3] = uint8((da*a + sa*ma) / m >> 8)
+			i += 4
+		}
+	}
+}
+
+// fitFill picks the fill for a shape: a gradient when gradients are enabled
+// and the shape is large enough, otherwise a solid color, fitted with the
+// pixels weighted by their coverage if coverage is set, or with joint set
+// and normal blending, fitted with its alpha by computeColorAlpha in place of
+// alpha. The color is always set; for a gradient it is the gradient's mean.
+func fitFill(target, current *image.RGBA, lines []Scanline, alpha int, mode BlendMode, gradients, coverage, joint bool) (Color, *Gradient) {
+	if gradients && mode == BlendNormal && linesArea(lines) >= gradientMinArea {
+		if g := computeGradient(target, current, lines, alpha); g != nil {
+			return g.mean(), g
+		}
+	}
+	if joint && mode == BlendNormal {
+		return computeColorAlpha(target, current, lines), nil
+	}
+	if coverage && mode == BlendNormal {
+		return computeColorCoverage(target, current, lines, alpha), nil
+	}
+	return computeColorBlend(target, current, lines, alpha, mode), nil
+}
+
+// drawFill draws lines with a fill chosen by fitFill.
+func drawFill(im *image.RGBA, c Color, g *Gradient, lines []Scanline, mode BlendMode) {
+	if g != nil {
+		drawLinesGradient(im, g, lines)
+		return
+	}
+	drawLinesBlend(im, c, lines, mode)
+}
+
+// gradientPattern returns g as a gg pattern for a context whose transform
+// scales working coordinates by sx, sy. gg evaluates patterns in device
+// space, so the end points are transformed here.
+func gradientPattern(g *Gradient, sx, sy float64) gg.Gradient {
+	p := gg.NewLinearGradient((g.X0+0.5)*sx, (g.Y0+0.5)*sy, (g.X1+0.5)*sx, (g.Y1+0.5)*sy)
+	p.AddColorStop(0, g.From.NRGBA())
+	p.AddColorStop(1, g.To.NRGBA())
+	return p
+}
+
+// svgGradient returns a linearGradient definition for g.
+func svgGradient(id string, g *Gradient) string {
+	stop := func(offset int, c Color) string {
+		return fmt.Sprintf("<stop offset=\"%d\" stop-color=\"#%02x%02x%02x\" stop-opacity=\"%f\" />",
+			offset, c.R, c.G, c.B, float64(c.A)/255)
+	}
+	return fmt.Sprintf("<defs><linearGradient id=\"%s\" gradientUnits=\"userSpaceOnUse\" x1=\"%f\" y1=\"%f\" x2=\"%f\" y2=\"%f\">%s%s</linearGradient></defs>",
+		id, g.X0, g.Y0, g.X1, g.Y1, stop(0, g.From), stop(1, g.To))
+}
@@ -0,0 +1,116 @@
+package primitive
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/fogleman/gg"
+)
+
+// Halftone draws the output's shapes as grids of dots, for a comic or print
+// look, in Render and the SVG output. The dots sit on one screen of cells
+// cellSize working pixels across, turned by angle degrees, and a shape gets
+// a dot at each cell center it covers, in its color, whose area is the
+// share of the cell its darkness asks for, so a black shape covers its
+// cells and a white one leaves them empty. Gradient fills are dotted in
+// their mean color. Wireframe takes precedence, shadows, feathering and
+// blend modes do not apply to the dots, and quadratics, already strokes,
+// are drawn as they are. It has no effect on the search. A cellSize of zero
+// or less turns it off.
+func (model *Model) Halftone(cellSize int, angle float64) {
+	model.halftoneCell = maxInt(cellSize, 0)
+	model.halftoneAngle = angle
+}
+
+// halftoneEnabled reports whether Halftone is in effect, which Wireframe
+// overrides.
+func (model *Model) halftoneEnabled() bool {
+	return model.halftoneCell > 0 && !model.Wireframe
+}
+
+// halftoneDots returns the centers and radius, in working coordinates, of
+// the dots standing in for a shape of color c, or false for shapes that are
+// drawn as they are.
+func (model *Model) halftoneDots(shape Shape, c Color) ([]gg.Point, float64, bool) {
+	if _, ok := shape.(*Quadratic); ok {
+		return nil, 0, false
+	}
+	cell := float64(model.halftoneCell)
+	luma := (0.299*float64(c.R) + 0.587*float64(c.G) + 0.114*float64(c.B)) / 255
+	// a dot covers the darkness of its cell: pi r^2 = (1 - luma) cell^2
+	r := cell * math.Sqrt(math.Max(1-luma, 0)/math.Pi)
+	if r <= 0 {
+		return nil, 0, true
+	}
+	lines := shape.Rasterize()
+	if len(lines) == 0 {
+		return nil, r, true
+	}
+	bounds := scanlineBounds(lines)
+	covered := make([]bool, bounds.Dx()*bounds.Dy())
+	for _, line := range lines {
+		for x := line.X1; x <= line.X2; x++ {
+			covered[(line.Y-bounds.Min.Y)*bounds.Dx()+x-bounds.Min.X] = true
+		}
+	}
+
+	// the screen's cells, in its own turned frame, that the bounds reach
+	a := radians(model.halftoneAngle)
+	u0, v0 := math.Inf(1), math.Inf(1)
+	u1, v1 := math.Inf(-1), math.Inf(-1)
+	for _, p := range [][2]int{
+		{bounds.Min.X, bounds.Min.Y}, {bounds.Max.X, bounds.Min.Y},
+		{bounds.Min.X, bounds.Max.Y}, {bounds.Max.X, bounds.Max.Y},
+	} {
+		u, v := rotate(float64(p[0]), float64(p[1]), -a)
+		u0, v0 = math.Min(u0, u), math.Min(v0, v)
+		u1, v1 = math.Max(u1, u), math.Max(v1, v)
+	}
+	var dots []gg.Point
+	for j := math.Floor(v0/cell - 0.5); j <= math.Ceil(v1/cell); j++ {
+		for i := math.Floor(u0/cell - 0.5); i <= math.Ceil(u1/cell); i++ {
+			x, y := rotate((i+0.5)*cell, (j+0.5)*cell, a)
+			px, py := int(math.Round(x)), int(math.Round(y))
+			if px < bounds.Min.X || py < bounds.Min.Y || px >= bounds.Max.X || py >= bounds.Max.Y {
+				continue
+			}
+			if covered[(py-bounds.Min.Y)*bounds.Dx()+px-bounds.Min.X] {
+				dots = append(dots, gg.Point{X: x, Y: y})
+			}
+		}
+	}
+	return dots, r, true
+}
+
+// drawHalftone draws the dots of a shape of color c onto dc, and
+// reports false for shapes that are drawn as they are. The dots are filled
+// as one path, so where they overlap they are not painted twice.
+func (model *Model) drawHalftone(dc *gg.Context, shape Shape, c Color) bool {
+	dots, r, ok := model.halftoneDots(shape, c)
+	if !ok {
+		return false
+	}
+	for _, p := range dots {
+		dc.NewSubPath()
+		dc.DrawCircle(p.X, p.Y, r)
+	}
+	c = model.canvasColor(c)
+	dc.SetRGBA255(c.R, c.G, c.B, c.A)
+	dc.Fill()
+	return true
+}
+
+// svgHalftone returns a shape's dots as circles in a group of color c, and
+// false for shapes that are drawn as they are. The group carries the
+// opacity, so overlapping dots are not painted twice.
+func (model *Model) svgHalftone(shape Shape, c Color) ([]string, bool) {
+	dots, r, ok := model.halftoneDots(shape, c)
+	if !ok {
+		return nil, false
+	}
+	lines := []string{fmt.Sprintf("<g fill=\"#%02x%02x%02x\" opacity=\"%f\">", c.R, c.G, c.B, float64(c.A)/255)}
+	for _, p := range dots {
+		lines = append(lines, fmt.Sprintf("<circle cx=\"%f\" cy=\"%f\" r=\"%f\" />", p.X, p.Y, r))
+	}
+	return append(lines, "</g>"), true
+}
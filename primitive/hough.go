@@ -0,0 +1,275 @@
+package primitive
+
+import (
+	"math"
+	"sort"
+)
+
+const (
+	// houghEdgeFraction is the share of the target's pixels, those with the
+	// strongest gradients, that vote for lines.
+	houghEdgeFraction = 0.1
+	// houghAngles is the number of line angles over half a turn.
+	houghAngles = 180
+	// houghSpread is how many angle steps either side of its gradient's
+	// direction an edge pixel votes for.
+	houghSpread = 2
+	// houghMinLength is the shortest segment kept, as a fraction of the
+	// target's longer side.
+	houghMinLength = 1.0 / 16
+	// houghGap is the longest run of pixels without an edge that a segment
+	// bridges.
+	houghGap = 3
+	// houghMaxSegments caps the segments kept, the longest first.
+	houghMaxSegments = 64
+	// edgeSeedProb is the share of new rectangles and curves that start on
+	// a detected edge under EdgeGuidedInit.
+	edgeSeedProb = 0.5
+)
+
+// An edgeSegment is a straight edge found in the target, from x1, y1 to
+// x2, y2 in working coordinates.
+type edgeSegment struct {
+	x1, y1, x2, y2 float64
+}
+
+// edgeGuides returns the segments new shapes are seeded on, nil unless
+// EdgeGuidedInit is set.
+func (model *Model) edgeGuides() []edgeSegment {
+	if !model.EdgeGuidedInit {
+		return nil
+	}
+	if !model.edgesDetected {
+		model.edges = houghSegments(model.targetLuminance(), model.Target.Rect.Dx(), model.Target.Rect.Dy())
+		model.edgesDetected = true
+	}
+	return model.edges
+}
+
+// targetLuminance returns the Rec. 601 luma of the target's pixels, row by
+// row.
+func (model *Model) targetLuminance() []float64 {
+	im := model.Target
+	lum := make([]float64, im.Rect.Dx()*im.Rect.Dy())
+	for i := range lum {
+		p := im.Pix[i*4 : i*4+3]
+		lum[i] = 0.299*float64(p[0]) + 0.587*float64(p[1]) + 0.114*float64(p[2])
+	}
+	return lum
+}
+
+// houghSegments finds the straight edges of a w x h luminance image: the
+// pixels with the strongest Sobel gradients vote, in a Hough transform, for
+// the lines through them across their gradient, and each of the strongest
+// lines is followed through the image to cut it into the runs of edge
+// pixels along it. It returns up to houghMaxSegments of them, longest first.
+func houghSegments(lum []float64, w, h int) []edgeSegment {
+	if w < 3 || h < 3 {
+		return nil
+	}
+	magnitudes := make([]float64, w*h)
+	angles := make([]float64, w*h)
+	for y := 1; y < h-1; y++ {
+		for x := 1; x < w-1; x++ {
+			at := func(dx, dy int) float64 { return lum[(y+dy)*w+x+dx] }
+			gx := at(1, -1) + 2*at(1, 0) + at(1, 1) - at(-1, -1) - 2*at(-1, 0) - at(-1, 1)
+			gy := at(-1, 1) + 2*at(0, 1) + at(1, 1) - at(-1, -1) - 2*at(0, -1) - at(1, -1)
+			magnitudes[y*w+x] = math.Hypot(gx, gy)
+			angles[y*w+x] = math.Atan2(gy, gx)
+		}
+	}
+	sorted := append([]float64(nil), magnitudes...)
+	sort.Float64s(sorted)
+	threshold := sorted[int(float64(len(sorted)-1)*(1-houghEdgeFraction))]
+	if threshold <= 0 {
+		return nil
+	}
+	edge := make([]bool, w*h)
+	for i, m := range magnitudes {
+		edge[i] = m >= threshold
+	}
+
+	// lines are x cos(theta) + y sin(theta) = rho, theta in [0, pi)
+	diagonal := int(math.Ceil(math.Hypot(float64(w), float64(h))))
+	rhos := 2*diagonal + 1
+	votes := make([]int, houghAngles*rhos)
+	var cos, sin [houghAngles]float64
+	for k := range cos {
+		theta := math.Pi * float64(k) / houghAngles
+		cos[k], sin[k] = math.Cos(theta), math.Sin(theta)
+	}
+	for i, on := range edge {
+		if !on {
+			continue
+		}
+		x, y := float64(i%w), float64(i/w)
+		center := int(math.Round(angles[i] / math.Pi * houghAngles))
+		for d := -houghSpread; d <= houghSpread; d++ {
+			k := ((center+d)%houghAngles + houghAngles) % houghAngles
+			rho := int(math.Round(x*cos[k]+y*sin[k])) + diagonal
+			votes[k*rhos+rho]++
+		}
+	}
+
+	minLength := math.Max(houghMinLength*float64(maxInt(w, h)), 4)
+	type peak struct{ k, rho, votes int }
+	var peaks []peak
+	for k := 0; k < houghAngles; k++ {
+		for rho := 0; rho < rhos; rho++ {
+			v := votes[k*rhos+rho]
+			if float64(v) < minLength {
+				continue
+			}
+			// keep local maxima only, so one edge gives one line
+			best := true
+			for dk := -3; dk <= 3 && best; dk++ {
+				for dr := -3; dr <= 3; dr++ {
+					kk, rr := k+dk, rho+dr
+					if kk < 0 || kk >= houghAngles || rr < 0 || rr >= rhos || (dk == 0 && dr == 0) {
+						continue
+					}
+					if u := votes[kk*rhos+rr]; u > v || (u == v && kk*rhos+rr < k*rhos+rho) {
+						best = false
+						break
+					}
+				}
+			}
+			if best {
+				peaks = append(peaks, peak{k, rho, v})
+			}
+		}
+	}
+	sort.Slice(peaks, func(a, b int) bool { return peaks[a].votes > peaks[b].votes })
+	if len(peaks) > houghMaxSegments {
+		peaks = peaks[:houghMaxSegments]
+	}
+
+	// near reports whether an edge pixel lies within a pixel of x, y
+	near := func(x, y int) bool {
+		for dy := -1; dy <= 1; dy++ {
+			for dx := -1; dx <= 1; dx++ {
+				if px, py := x+dx, y+dy; px >= 0 && py >= 0 && px < w && py < h && edge[py*w+px] {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	var segments []edgeSegment
+	for _, p := range peaks {
+		c, s := cos[p.k], sin[p.k]
+		rho := float64(p.rho - diagonal)
+		// walk along the line, from the foot of the normal through the
+		// origin, in both directions
+		ox, oy := rho*c, rho*s
+		start, last := math.NaN(), math.NaN()
+		emit := func() {
+			if !math.IsNaN(start) && last-start >= minLength {
+				segments = append(segments, edgeSegment{ox - start*s, oy + start*c, ox - last*s, oy + last*c})
+			}
+			start = math.NaN()
+		}
+		for t := -float64(diagonal); t <= float64(diagonal); t++ {
+			x, y := ox-t*s, oy+t*c
+			if !near(int(math.Round(x)), int(math.Round(y))) {
+				if !math.IsNaN(start) && t-last > houghGap {
+					emit()
+				}
+				continue
+			}
+			if math.IsNaN(start) {
+				start = t
+			}
+			last = t
+		}
+		emit()
+	}
+	sort.SliceStable(segments, func(a, b int) bool { return segments[a].length() > segments[b].length() })
+	if len(segments) > houghMaxSegments {
+		segments = segments[:houghMaxSegments]
+	}
+	return segments
+}
+
+func (s edgeSegment) length() float64 {
+	return math.Hypot(s.x2-s.x1, s.y2-s.y1)
+}
+
+// edgeShape returns a new shape of type t seeded on one of EdgeSegments,
+// or nil to start it at random. Rectangles, rotated rectangles and
+// quadratics are seeded, edgeSeedProb of the time: rectangles and rotated
+// rectangles lie along a stretch of the edge with one side on it, and
+// quadratics run straight along it. Axis aligned rectangles only seed on
+// edges within 10 degrees of horizontal or vertical, and nothing is seeded
+// on a grid or with FixedShapeSize, which set the sizes themselves.
+func (worker *Worker) edgeShape(t ShapeType) Shape {
+	if len(worker.EdgeSegments) == 0 || worker.gridded() || worker.FixedShapeSize > 0 {
+		return nil
+	}
+	if t != ShapeTypeRectangle && t != ShapeTypeRotatedRectangle && t != ShapeTypeQuadratic {
+		return nil
+	}
+	rnd := worker.Rnd
+	if rnd.Float64() >= edgeSeedProb {
+		return nil
+	}
+	s := worker.EdgeSegments[rnd.Intn(len(worker.EdgeSegments))]
+	// a stretch of a quarter of the segment or more
+	length := s.length()
+	stretch := length * (0.25 + 0.75*rnd.Float64())
+	u0 := rnd.Float64() * (length - stretch) / length
+	u1 := u0 + stretch/length
+	x1, y1 := s.x1+(s.x2-s.x1)*u0, s.y1+(s.y2-s.y1)*u0
+	x2, y2 := s.x1+(s.x2-s.x1)*u1, s.y1+(s.y2-s.y1)*u1
+	mx, my := (x1+x2)/2, (y1+y2)/2
+	r := worker.bounds()
+	if mx < float64(r.Min.X) || my < float64(r.Min.Y) || mx >= float64(r.Max.X) || my >= float64(r.Max.Y) {
+		return nil
+	}
+	// the shape's thickness, and the side of the edge it lies on
+	thickness := rnd.Intn(32) + 1
+	side := float64(rnd.Intn(2)*2 - 1)
+	angle := math.Atan2(y2-y1, x2-x1)
+
+	switch t {
+	case ShapeTypeQuadratic:
+		q := &Quadratic{worker, x1, y1, mx, my, x2, y2, 1.0 / 2}
+		if !q.Valid() {
+			return nil
+		}
+		return q
+	case ShapeTypeRotatedRectangle:
+		nx, ny := -math.Sin(angle), math.Cos(angle)
+		d := side * float64(thickness) / 2
+		sx, sy := worker.limitAspect(math.Max(stretch, 1), float64(thickness))
+		return &RotatedRectangle{
+			worker,
+			clampInt(int(math.Round(mx+nx*d)), 0, worker.W-1),
+			clampInt(int(math.Round(my+ny*d)), 0, worker.H-1),
+			clampInt(int(sx), 1, worker.W-1), clampInt(int(sy), 1, worker.H-1),
+			int(math.Round(degrees(angle))),
+		}
+	}
+	// axis aligned rectangles need an edge that is nearly so
+	lean := math.Mod(math.Abs(degrees(angle)), 90)
+	if lean > 10 && lean < 80 {
+		return nil
+	}
+	var rx1, ry1, rx2, ry2 int
+	if lean <= 10 && math.Abs(math.Cos(angle)) > 0.5 {
+		// horizontal: the rectangle sits above or below the edge
+		rx1, rx2 = int(math.Min(x1, x2)), int(math.Max(x1, x2))
+		ry1 = int(math.Round(my))
+		ry2 = ry1 + int(side)*thickness
+	} else {
+		rx1 = int(math.Round(mx))
+		rx2 = rx1 + int(side)*thickness
+		ry1, ry2 = int(math.Min(y1, y2)), int(math.Max(y1, y2))
+	}
+	rect := &Rectangle{worker,
+		clampInt(rx1, 0, worker.W-1), clampInt(ry1, 0, worker.H-1),
+		clampInt(rx2, 0, worker.W-1), clampInt(ry2, 0, worker.H-1),
+	}
+	rect.limitAspect()
+	return rect
+}
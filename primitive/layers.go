@@ -0,0 +1,75 @@
+package primitive
+
+import (
+	"fmt"
+	"image"
+
+	"github.com/fogleman/gg"
+)
+
+// LayerCount returns how many layers LayerImages makes with groupSize
+// shapes to a layer.
+func (model *Model) LayerCount(groupSize int) int {
+	groupSize = maxInt(groupSize, 1)
+	return (len(model.Shapes) + groupSize - 1) / groupSize
+}
+
+// LayerImages returns the shapes as transparent layers of groupSize shapes
+// each, in draw order, for compositing in other tools. Drawn in order over
+// the background color they rebuild Render's output, before quantization
+// and alpha; with blend modes other than BlendNormal they only approximate
+// it, since each layer blends with nothing beneath it. The layers are the
+// size Render's output is and honor RenderScale.
+func (model *Model) LayerImages(groupSize int) []image.Image {
+	layers := make([]image.Image, model.LayerCount(groupSize))
+	for k := range layers {
+		layers[k] = model.LayerImage(k, groupSize)
+	}
+	return layers
+}
+
+// LayerImage returns layer k of LayerImages, so that the layers can be
+// rendered one at a time.
+func (model *Model) LayerImage(k, groupSize int) image.Image {
+	groupSize = maxInt(groupSize, 1)
+	w, h := model.outputSize()
+	factor := maxInt(model.RenderScale, 1)
+	size := model.Target.Bounds().Size()
+	sx := float64(w*factor) / float64(size.X)
+	sy := float64(h*factor) / float64(size.Y)
+	dc := gg.NewContext(w*factor, h*factor)
+	dc.Scale(sx, sy)
+	dc.Translate(0.5, 0.5)
+	order := model.drawOrder()
+	for _, i := range order[minInt(k*groupSize, len(order)):minInt((k+1)*groupSize, len(order))] {
+		c, g := model.shapeFill(i)
+		model.drawShape(dc, model.Shapes[i], c, g, sx, sy)
+	}
+	im := dc.Image().(*image.RGBA)
+	if factor == 1 {
+		return model.outputImage(im)
+	}
+	return model.outputImage(downsampleRGBA(im, factor))
+}
+
+// ShapeMask returns an alpha mask of where shape index draws on the working
+// canvas, from the scanlines the canvas was drawn with, at the target's
+// size: opaque where the shape covers a pixel fully, partly transparent at
+// antialiased or feathered edges. It is for highlighting one shape, say on
+// hover in an editor, and leaves the model unchanged. It returns an error
+// if index is out of range.
+func (model *Model) ShapeMask(index int) (image.Image, error) {
+	if index < 0 || index >= len(model.Shapes) {
+		return nil, fmt.Errorf("shape mask: index %d out of range, the model has %d shapes", index, len(model.Shapes))
+	}
+	mask := image.NewAlpha(model.Target.Bounds())
+	for _, line := range model.shapeLines(model.Shapes[index]) {
+		i := mask.PixOffset(line.X1, line.Y)
+		a := uint8(line.Alpha >> 8)
+		for x := line.X1; x <= line.X2; x++ {
+			mask.Pix[i] = a
+			i++
+		}
+	}
+	return mask, nil
+}
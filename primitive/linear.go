@@ -0,0 +1,120 @@
+package primitive
+
+import (
+	"image"
+	"math"
+)
+
+// srgbToLinear and linearToSRGB convert 8 bit channel values between sRGB
+// and linear light.
+var srgbToLinear, linearToSRGB [256]uint8
+
+func init() {
+	for i := range srgbToLinear {
+		v := float64(i) / 255
+		srgbToLinear[i] = uint8(math.Round(linearOf(v) * 255))
+		linearToSRGB[i] = uint8(math.Round(srgbOf(v) * 255))
+	}
+}
+
+// linearOf and srgbOf convert a channel value in [0, 1] from sRGB to linear
+// light and back.
+func linearOf(v float64) float64 {
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+func srgbOf(v float64) float64 {
+	if v <= 0.0031308 {
+		return v * 12.92
+	}
+	return 1.055*math.Pow(v, 1/2.4) - 0.055
+}
+
+// SetLinearLight makes the model blend shapes in linear light rather than
+// in gamma encoded sRGB, as light mixes physically, so translucent overlaps
+// do not darken. The target and the canvas are converted to linear light,
+// which the search then fits and scores colors in, and renders are
+// converted back to sRGB. Errors in linear light count for less in the
+// shadows than in sRGB, so the search favors the highlights. Colors, and the SVG and JSON output, stay sRGB;
+// SVG viewers blend in sRGB, so SVG output only approximates the render.
+// The canvases keep 8 bits per channel, which in linear light is coarse in
+// the deepest shadows. Call it before adding shapes; Reset keeps it.
+func (model *Model) SetLinearLight(on bool) {
+	if on == model.linearLight {
+		return
+	}
+	model.linearLight = on
+	if on {
+		convertRGB(model.Target, &srgbToLinear)
+	} else {
+		convertRGB(model.Target, &linearToSRGB)
+	}
+	model.repaint()
+}
+
+// convertRGB maps the color channels of an opaque image through lut.
+func convertRGB(im *image.RGBA, lut *[256]uint8) {
+	for i := 0; i < len(im.Pix); i += 4 {
+		im.Pix[i] = lut[im.Pix[i]]
+		im.Pix[i+1] = lut[im.Pix[i+1]]
+		im.Pix[i+2] = lut[im.Pix[i+2]]
+	}
+}
+
+// canvasColor returns c, an sRGB color, as it is drawn on the canvas.
+func (model *Model) canvasColor(c Color) Color {
+	if !model.linearLight {
+		return c
+	}
+	return Color{int(srgbToLinear[c.R]), int(srgbToLinear[c.G]), int(srgbToLinear[c.B]), c.A}
+}
+
+// outputColor returns c, a color fitted on the canvas, in sRGB.
+func (model *Model) outputColor(c Color) Color {
+	if !model.linearLight {
+		return c
+	}
+	return Color{int(linearToSRGB[c.R]), int(linearToSRGB[c.G]), int(linearToSRGB[c.B]), c.A}
+}
+
+func (model *Model) canvasGradient(g *Gradient) *Gradient {
+	if g == nil || !model.linearLight {
+		return g
+	}
+	a := *g
+	a.From, a.To = model.canvasColor(g.From), model.canvasColor(g.To)
+	return &a
+}
+
+func (model *Model) outputGradient(g *Gradient) *Gradient {
+	if g == nil || !model.linearLight {
+		return g
+	}
+	a := *g
+	a.From, a.To = model.outputColor(g.From), model.outputColor(g.To)
+	return &a
+}
+
+// outputImage returns a render drawn on a linear light canvas in sRGB. im
+// is premultiplied and may be translucent. Without linear light it is
+// returned as is.
+func (model *Model) outputImage(im image.Image) image.Image {
+	if !model.linearLight {
+		return im
+	}
+	dst := imageToRGBA(im)
+	for i := 0; i < len(dst.Pix); i += 4 {
+		a := int(dst.Pix[i+3])
+		if a == 0 {
+			continue
+		}
+		for j := 0; j < 3; j++ {
+			v := minInt(int(dst.Pix[i+j])*255/a, 255)
+			dst.Pix[i+j] = uint8(int(linearToSRGB[v]) * a / 255)
+		}
+	}
+	return dst
+}
@@ -0,0 +1,214 @@
+package primitive
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+)
+
+// lottieFrameRate is the frame rate of Lottie output. Keyframe times are
+// fractional, so it only affects how players sample the fades.
+const lottieFrameRate = 30
+
+// lottieHoldMs is how long the finished image stays on screen at the end.
+const lottieHoldMs = 1000
+
+// lottieBlendModes maps blend modes to Lottie layer blend modes.
+var lottieBlendModes = map[BlendMode]int{
+	BlendNormal:   0,
+	BlendMultiply: 1,
+	BlendScreen:   2,
+	BlendAdd:      16,
+}
+
+type lottieObject map[string]interface{}
+
+func lottieStatic(v interface{}) lottieObject {
+	return lottieObject{"a": 0, "k": v}
+}
+
+// Lottie returns the shapes as a Lottie animation in which each shape is a
+// layer that fades in over fadeMs milliseconds, one after another in the
+// order they were added, over the background, and then holds for a second.
+// Triangles, rectangles, ellipses, circles and polygons map exactly,
+// rotated shapes are drawn as rotated paths and ellipses, and quadratics as
+// stroked curves. Gradient fills are drawn in their mean color, and
+// Wireframe outlines are strokes.
+func (model *Model) Lottie(fadeMs int) ([]byte, error) {
+	if fadeMs <= 0 {
+		return nil, fmt.Errorf("lottie: fadeMs must be positive, got %d", fadeMs)
+	}
+	frames := func(ms int) float64 {
+		return float64(ms) * lottieFrameRate / 1000
+	}
+	n := len(model.Shapes)
+	end := frames(n*fadeMs + lottieHoldMs)
+	layers := make([]lottieObject, 0, n+1)
+	// Lottie draws the first layer on top, so the last shape drawn comes
+	// first
+	order := model.drawOrder()
+	for k := n - 1; k >= 0; k-- {
+		i := order[k]
+		c, _ := model.shapeFill(i)
+		if model.Wireframe {
+			c, _ = model.wireframeFill(c, nil)
+		}
+		items, err := lottieShape(model.Shapes[i], c)
+		if err != nil {
+			return nil, err
+		}
+		if model.Wireframe {
+			lottieWireframe(items, model.wireframeWidth())
+		}
+		items = append(items, lottieObject{
+			"ty": "tr",
+			"p":  lottieStatic([]float64{0.5, 0.5}),
+			"a":  lottieStatic([]float64{0, 0}),
+			"s":  lottieStatic([]float64{100, 100}),
+			"r":  lottieStatic(0),
+			"o":  lottieStatic(100),
+		})
+		start := frames(i * fadeMs)
+		layers = append(layers, lottieObject{
+			"ddd": 0, "ind": i + 2, "ty": 4, "nm": fmt.Sprintf("shape %d", i), "sr": 1,
+			"ks": lottieObject{
+				"o": lottieObject{"a": 1, "k": []lottieObject{
+					{"t": start, "s": []float64{0}, "i": lottieObject{"x": []float64{0.5}, "y": []float64{1}}, "o": lottieObject{"x": []float64{0.5}, "y": []float64{0}}},
+					{"t": start + frames(fadeMs), "s": []float64{100}},
+				}},
+				"r": lottieStatic(0),
+				"p": lottieStatic([]float64{0, 0, 0}),
+				"a": lottieStatic([]float64{0, 0, 0}),
+				"s": lottieStatic([]float64{model.Scale * 100, model.Scale * 100, 100}),
+			},
+			"ao": 0, "ip": 0, "op": end, "st": 0, "bm": lottieBlendModes[model.BlendMode],
+			"shapes": []lottieObject{{"ty": "gr", "nm": "shape", "it": items}},
+		})
+	}
+	bg := model.Background
+	layers = append(layers, lottieObject{
+		"ddd": 0, "ind": 1, "ty": 1, "nm": "background", "sr": 1,
+		"ks": lottieObject{
+			"o": lottieStatic(100),
+			"r": lottieStatic(0),
+			"p": lottieStatic([]float64{0, 0, 0}),
+			"a": lottieStatic([]float64{0, 0, 0}),
+			"s": lottieStatic([]float64{100, 100, 100}),
+		},
+		"sc": fmt.Sprintf("#%02x%02x%02x", bg.R, bg.G, bg.B), "sw": model.Sw, "sh": model.Sh,
+		"ao": 0, "ip": 0, "op": end, "st": 0, "bm": 0,
+	})
+	return json.Marshal(lottieObject{
+		"v": "5.7.4", "fr": lottieFrameRate, "ip": 0, "op": end,
+		"w": model.Sw, "h": model.Sh, "nm": "primitive", "ddd": 0,
+		"assets": []lottieObject{}, "layers": layers,
+	})
+}
+
+// lottieShape returns the geometry and paint of a shape, in working
+// coordinates.
+func lottieShape(shape Shape, c Color) ([]lottieObject, error) {
+	fill := lottieObject{
+		"ty": "fl",
+		"c":  lottieStatic(lottieColor(c)),
+		"o":  lottieStatic(float64(c.A) / 255 * 100),
+		"r":  1,
+	}
+	switch s := shape.(type) {
+	case *Triangle:
+		return []lottieObject{lottiePath([][2]float64{
+			{float64(s.X1), float64(s.Y1)}, {float64(s.X2), float64(s.Y2)}, {float64(s.X3), float64(s.Y3)},
+		}), fill}, nil
+	case *Rectangle:
+		x1, y1, x2, y2 := s.bounds()
+		l, t, r, b := float64(x1), float64(y1), float64(x2+1), float64(y2+1)
+		return []lottieObject{lottiePath([][2]float64{{l, t}, {r, t}, {r, b}, {l, b}}), fill}, nil
+	case *Ellipse:
+		return []lottieObject{lottieEllipse(float64(s.X), float64(s.Y), float64(s.Rx), float64(s.Ry), 0), fill}, nil
+	case *RotatedRectangle:
+		sx, sy := float64(s.Sx)/2, float64(s.Sy)/2
+		a := radians(float64(s.Angle))
+		var points [][2]float64
+		for _, p := range [][2]float64{{-sx, -sy}, {sx, -sy}, {sx, sy}, {-sx, sy}} {
+			x, y := rotate(p[0], p[1], a)
+			points = append(points, [2]float64{float64(s.X) + x, float64(s.Y) + y})
+		}
+		return []lottieObject{lottiePath(points), fill}, nil
+	case *RotatedEllipse:
+		return []lottieObject{lottieEllipse(s.X, s.Y, s.Rx, s.Ry, s.Angle), fill}, nil
+	case *Polygon:
+		x, y := s.outline()
+		points := make([][2]float64, len(x))
+		for i := range points {
+			points[i] = [2]float64{x[i], y[i]}
+		}
+		return []lottieObject{lottiePath(points), fill}, nil
+	case *Quadratic:
+		// the quadratic's control point as the equivalent cubic tangents
+		out := [2]float64{(s.X2 - s.X1) * 2 / 3, (s.Y2 - s.Y1) * 2 / 3}
+		in := [2]float64{(s.X2 - s.X3) * 2 / 3, (s.Y2 - s.Y3) * 2 / 3}
+		path := lottieObject{"ty": "sh", "ks": lottieStatic(lottieObject{
+			"c": false,
+			"v": [][2]float64{{s.X1, s.Y1}, {s.X3, s.Y3}},
+			"i": [][2]float64{{0, 0}, in},
+			"o": [][2]float64{out, {0, 0}},
+		})}
+		stroke := lottieObject{
+			"ty": "st",
+			"c":  fill["c"],
+			"o":  fill["o"],
+			"w":  lottieStatic(s.Width),
+			"lc": 1, "lj": 1,
+		}
+		return []lottieObject{path, stroke}, nil
+	}
+	return nil, fmt.Errorf("lottie: unsupported shape %T", shape)
+}
+
+func lottieColor(c Color) []float64 {
+	return []float64{float64(c.R) / 255, float64(c.G) / 255, float64(c.B) / 255, 1}
+}
+
+// lottiePath returns a closed path through points.
+func lottiePath(points [][2]float64) lottieObject {
+	zero := make([][2]float64, len(points))
+	return lottieObject{"ty": "sh", "ks": lottieStatic(lottieObject{
+		"c": true, "v": points, "i": zero, "o": zero,
+	})}
+}
+
+// lottieEllipse returns an ellipse centered at x, y rotated by angle
+// degrees. The rotation needs a group of its own.
+func lottieEllipse(x, y, rx, ry, angle float64) lottieObject {
+	return lottieObject{"ty": "gr", "it": []lottieObject{
+		{"ty": "el", "p": lottieStatic([]float64{0, 0}), "s": lottieStatic([]float64{rx * 2, ry * 2})},
+		{
+			"ty": "tr",
+			"p":  lottieStatic([]float64{x, y}),
+			"a":  lottieStatic([]float64{0, 0}),
+			"s":  lottieStatic([]float64{100, 100}),
+			"r":  lottieStatic(math.Mod(angle, 360)),
+			"o":  lottieStatic(100),
+		},
+	}}
+}
+
+// LottieEncoder writes a Lottie animation with shapes fading in over FadeMs
+// milliseconds each.
+type LottieEncoder struct {
+	FadeMs int
+}
+
+func (e LottieEncoder) Encode(w io.Writer, m *Model) error {
+	data, err := m.Lottie(e.FadeMs)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+func (e LottieEncoder) ContentType() string {
+	return "application/json"
+}
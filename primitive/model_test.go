@@ -0,0 +1,209 @@
+package primitive
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"io"
+	"log"
+	"strings"
+	"testing"
+
+	"github.com/fogleman/gg"
+)
+
+// testTarget returns a w x h image with a dark disc on a horizontal
+// gradient, so that the search has something to find.
+func testTarget(w, h int) *image.NRGBA {
+	im := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c := color.NRGBA{uint8(255 * x / w), 160, uint8(255 * y / h), 255}
+			dx, dy := x-w/2, y-h/2
+			if dx*dx+dy*dy < w*h/16 {
+				c = color.NRGBA{30, 20, 60, 255}
+			}
+			im.SetNRGBA(x, y, c)
+		}
+	}
+	return im
+}
+
+// countdownContext is a context that reports itself cancelled once Err
+// has been called more than n times, so that a test can cancel at an exact
+// point in a run.
+type countdownContext struct {
+	context.Context
+	n int
+}
+
+func (c *countdownContext) Err() error {
+	if c.n--; c.n < 0 {
+		return context.Canceled
+	}
+	return nil
+}
+
+func TestStepContextCancelMatchesCleanRun(t *testing.T) {
+	target := testTarget(32, 32)
+	bg := MakeHexColor("#808080")
+
+	// StepContext checks the context before a step's search and after it,
+	// so the 12th check comes after the sixth shape has been searched for
+	// and before it is added
+	run := NewModel(target, bg, 64, 1)
+	run.Seed(1)
+	ctx := &countdownContext{context.Background(), 11}
+	for {
+		if _, err := run.StepContext(ctx, ShapeTypeTriangle, 128, 0); err != nil {
+			break
+		}
+	}
+	const n = 5
+	if len(run.Shapes) != n {
+		t.Fatalf("cancelled run has %d shapes, want %d", len(run.Shapes), n)
+	}
+
+	clean := NewModel(target, bg, 64, 1)
+	clean.Seed(1)
+	for i := 0; i < n; i++ {
+		clean.Step(ShapeTypeTriangle, 128, 0)
+	}
+	if run.Score != clean.Score {
+		t.Fatalf("score %v after cancelling, %v for a clean run of %d shapes", run.Score, clean.Score, n)
+	}
+	got := run.Context.Image().(*image.RGBA)
+	want := clean.Context.Image().(*image.RGBA)
+	if !bytes.Equal(got.Pix, want.Pix) {
+		t.Fatalf("image after cancelling differs from a clean run of %d shapes", n)
+	}
+}
+
+// panicShape is a shape whose rasterization panics, as a bug in a shape
+// would on a pathological input.
+type panicShape struct{}
+
+func (panicShape) Rasterize() []Scanline     { panic("pathological shape") }
+func (s panicShape) Copy() Shape             { return s }
+func (panicShape) Mutate()                   {}
+func (panicShape) Draw(*gg.Context, float64) {}
+func (panicShape) SVG(attrs string) string   { return "" }
+
+func TestRunWorkerRecoversFromPanickingShape(t *testing.T) {
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(io.Discard)
+
+	model := NewModel(testTarget(16, 16), MakeHexColor("#808080"), 32, 1)
+	worker := model.Workers[0]
+	model.initWorker(worker)
+	ch := make(chan workerResult, 1)
+	runWorker(0, func() []*State {
+		return []*State{worker.hillClimb(NewState(worker, panicShape{}, 128), 10)}
+	}, ch)
+	if r := <-ch; r.states != nil {
+		t.Fatalf("panicking search returned %d states, want none", len(r.states))
+	}
+
+	// the model carries on with the next step
+	model.Step(ShapeTypeTriangle, 128, 0)
+	if len(model.Shapes) != 1 {
+		t.Fatalf("step after the panic added %d shapes, want 1", len(model.Shapes))
+	}
+}
+
+func TestComputeColorExcludingOverlappingTriangles(t *testing.T) {
+	// a red target with a blue triangle, b, which overlaps a
+	target := image.NewNRGBA(image.Rect(0, 0, 32, 32))
+	model := NewModel(target, MakeHexColor("#000"), 32, 1)
+	worker := model.Workers[0]
+	a := append([]Scanline(nil), (&Triangle{worker, 0, 0, 31, 0, 0, 31}).Rasterize()...)
+	b := append([]Scanline(nil), (&Triangle{worker, 6, 6, 31, 6, 6, 31}).Rasterize()...)
+	red, blue := color.NRGBA{255, 0, 0, 255}, color.NRGBA{0, 0, 255, 255}
+	for y := 0; y < 32; y++ {
+		for x := 0; x < 32; x++ {
+			target.SetNRGBA(x, y, red)
+		}
+	}
+	for _, line := range b {
+		for x := line.X1; x <= line.X2; x++ {
+			target.SetNRGBA(x, line.Y, blue)
+		}
+	}
+	model.Reset(target, MakeHexColor("#000"))
+
+	if c := model.computeColorExcluding(a, nil, 255); c.B == 0 {
+		t.Fatalf("without the exclusion the color is %v, want b's blue mixed in", c)
+	}
+	if c := model.computeColorExcluding(a, b, 255); c != (Color{255, 0, 0, 255}) {
+		t.Fatalf("excluding b the color is %v, want a's own red", c)
+	}
+	// excluding everything falls back to fitting all of a
+	all := model.computeColorExcluding(a, a, 255)
+	if want := model.computeColorExcluding(a, nil, 255); all != want {
+		t.Fatalf("excluding all of a the color is %v, want %v", all, want)
+	}
+}
+
+func TestSVGViewBoxIsWorkingSpace(t *testing.T) {
+	model := NewModel(image.NewNRGBA(image.Rect(0, 0, 40, 30)), MakeHexColor("#fff"), 200, 1)
+	model.FixedColor = &Color{0, 0, 0, 255}
+	model.Add(&Triangle{model.Workers[0], 0, 0, 39, 0, 39, 29}, 255)
+	svg := model.SVG()
+	if !strings.Contains(svg, `width="200" height="150" viewBox="0 0 40 30"`) {
+		t.Fatalf("svg header does not give a 200x150 output of the 40x30 working space:\n%s", svg)
+	}
+	// the shape is in the viewBox's coordinates, reaching its corner
+	if !strings.Contains(svg, `points="0,0 39,0 39,29"`) {
+		t.Fatalf("triangle is not in working coordinates:\n%s", svg)
+	}
+}
+
+func TestAcceptWorseDecaysToGreedy(t *testing.T) {
+	target := testTarget(24, 24)
+	bg := MakeHexColor("#808080")
+
+	start := NewModel(target, bg, 48, 1)
+	start.Seed(1)
+	for i := 0; i < 3; i++ {
+		start.Step(ShapeTypeTriangle, 128, 0)
+	}
+	shapes := start.ShapeList()
+
+	// annealing runs over the first 3 shapes only; from the 4th on, a
+	// seeded step matches a greedy one
+	annealed := NewModel(target, bg, 48, 1)
+	annealed.AcceptWorseProb = 0.9
+	annealed.AcceptWorseTemp = 1
+	annealed.AcceptWorseSteps = 3
+	greedy := NewModel(target, bg, 48, 1)
+	for _, model := range []*Model{annealed, greedy} {
+		if err := model.AddShapeList(shapes); err != nil {
+			t.Fatal(err)
+		}
+		model.Seed(2)
+	}
+	if p := annealed.acceptWorseProb(); p != 0 {
+		t.Fatalf("after %d shapes the acceptance probability is %v, want 0", len(annealed.Shapes), p)
+	}
+	annealed.Step(ShapeTypeTriangle, 128, 0)
+	greedy.Step(ShapeTypeTriangle, 128, 0)
+	if annealed.Score != greedy.Score || annealed.Shapes[3].SVG("") != greedy.Shapes[3].SVG("") {
+		t.Fatalf("decayed step added %s, greedy step %s",
+			annealed.Shapes[3].SVG(""), greedy.Shapes[3].SVG(""))
+	}
+
+	// and on the way there the probability falls linearly
+	decaying := NewModel(target, bg, 48, 1)
+	decaying.AcceptWorseProb = 0.9
+	decaying.AcceptWorseSteps = 3
+	if p := decaying.acceptWorseProb(); p != 0.9 {
+		t.Fatalf("at the start the acceptance probability is %v, want 0.9", p)
+	}
+	if err := decaying.AddShapeList(&ShapeList{Width: 24, Height: 24, Background: "#808080", Shapes: shapes.Shapes[:1]}); err != nil {
+		t.Fatal(err)
+	}
+	if p := decaying.acceptWorseProb(); p < 0.6-1e-9 || p > 0.6+1e-9 {
+		t.Fatalf("after one shape the acceptance probability is %v, want 0.6", p)
+	}
+}
@@ -0,0 +1,69 @@
+package primitive
+
+// DefaultMutationWeights returns weights for Model.MutationWeights that
+// pick each shape's moves as often as models do without weights: evenly,
+// but for polygons, which swap two vertices a quarter of the time, and
+// quadratics, which never change their width. They make a starting point
+// for tuning; installed as they are they give the same distribution of
+// moves, but not the same seeded runs, since picking by weight draws
+// different random numbers.
+func DefaultMutationWeights() map[ShapeType][]float64 {
+	return map[ShapeType][]float64{
+		ShapeTypeTriangle:         {1, 1, 1},
+		ShapeTypeRectangle:        {1, 1},
+		ShapeTypeEllipse:          {1, 1, 1},
+		ShapeTypeCircle:           {1, 1, 1},
+		ShapeTypeRotatedRectangle: {1, 1, 1},
+		ShapeTypeQuadratic:        {1, 1, 1, 0},
+		ShapeTypeRotatedEllipse:   {1, 1, 1},
+		ShapeTypePolygon:          {1, 3},
+	}
+}
+
+// maxWeightedTries is how many moves in a row a shape that mutates until it
+// is valid picks by weight before it picks evenly, since weights can rule
+// out the only moves that would make it valid again.
+const maxWeightedTries = 100
+
+// weightedMutation picks one of a shape's n kinds of move by the
+// MutationWeights for t, tries being the number of moves already tried and
+// found invalid in this mutation. It returns false, without drawing a
+// random number, if t has no weights, the wrong number of them, or none
+// positive, or after maxWeightedTries tries.
+func (worker *Worker) weightedMutation(t ShapeType, n, tries int) (int, bool) {
+	weights := worker.MutationWeights[t]
+	if len(weights) != n || tries >= maxWeightedTries {
+		return 0, false
+	}
+	total := 0.0
+	for _, w := range weights {
+		if w > 0 {
+			total += w
+		}
+	}
+	if total <= 0 {
+		return 0, false
+	}
+	r := worker.Rnd.Float64() * total
+	last := 0
+	for i, w := range weights {
+		if w <= 0 {
+			continue
+		}
+		if r -= w; r < 0 {
+			return i, true
+		}
+		last = i
+	}
+	// rounding can leave a sliver of r past the last positive weight
+	return last, true
+}
+
+// mutationChoice picks one of a shape's n kinds of move, by MutationWeights
+// if they apply to t and otherwise evenly.
+func (worker *Worker) mutationChoice(t ShapeType, n, tries int) int {
+	if i, ok := worker.weightedMutation(t, n, tries); ok {
+		return i
+	}
+	return worker.Rnd.Intn(n)
+}
@@ -33,6 +33,32 @@ func HillClimb(state Annealable, maxAge int) Annealable {
 	return bestState
 }
 
+// hillClimbAccepting is HillClimb that may also take a move that makes the
+// state worse, when accept returns true for how much worse it is. Such moves
+// let the climb leave a local minimum; the best state seen is returned.
+func hillClimbAccepting(state Annealable, maxAge int, accept func(change float64) bool) Annealable {
+	state = state.Copy()
+	bestState := state.Copy()
+	bestEnergy := state.Energy()
+	previousEnergy := bestEnergy
+	for age := 0; age < maxAge; age++ {
+		undo := state.DoMove()
+		energy := state.Energy()
+		change := energy - previousEnergy
+		if change >= 0 && !accept(change) {
+			state.UndoMove(undo)
+			continue
+		}
+		previousEnergy = energy
+		if energy < bestEnergy {
+			bestEnergy = energy
+			bestState = state.Copy()
+			age = -1
+		}
+	}
+	return bestState
+}
+
 func PreAnneal(state Annealable, iterations int) float64 {
 	state = state.Copy()
 	previous := state.Energy()
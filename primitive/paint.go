@@ -0,0 +1,48 @@
+package primitive
+
+// maxPaints is where the per-pixel paint counts saturate.
+const maxPaints = 1<<16 - 1
+
+// clearPaints zeroes the paint counts, allocating them for the target's
+// size if needed.
+func (model *Model) clearPaints() {
+	size := model.Target.Bounds().Size()
+	if n := size.X * size.Y; len(model.paints) != n {
+		model.paints = make([]uint16, n)
+		return
+	}
+	for i := range model.paints {
+		model.paints[i] = 0
+	}
+}
+
+// addPaints counts one more coat of paint on every pixel lines cover.
+func (model *Model) addPaints(lines []Scanline) {
+	w := model.Target.Bounds().Size().X
+	for _, line := range lines {
+		i := line.Y*w + line.X1
+		for x := line.X1; x <= line.X2; x++ {
+			if model.paints[i] < maxPaints {
+				model.paints[i]++
+			}
+			i++
+		}
+	}
+}
+
+// paintsAllowed reports whether every pixel lines cover has been painted
+// fewer than MaxPaints times, so one more coat stays within the cap.
+func (worker *Worker) paintsAllowed(lines []Scanline) bool {
+	if worker.MaxPaints <= 0 {
+		return true
+	}
+	for _, line := range lines {
+		i := line.Y*worker.W + line.X1
+		for _, n := range worker.Paints[i : i+line.X2-line.X1+1] {
+			if int(n) >= worker.MaxPaints {
+				return false
+			}
+		}
+	}
+	return true
+}
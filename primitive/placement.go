@@ -0,0 +1,48 @@
+package primitive
+
+import "image"
+
+// minInsidePlacement is the fraction of a shape's pixels that must lie
+// inside the placement mask.
+const minInsidePlacement = 0.5
+
+// SetPlacementMask confines the shapes to where mask is not black, such as
+// a circle for a round crop. The mask is scaled to the target's size. The
+// search rejects shapes with less than half of their pixels inside it, and
+// the error outside it is left out of the score, so shapes neither reach for
+// the parts of the image outside nor are held back by covering them. Unlike
+// SetWeightMask it is a hard boundary, not a weighting, and it combines with
+// weight masks. Shapes may still overlap the edge, and a step that finds no
+// shape inside adds nothing. A nil mask removes it. Like SetWeightMask, call
+// it before the first Step.
+func (model *Model) SetPlacementMask(mask image.Image) {
+	model.placement = nil
+	if mask != nil {
+		weights := weightsFromMask(mask, model.Target.Bounds())
+		model.placement = make([]bool, len(weights))
+		for i, w := range weights {
+			model.placement[i] = w > 0
+		}
+	}
+	model.updateWeights()
+}
+
+// insideFraction returns the fraction of the pixels lines cover that the
+// placement mask allows.
+func insideFraction(lines []Scanline, placement []bool, w int) float64 {
+	inside, total := 0, 0
+	for _, line := range lines {
+		i := line.Y*w + line.X1
+		for x := line.X1; x <= line.X2; x++ {
+			if placement[i] {
+				inside++
+			}
+			i++
+		}
+		total += line.X2 - line.X1 + 1
+	}
+	if total == 0 {
+		return 0
+	}
+	return float64(inside) / float64(total)
+}
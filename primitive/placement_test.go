@@ -0,0 +1,67 @@
+package primitive
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+// circleMask returns a w x w mask that is white inside a centred circle of
+// radius w/3 and black outside it.
+func circleMask(w int) *image.Gray {
+	im := image.NewGray(image.Rect(0, 0, w, w))
+	r := w / 3
+	for y := 0; y < w; y++ {
+		for x := 0; x < w; x++ {
+			dx, dy := x-w/2, y-w/2
+			if dx*dx+dy*dy <= r*r {
+				im.SetGray(x, y, color.Gray{255})
+			}
+		}
+	}
+	return im
+}
+
+func TestPlacementMaskConfinesShapes(t *testing.T) {
+	mask := circleMask(32)
+	bg := MakeHexColor("#808080")
+	model := NewModel(testTarget(32, 32), bg, 64, 1)
+	model.SetPlacementMask(mask)
+	model.Seed(1)
+	for i := 0; i < 10; i++ {
+		model.Step(ShapeTypeEllipse, 128, 0)
+	}
+	if len(model.Shapes) == 0 {
+		t.Fatal("no shape inside the circle was added")
+	}
+	for i, shape := range model.Shapes {
+		if f := insideFraction(shape.Rasterize(), model.placement, 32); f < minInsidePlacement {
+			t.Fatalf("shape %d has %v of its pixels inside the circle, want at least %v", i, f, minInsidePlacement)
+		}
+	}
+
+	// a target that differs only outside the circle scores the same
+	outside := testTarget(32, 32)
+	for y := 0; y < 32; y++ {
+		for x := 0; x < 32; x++ {
+			if mask.GrayAt(x, y).Y == 0 {
+				outside.SetNRGBA(x, y, color.NRGBA{255, 255, 255, 255})
+			}
+		}
+	}
+	a := NewModel(testTarget(32, 32), bg, 64, 1)
+	a.SetPlacementMask(mask)
+	b := NewModel(outside, bg, 64, 1)
+	b.SetPlacementMask(mask)
+	if a.Score != b.Score {
+		t.Fatalf("scores %v and %v for targets that differ only outside the circle", a.Score, b.Score)
+	}
+
+	// and a shape outside it changes nothing that is scored
+	score := model.Score
+	model.FixedColor = &Color{255, 255, 255, 255}
+	model.Add(&Rectangle{model.Workers[0], 0, 0, 3, 3}, 255)
+	if model.Score != score {
+		t.Fatalf("score = %v after a shape outside the circle, want %v", model.Score, score)
+	}
+}
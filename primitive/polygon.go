@@ -2,6 +2,7 @@ package primitive
 
 import (
 	"fmt"
+	"sort"
 	"strings"
 
 	"github.com/fogleman/gg"
@@ -19,8 +20,7 @@ func NewRandomPolygon(worker *Worker, order int, convex bool) *Polygon {
 	rnd := worker.Rnd
 	x := make([]float64, order)
 	y := make([]float64, order)
-	x[0] = rnd.Float64() * float64(worker.W)
-	y[0] = rnd.Float64() * float64(worker.H)
+	x[0], y[0] = worker.randomPointF()
 	for i := 1; i < order; i++ {
 		x[i] = x[0] + rnd.Float64()*40 - 20
 		y[i] = y[0] + rnd.Float64()*40 - 20
@@ -30,10 +30,20 @@ func NewRandomPolygon(worker *Worker, order int, convex bool) *Polygon {
 	return p
 }
 
+// outline returns the vertices the polygon is drawn with: its own, or with
+// Model.ConvexPolygons their convex hull.
+func (p *Polygon) outline() ([]float64, []float64) {
+	if p.Worker == nil || !p.Worker.ConvexPolygons {
+		return p.X, p.Y
+	}
+	return convexHull(p.X, p.Y)
+}
+
 func (p *Polygon) Draw(dc *gg.Context, scale float64) {
+	x, y := p.outline()
 	dc.NewSubPath()
-	for i := 0; i < p.Order; i++ {
-		dc.LineTo(p.X[i], p.Y[i])
+	for i := range x {
+		dc.LineTo(x[i], y[i])
 	}
 	dc.ClosePath()
 	dc.Fill()
@@ -43,9 +53,10 @@ func (p *Polygon) SVG(attrs string) string {
 	ret := fmt.Sprintf(
 		"<polygon %s points=\"",
 		attrs)
-	points := make([]string, len(p.X))
-	for i := 0; i < len(p.X); i++ {
-		points[i] = fmt.Sprintf("%f,%f", p.X[i], p.Y[i])
+	x, y := p.outline()
+	points := make([]string, len(x))
+	for i := range x {
+		points[i] = fmt.Sprintf("%f,%f", x[i], y[i])
 	}
 
 	return ret + strings.Join(points, ",") + "\" />"
@@ -65,15 +76,24 @@ func (p *Polygon) Mutate() {
 	w := p.Worker.W
 	h := p.Worker.H
 	rnd := p.Worker.Rnd
-	for {
-		if rnd.Float64() < 0.25 {
+	d := 16 * p.Worker.mutationScale(ShapeTypePolygon)
+	for tries := 0; ; tries++ {
+		// without weights a quarter of the moves are swaps
+		move, ok := p.Worker.weightedMutation(ShapeTypePolygon, 2, tries)
+		if !ok {
+			move = 1
+			if rnd.Float64() < 0.25 {
+				move = 0
+			}
+		}
+		if move == 0 {
 			i := rnd.Intn(p.Order)
 			j := rnd.Intn(p.Order)
 			p.X[i], p.Y[i], p.X[j], p.Y[j] = p.X[j], p.Y[j], p.X[i], p.Y[i]
 		} else {
 			i := rnd.Intn(p.Order)
-			p.X[i] = clamp(p.X[i]+rnd.NormFloat64()*16, -m, float64(w-1+m))
-			p.Y[i] = clamp(p.Y[i]+rnd.NormFloat64()*16, -m, float64(h-1+m))
+			p.X[i] = clamp(p.X[i]+rnd.NormFloat64()*d, -m, float64(w-1+m))
+			p.Y[i] = clamp(p.Y[i]+rnd.NormFloat64()*d, -m, float64(h-1+m))
 		}
 		if p.Valid() {
 			break
@@ -109,9 +129,11 @@ func cross3(x1, y1, x2, y2, x3, y3 float64) float64 {
 }
 
 func (p *Polygon) Rasterize() []Scanline {
+	x, y := p.outline()
+	n := len(x)
 	var path raster.Path
-	for i := 0; i <= p.Order; i++ {
-		f := fixp(p.X[i%p.Order], p.Y[i%p.Order])
+	for i := 0; i <= n; i++ {
+		f := fixp(x[i%n], y[i%n])
 		if i == 0 {
 			path.Start(f)
 		} else {
@@ -120,3 +142,47 @@ func (p *Polygon) Rasterize() []Scanline {
 	}
 	return fillPath(p.Worker, path)
 }
+
+// convexHull returns the convex hull of the points, counterclockwise in
+// image coordinates, by the monotone chain algorithm. Collinear points are
+// dropped. If the points are all collinear they are returned as they are.
+func convexHull(x, y []float64) ([]float64, []float64) {
+	n := len(x)
+	order := make([]int, n)
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(a, b int) bool {
+		i, j := order[a], order[b]
+		return x[i] < x[j] || x[i] == x[j] && y[i] < y[j]
+	})
+	cross := func(o, a, b int) float64 {
+		return (x[a]-x[o])*(y[b]-y[o]) - (y[a]-y[o])*(x[b]-x[o])
+	}
+	hull := make([]int, 0, 2*n)
+	for _, i := range order {
+		for len(hull) >= 2 && cross(hull[len(hull)-2], hull[len(hull)-1], i) <= 0 {
+			hull = hull[:len(hull)-1]
+		}
+		hull = append(hull, i)
+	}
+	lower := len(hull) + 1
+	for k := n - 2; k >= 0; k-- {
+		i := order[k]
+		for len(hull) >= lower && cross(hull[len(hull)-2], hull[len(hull)-1], i) <= 0 {
+			hull = hull[:len(hull)-1]
+		}
+		hull = append(hull, i)
+	}
+	hull = hull[:len(hull)-1]
+	if len(hull) < 3 {
+		return x, y
+	}
+	hx := make([]float64, len(hull))
+	hy := make([]float64, len(hull))
+	for k, i := range hull {
+		hx[k] = x[i]
+		hy[k] = y[i]
+	}
+	return hx, hy
+}
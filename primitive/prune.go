@@ -0,0 +1,164 @@
+package primitive
+
+import (
+	"image"
+	"sort"
+)
+
+// Prune removes shapes that are not needed to keep NormalizedScore under
+// targetScore, which shrinks the SVG and JSON output for a given quality. It
+// tries the shapes that improved the score least first and drops each one
+// whose removal still leaves NormalizedScore under the target. A removal only
+// changes the pixels the shape covered, so each trial recomposites just
+// those. It returns the number of shapes removed.
+func (model *Model) Prune(targetScore float64) int {
+	n := len(model.Shapes)
+	normalized := model.NormalizedScore()
+	if n == 0 || normalized >= targetScore {
+		return 0
+	}
+	size := model.Target.Bounds().Size()
+	w, h := size.X, size.Y
+
+	lines := make([][]Scanline, n)
+	boxes := make([]image.Rectangle, n)
+	for i, shape := range model.Shapes {
+		lines[i] = append([]Scanline(nil), model.shapeLines(shape)...)
+		boxes[i] = scanlineBounds(lines[i])
+	}
+
+	// the deltas are negative, so the least useful shapes come first
+	order := make([]int, n)
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return model.Deltas[order[a]] > model.Deltas[order[b]]
+	})
+
+	canvas := model.canvasColor(model.Background)
+	blank := uniformRGBA(model.Target.Bounds(), canvas.NRGBA())
+	bg := blank.RGBAAt(blank.Rect.Min.X, blank.Rect.Min.Y)
+	removed := make([]bool, n)
+	mask := make([]bool, w*h)
+	before := copyRGBA(model.Current)
+	count := 0
+	for _, i := range order {
+		region := regionLines(lines[i], mask, w)
+		if len(region) == 0 {
+			removed[i] = true
+			count++
+			continue
+		}
+
+		// recomposite the region without shape i
+		for _, line := range region {
+			p := model.Current.PixOffset(line.X1, line.Y)
+			for x := line.X1; x <= line.X2; x++ {
+				model.Current.Pix[p+0] = bg.R
+				model.Current.Pix[p+1] = bg.G
+				model.Current.Pix[p+2] = bg.B
+				model.Current.Pix[p+3] = bg.A
+				p += 4
+			}
+		}
+		for k := range model.Shapes {
+			if k != i && !removed[k] && boxes[k].Overlaps(boxes[i]) {
+				drawFill(model.Current, model.canvasColor(model.Colors[k]), model.canvasGradient(model.Gradients[k]), clipLines(lines[k], mask, w), model.BlendMode)
+			}
+		}
+
+		if score := normalizedPartial(model.Target, before, model.Current, normalized, region); score < targetScore {
+			removed[i] = true
+			count++
+			normalized = score
+			copyLines(before, model.Current, region)
+		} else {
+			copyLines(model.Current, before, region)
+		}
+		for _, line := range region {
+			for x := line.X1; x <= line.X2; x++ {
+				mask[line.Y*w+x] = false
+			}
+		}
+	}
+	if count == 0 {
+		return 0
+	}
+
+	// replay the kept shapes so that Scores and Context are exact
+	shapes, colors, gradients := model.Shapes, model.Colors, model.Gradients
+	model.Shapes, model.Colors, model.Scores, model.Deltas, model.Gradients = nil, nil, nil, nil, nil
+	model.centers = nil
+	model.coverage = 0
+	model.clearPaints()
+	copy(model.Current.Pix, blank.Pix)
+	model.Score = model.differenceFull()
+	model.clearContext(model.Context, model.Scale, model.Scale)
+	for i, shape := range shapes {
+		if !removed[i] {
+			model.addLines(shape, colors[i], gradients[i], lines[i])
+		}
+	}
+	return count
+}
+
+func scanlineBounds(lines []Scanline) image.Rectangle {
+	var r image.Rectangle
+	for _, line := range lines {
+		r = r.Union(image.Rect(line.X1, line.Y, line.X2+1, line.Y+1))
+	}
+	return r
+}
+
+// regionLines marks the pixels covered by lines in mask and returns them as
+// non-overlapping runs.
+func regionLines(lines []Scanline, mask []bool, w int) []Scanline {
+	for _, line := range lines {
+		for x := line.X1; x <= line.X2; x++ {
+			mask[line.Y*w+x] = true
+		}
+	}
+	var result []Scanline
+	for _, line := range lines {
+		start := -1
+		for x := line.X1; x <= line.X2+1; x++ {
+			on := x <= line.X2 && mask[line.Y*w+x]
+			if on && start < 0 {
+				start = x
+			} else if !on && start >= 0 {
+				result = append(result, Scanline{line.Y, start, x - 1, 0xffff})
+				for j := start; j < x; j++ {
+					// claim the run so overlapping lines do not repeat it
+					mask[line.Y*w+j] = false
+				}
+				start = -1
+			}
+		}
+	}
+	// restore the marks for clipLines
+	for _, line := range result {
+		for x := line.X1; x <= line.X2; x++ {
+			mask[line.Y*w+x] = true
+		}
+	}
+	return result
+}
+
+// clipLines returns the parts of lines that fall on marked pixels.
+func clipLines(lines []Scanline, mask []bool, w int) []Scanline {
+	var result []Scanline
+	for _, line := range lines {
+		start := -1
+		for x := line.X1; x <= line.X2+1; x++ {
+			on := x <= line.X2 && mask[line.Y*w+x]
+			if on && start < 0 {
+				start = x
+			} else if !on && start >= 0 {
+				result = append(result, Scanline{line.Y, start, x - 1, line.Alpha})
+				start = -1
+			}
+		}
+	}
+	return result
+}
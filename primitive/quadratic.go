@@ -18,8 +18,7 @@ type Quadratic struct {
 
 func NewRandomQuadratic(worker *Worker) *Quadratic {
 	rnd := worker.Rnd
-	x1 := rnd.Float64() * float64(worker.W)
-	y1 := rnd.Float64() * float64(worker.H)
+	x1, y1 := worker.randomPointF()
 	x2 := x1 + rnd.Float64()*40 - 20
 	y2 := y1 + rnd.Float64()*40 - 20
 	x3 := x2 + rnd.Float64()*40 - 20
@@ -55,17 +54,23 @@ func (q *Quadratic) Mutate() {
 	w := q.Worker.W
 	h := q.Worker.H
 	rnd := q.Worker.Rnd
-	for {
-		switch rnd.Intn(3) {
+	d := 16 * q.Worker.mutationScale(ShapeTypeQuadratic)
+	for tries := 0; ; tries++ {
+		// without weights the width is never mutated
+		move, ok := q.Worker.weightedMutation(ShapeTypeQuadratic, 4, tries)
+		if !ok {
+			move = rnd.Intn(3)
+		}
+		switch move {
 		case 0:
-			q.X1 = clamp(q.X1+rnd.NormFloat64()*16, -m, float64(w-1+m))
-			q.Y1 = clamp(q.Y1+rnd.NormFloat64()*16, -m, float64(h-1+m))
+			q.X1 = clamp(q.X1+rnd.NormFloat64()*d, -m, float64(w-1+m))
+			q.Y1 = clamp(q.Y1+rnd.NormFloat64()*d, -m, float64(h-1+m))
 		case 1:
-			q.X2 = clamp(q.X2+rnd.NormFloat64()*16, -m, float64(w-1+m))
-			q.Y2 = clamp(q.Y2+rnd.NormFloat64()*16, -m, float64(h-1+m))
+			q.X2 = clamp(q.X2+rnd.NormFloat64()*d, -m, float64(w-1+m))
+			q.Y2 = clamp(q.Y2+rnd.NormFloat64()*d, -m, float64(h-1+m))
 		case 2:
-			q.X3 = clamp(q.X3+rnd.NormFloat64()*16, -m, float64(w-1+m))
-			q.Y3 = clamp(q.Y3+rnd.NormFloat64()*16, -m, float64(h-1+m))
+			q.X3 = clamp(q.X3+rnd.NormFloat64()*d, -m, float64(w-1+m))
+			q.Y3 = clamp(q.Y3+rnd.NormFloat64()*d, -m, float64(h-1+m))
 		case 3:
 			q.Width = clamp(q.Width+rnd.NormFloat64(), 1, 16)
 		}
@@ -96,5 +101,5 @@ func (q *Quadratic) Rasterize() []Scanline {
 	path.Start(p1)
 	path.Add2(p2, p3)
 	width := fix(q.Width)
-	return strokePath(q.Worker, path, width, raster.RoundCapper, raster.RoundJoiner)
+	return strokePath(q.Worker, path, width, raster.RoundCapper, q.Worker.StrokeJoin.joiner())
 }
@@ -0,0 +1,125 @@
+package primitive
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"sort"
+)
+
+// colorBox is a box of histogram entries in RGB space for median cut.
+type colorBox struct {
+	colors []histogramColor
+}
+
+type histogramColor struct {
+	c     [3]uint8
+	count int
+}
+
+// axis returns the channel with the widest range in the box and that range.
+func (b *colorBox) axis() (int, int) {
+	lo := [3]uint8{255, 255, 255}
+	var hi [3]uint8
+	for _, hc := range b.colors {
+		for j, v := range hc.c {
+			if v < lo[j] {
+				lo[j] = v
+			}
+			if v > hi[j] {
+				hi[j] = v
+			}
+		}
+	}
+	best, size := 0, -1
+	for j := range lo {
+		if d := int(hi[j]) - int(lo[j]); d > size {
+			best, size = j, d
+		}
+	}
+	return best, size
+}
+
+// split divides the box at the weighted median of its widest channel.
+func (b *colorBox) split() (*colorBox, *colorBox) {
+	j, _ := b.axis()
+	sort.Slice(b.colors, func(p, q int) bool {
+		return b.colors[p].c[j] < b.colors[q].c[j]
+	})
+	total := 0
+	for _, hc := range b.colors {
+		total += hc.count
+	}
+	i, sum := 0, 0
+	for i < len(b.colors)-1 {
+		sum += b.colors[i].count
+		i++
+		if sum*2 >= total {
+			break
+		}
+	}
+	return &colorBox{b.colors[:i]}, &colorBox{b.colors[i:]}
+}
+
+func (b *colorBox) average() color.Color {
+	var r, g, bl, n int
+	for _, hc := range b.colors {
+		r += int(hc.c[0]) * hc.count
+		g += int(hc.c[1]) * hc.count
+		bl += int(hc.c[2]) * hc.count
+		n += hc.count
+	}
+	if n == 0 {
+		return color.RGBA{0, 0, 0, 255}
+	}
+	return color.RGBA{uint8(r / n), uint8(g / n), uint8(bl / n), 255}
+}
+
+// medianCutPalette picks up to n colors that represent im, by repeatedly
+// splitting the box of colors with the widest channel range.
+func medianCutPalette(im *image.RGBA, n int) color.Palette {
+	counts := make(map[[3]uint8]int)
+	size := im.Bounds().Size()
+	for y := 0; y < size.Y; y++ {
+		i := im.PixOffset(im.Rect.Min.X, im.Rect.Min.Y+y)
+		for x := 0; x < size.X; x++ {
+			counts[[3]uint8{im.Pix[i], im.Pix[i+1], im.Pix[i+2]}]++
+			i += 4
+		}
+	}
+	all := make([]histogramColor, 0, len(counts))
+	for c, count := range counts {
+		all = append(all, histogramColor{c, count})
+	}
+	boxes := []*colorBox{{all}}
+	for len(boxes) < n {
+		best, bestSize := -1, 0
+		for i, b := range boxes {
+			if len(b.colors) < 2 {
+				continue
+			}
+			if _, d := b.axis(); d > bestSize {
+				best, bestSize = i, d
+			}
+		}
+		if best < 0 {
+			break
+		}
+		a, b := boxes[best].split()
+		boxes[best] = a
+		boxes = append(boxes, b)
+	}
+	palette := make(color.Palette, len(boxes))
+	for i, b := range boxes {
+		palette[i] = b.average()
+	}
+	return palette
+}
+
+// quantizeImage maps im to at most n colors chosen by median cut.
+func quantizeImage(src image.Image, n int) *image.Paletted {
+	im := imageToRGBA(src)
+	dst := image.NewPaletted(im.Bounds(), medianCutPalette(im, clampInt(n, 1, 256)))
+	draw.Draw(dst, dst.Rect, im, im.Rect.Min, draw.Src)
+	return dst
+}
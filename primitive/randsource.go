@@ -0,0 +1,31 @@
+package primitive
+
+import (
+	"math/rand"
+	"time"
+)
+
+// SetRandSource sets the function that gives each worker its random
+// numbers: it is called with each worker's index now, and again for the
+// workers Reset makes for a target of a new size, so that callers control
+// all of the search's randomness, as tests of a single worker need. A nil
+// source restores the default, a source per worker seeded from the clock,
+// as NewWorker makes. SaveState only tracks the default and seeded sources,
+// so it fails on a model given one here, and Seed replaces them.
+func (model *Model) SetRandSource(source func(workerIndex int) *rand.Rand) {
+	model.randSource = source
+	for i, worker := range model.Workers {
+		model.initRand(i, worker)
+	}
+}
+
+// initRand gives worker i its random numbers from the model's source, or a
+// fresh default one.
+func (model *Model) initRand(i int, worker *Worker) {
+	if model.randSource == nil {
+		seedWorker(worker, time.Now().UnixNano())
+		return
+	}
+	worker.Rnd = model.randSource(i)
+	worker.source = nil
+}
@@ -10,7 +10,7 @@ func fix(x float64) fixed.Int26_6 {
 }
 
 func fixp(x, y float64) fixed.Point26_6 {
-	return fixed.Point26_6{fix(x), fix(y)}
+	return fixed.Point26_6{X: fix(x), Y: fix(y)}
 }
 
 type painter struct {
@@ -23,6 +23,17 @@ func (p *painter) Paint(spans []raster.Span, done bool) {
 	}
 }
 
+// polygonPath returns the closed path through the points x1, y1, x2, y2, ...
+func polygonPath(points ...float64) raster.Path {
+	var path raster.Path
+	path.Start(fixp(points[0], points[1]))
+	for i := 2; i < len(points); i += 2 {
+		path.Add1(fixp(points[i], points[i+1]))
+	}
+	path.Add1(fixp(points[0], points[1]))
+	return path
+}
+
 func fillPath(worker *Worker, path raster.Path) []Scanline {
 	r := worker.Rasterizer
 	r.Clear()
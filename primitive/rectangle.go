@@ -15,11 +15,54 @@ type Rectangle struct {
 
 func NewRandomRectangle(worker *Worker) *Rectangle {
 	rnd := worker.Rnd
-	x1 := rnd.Intn(worker.W)
-	y1 := rnd.Intn(worker.H)
+	x1, y1 := worker.randomPoint()
 	x2 := clampInt(x1+rnd.Intn(32)+1, 0, worker.W-1)
 	y2 := clampInt(y1+rnd.Intn(32)+1, 0, worker.H-1)
-	return &Rectangle{worker, x1, y1, x2, y2}
+	r := &Rectangle{worker, x1, y1, x2, y2}
+	if _, ok := worker.fixedSize(); ok {
+		r.resizeFixed()
+	}
+	r.limitAspect()
+	if worker.gridded() {
+		r.snap()
+	}
+	return r
+}
+
+// limitAspect shortens the rectangle's longer side to keep it within
+// MaxAspectRatio, keeping the top left corner.
+func (r *Rectangle) limitAspect() {
+	if r.Worker.MaxAspectRatio <= 0 {
+		return
+	}
+	x1, y1, x2, y2 := r.bounds()
+	w, h := r.Worker.limitAspect(float64(x2-x1+1), float64(y2-y1+1))
+	r.X1, r.Y1 = x1, y1
+	r.X2 = x1 + maxInt(int(w), 1) - 1
+	r.Y2 = y1 + maxInt(int(h), 1) - 1
+}
+
+// snap aligns the rectangle to the grid so that it covers whole cells.
+func (r *Rectangle) snap() {
+	worker := r.Worker
+	g := worker.GridSize
+	x1, y1, x2, y2 := r.bounds()
+	r.X1 = worker.snap(x1, worker.W)
+	r.Y1 = worker.snap(y1, worker.H)
+	// the far edges sit just before a grid line, at least a cell away
+	r.X2 = minInt(maxInt(worker.snap(x2+1, worker.W+1)-1, r.X1+g-1), worker.W-1)
+	r.Y2 = minInt(maxInt(worker.snap(y2+1, worker.H+1)-1, r.Y1+g-1), worker.H-1)
+}
+
+// resizeFixed sets the size to a new one near FixedShapeSize, keeping the
+// top left corner.
+func (r *Rectangle) resizeFixed() {
+	sx, _ := r.Worker.fixedSize()
+	sy, _ := r.Worker.fixedSize()
+	r.X1 = minInt(r.X1, r.X2)
+	r.Y1 = minInt(r.Y1, r.Y2)
+	r.X2 = clampInt(r.X1+int(sx)-1, 0, r.Worker.W-1)
+	r.Y2 = clampInt(r.Y1+int(sy)-1, 0, r.Worker.H-1)
 }
 
 func (r *Rectangle) bounds() (x1, y1, x2, y2 int) {
@@ -57,14 +100,37 @@ func (r *Rectangle) Copy() Shape {
 func (r *Rectangle) Mutate() {
 	w := r.Worker.W
 	h := r.Worker.H
-	rnd := r.Worker.Rnd
-	switch rnd.Intn(2) {
+	d := 16 * r.Worker.mutationScale(ShapeTypeRectangle)
+	if _, ok := r.Worker.fixedSize(); ok {
+		// move the rectangle as a whole, or pick a new size near the fixed
+		// one
+		switch r.Worker.mutationChoice(ShapeTypeRectangle, 2, 0) {
+		case 0:
+			sx, sy := r.X2-r.X1, r.Y2-r.Y1
+			r.X1 = clampInt(r.X1+r.Worker.offset(d), 0, w-1)
+			r.Y1 = clampInt(r.Y1+r.Worker.offset(d), 0, h-1)
+			r.X2 = clampInt(r.X1+sx, 0, w-1)
+			r.Y2 = clampInt(r.Y1+sy, 0, h-1)
+		case 1:
+			r.resizeFixed()
+		}
+		r.limitAspect()
+		if r.Worker.gridded() {
+			r.snap()
+		}
+		return
+	}
+	switch r.Worker.mutationChoice(ShapeTypeRectangle, 2, 0) {
 	case 0:
-		r.X1 = clampInt(r.X1+int(rnd.NormFloat64()*16), 0, w-1)
-		r.Y1 = clampInt(r.Y1+int(rnd.NormFloat64()*16), 0, h-1)
+		r.X1 = clampInt(r.X1+r.Worker.offset(d), 0, w-1)
+		r.Y1 = clampInt(r.Y1+r.Worker.offset(d), 0, h-1)
 	case 1:
-		r.X2 = clampInt(r.X2+int(rnd.NormFloat64()*16), 0, w-1)
-		r.Y2 = clampInt(r.Y2+int(rnd.NormFloat64()*16), 0, h-1)
+		r.X2 = clampInt(r.X2+r.Worker.offset(d), 0, w-1)
+		r.Y2 = clampInt(r.Y2+r.Worker.offset(d), 0, h-1)
+	}
+	r.limitAspect()
+	if r.Worker.gridded() {
+		r.snap()
 	}
 }
 
@@ -86,10 +152,14 @@ type RotatedRectangle struct {
 
 func NewRandomRotatedRectangle(worker *Worker) *RotatedRectangle {
 	rnd := worker.Rnd
-	x := rnd.Intn(worker.W)
-	y := rnd.Intn(worker.H)
+	x, y := worker.randomPoint()
 	sx := rnd.Intn(32) + 1
 	sy := rnd.Intn(32) + 1
+	if s, ok := worker.fixedSize(); ok {
+		sx = int(s)
+		s, _ = worker.fixedSize()
+		sy = int(s)
+	}
 	a := rnd.Intn(360)
 	r := &RotatedRectangle{worker, x, y, sx, sy, a}
 	r.Mutate()
@@ -121,16 +191,25 @@ func (r *RotatedRectangle) Mutate() {
 	w := r.Worker.W
 	h := r.Worker.H
 	rnd := r.Worker.Rnd
-	switch rnd.Intn(3) {
+	d := 16 * r.Worker.mutationScale(ShapeTypeRotatedRectangle)
+	switch r.Worker.mutationChoice(ShapeTypeRotatedRectangle, 3, 0) {
 	case 0:
-		r.X = clampInt(r.X+int(rnd.NormFloat64()*16), 0, w-1)
-		r.Y = clampInt(r.Y+int(rnd.NormFloat64()*16), 0, h-1)
+		r.X = clampInt(r.X+int(rnd.NormFloat64()*d), 0, w-1)
+		r.Y = clampInt(r.Y+int(rnd.NormFloat64()*d), 0, h-1)
 	case 1:
-		r.Sx = clampInt(r.Sx+int(rnd.NormFloat64()*16), 1, w-1)
-		r.Sy = clampInt(r.Sy+int(rnd.NormFloat64()*16), 1, h-1)
+		if s, ok := r.Worker.fixedSize(); ok {
+			r.Sx = clampInt(int(s), 1, w-1)
+			s, _ = r.Worker.fixedSize()
+			r.Sy = clampInt(int(s), 1, h-1)
+			break
+		}
+		r.Sx = clampInt(r.Sx+int(rnd.NormFloat64()*d), 1, w-1)
+		r.Sy = clampInt(r.Sy+int(rnd.NormFloat64()*d), 1, h-1)
 	case 2:
-		r.Angle = r.Angle + int(rnd.NormFloat64()*32)
+		r.Angle = r.Angle + int(rnd.NormFloat64()*d*2)
 	}
+	sx, sy := r.Worker.limitAspect(float64(r.Sx), float64(r.Sy))
+	r.Sx, r.Sy = maxInt(int(sx), 1), maxInt(int(sy), 1)
 	// for !r.Valid() {
 	// 	r.Sx = clampInt(r.Sx+int(rnd.NormFloat64()*16), 0, w-1)
 	// 	r.Sy = clampInt(r.Sy+int(rnd.NormFloat64()*16), 0, h-1)
@@ -149,12 +228,20 @@ func (r *RotatedRectangle) Valid() bool {
 func (r *RotatedRectangle) Rasterize() []Scanline {
 	w := r.Worker.W
 	h := r.Worker.H
+	if r.Sx < 1 || r.Sy < 1 {
+		return r.Worker.Lines[:0]
+	}
 	sx, sy := float64(r.Sx), float64(r.Sy)
 	angle := radians(float64(r.Angle))
 	rx1, ry1 := rotate(-sx/2, -sy/2, angle)
 	rx2, ry2 := rotate(sx/2, -sy/2, angle)
 	rx3, ry3 := rotate(sx/2, sy/2, angle)
 	rx4, ry4 := rotate(-sx/2, sy/2, angle)
+	if r.Worker.AntialiasSearch {
+		cx, cy := float64(r.X)+0.5, float64(r.Y)+0.5
+		return fillPath(r.Worker, polygonPath(
+			rx1+cx, ry1+cy, rx2+cx, ry2+cy, rx3+cx, ry3+cy, rx4+cx, ry4+cy))
+	}
 	x1, y1 := int(rx1)+r.X, int(ry1)+r.Y
 	x2, y2 := int(rx2)+r.X, int(ry2)+r.Y
 	x3, y3 := int(rx3)+r.X, int(ry3)+r.Y
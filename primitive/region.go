@@ -0,0 +1,104 @@
+package primitive
+
+import (
+	"image"
+	"math"
+)
+
+// ReprocessRegion adds count shapes of type t at alpha inside the box x, y,
+// w, h of the target, such as an area of a finished render that needs more
+// detail, and returns the number added. New shapes start inside the box and
+// must lie wholly within it, so the canvas outside is left as it is, and
+// only the error inside the box counts while they are searched for. The box
+// is clipped to the target, and any placement mask still applies within it.
+// Afterwards the model scores the whole image again.
+func (model *Model) ReprocessRegion(x, y, w, h int, t ShapeType, alpha, count int) int {
+	r := image.Rect(x, y, x+w, y+h).Intersect(model.Target.Bounds())
+	if r.Empty() || count <= 0 {
+		return 0
+	}
+	placement := model.placement
+	defer func() {
+		model.region = image.Rectangle{}
+		model.placement = placement
+		model.updateWeights()
+	}()
+	model.region = r
+	model.placement = regionPlacement(r, placement, model.Target.Rect.Dx(), model.Target.Rect.Dy())
+	model.updateWeights()
+	n := len(model.Shapes)
+	for i := 0; i < count; i++ {
+		model.Step(t, alpha, 0)
+	}
+	return len(model.Shapes) - n
+}
+
+// shapeRegion returns the box shapes must lie within, the region of
+// ReprocessRegion inset by Margin, or the empty rectangle for anywhere.
+func (model *Model) shapeRegion() image.Rectangle {
+	if model.Margin <= 0 {
+		return model.region
+	}
+	w, h := model.Target.Rect.Dx(), model.Target.Rect.Dy()
+	m := int(math.Round(math.Min(model.Margin, 0.49) * float64(minInt(w, h))))
+	inset := image.Rect(m, m, w-m, h-m)
+	if !model.region.Empty() {
+		inset = inset.Intersect(model.region)
+		if inset.Empty() {
+			// a region wholly inside the margin allows nothing, which an
+			// empty rectangle, meaning anywhere, cannot say; no shape
+			// lies within a box off the canvas
+			return image.Rect(-2, -2, -1, -1)
+		}
+	}
+	return inset
+}
+
+// regionPlacement returns a w x h placement mask of the pixels in r that
+// placement, if set, also allows.
+func regionPlacement(r image.Rectangle, placement []bool, w, h int) []bool {
+	result := make([]bool, w*h)
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			i := y*w + x
+			result[i] = placement == nil || placement[i]
+		}
+	}
+	return result
+}
+
+// linesWithin reports whether lines lie wholly inside r.
+func linesWithin(lines []Scanline, r image.Rectangle) bool {
+	for _, line := range lines {
+		if line.Y < r.Min.Y || line.Y >= r.Max.Y || line.X1 < r.Min.X || line.X2 >= r.Max.X {
+			return false
+		}
+	}
+	return true
+}
+
+// randomPoint returns a random pixel for a new shape to start at, inside
+// the region if there is one.
+func (worker *Worker) randomPoint() (int, int) {
+	r := worker.bounds()
+	x := r.Min.X + worker.Rnd.Intn(r.Dx())
+	y := r.Min.Y + worker.Rnd.Intn(r.Dy())
+	return x, y
+}
+
+// randomPointF is randomPoint for shapes with fractional coordinates.
+func (worker *Worker) randomPointF() (float64, float64) {
+	r := worker.bounds()
+	x := float64(r.Min.X) + worker.Rnd.Float64()*float64(r.Dx())
+	y := float64(r.Min.Y) + worker.Rnd.Float64()*float64(r.Dy())
+	return x, y
+}
+
+// bounds returns the area new shapes start in: the region, or the whole
+// target.
+func (worker *Worker) bounds() image.Rectangle {
+	if !worker.Region.Empty() {
+		return worker.Region
+	}
+	return image.Rect(0, 0, worker.W, worker.H)
+}
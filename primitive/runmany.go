@@ -0,0 +1,95 @@
+package primitive
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"sync"
+)
+
+// A RunConfig is one search for RunMany: Count steps of Shape at Alpha,
+// each with Repeat, on a new model of Target over Background whose renders
+// fit Size, as NewModel takes them. Workers is the model's worker count; 0
+// gives it an even share of RunMany's budget. Configure, if set, is called
+// with the new model before the search, to set its fields, masks or seed.
+type RunConfig struct {
+	Target     image.Image
+	Background Color
+	Size       int
+	Workers    int
+	Shape      ShapeType
+	Count      int
+	Alpha      int
+	Repeat     int
+	Configure  func(*Model)
+}
+
+// RunMany runs the searches of configs concurrently and returns their
+// models, in the same order. Together they use at most maxTotalWorkers
+// worker goroutines: each search is started, in order, once enough of the
+// budget is free for its workers, so when the configs want more than the
+// budget some wait for others to finish. A config wanting more workers than
+// the whole budget gets the budget. It returns an error, and no models, if
+// the budget is not positive or a config has no target or a negative count.
+// Searches that reach their MaxCumulativeCoverage stop early, as Step does.
+func RunMany(configs []RunConfig, maxTotalWorkers int) ([]*Model, error) {
+	if maxTotalWorkers < 1 {
+		return nil, fmt.Errorf("run many: maxTotalWorkers must be positive, got %d", maxTotalWorkers)
+	}
+	workers := make([]int, len(configs))
+	for i, config := range configs {
+		if config.Target == nil || config.Target.Bounds().Empty() {
+			return nil, fmt.Errorf("run many: config %d has no target", i)
+		}
+		if config.Count < 0 {
+			return nil, fmt.Errorf("run many: config %d has a negative count, %d", i, config.Count)
+		}
+		n := config.Workers
+		if n <= 0 {
+			n = maxTotalWorkers / len(configs)
+		}
+		workers[i] = clampInt(n, 1, maxTotalWorkers)
+	}
+
+	models := make([]*Model, len(configs))
+	var mu sync.Mutex
+	free := sync.NewCond(&mu)
+	used := 0
+	var wg sync.WaitGroup
+	for i, config := range configs {
+		n := workers[i]
+		mu.Lock()
+		for used+n > maxTotalWorkers {
+			free.Wait()
+		}
+		used += n
+		mu.Unlock()
+		wg.Add(1)
+		go func(i int, config RunConfig) {
+			defer wg.Done()
+			models[i] = config.run(n)
+			mu.Lock()
+			used -= n
+			free.Broadcast()
+			mu.Unlock()
+		}(i, config)
+	}
+	wg.Wait()
+	return models, nil
+}
+
+// run makes the config's model with the given number of workers and runs
+// its search.
+func (config RunConfig) run(workers int) *Model {
+	model := NewModel(config.Target, config.Background, config.Size, workers)
+	if config.Configure != nil {
+		config.Configure(model)
+	}
+	for i := 0; i < config.Count; i++ {
+		if _, err := model.StepContext(context.Background(), config.Shape, config.Alpha, config.Repeat); errors.Is(err, ErrCoverageLimit) {
+			break
+		}
+	}
+	return model
+}
@@ -1,5 +1,7 @@
 package primitive
 
+import "sort"
+
 type Scanline struct {
 	Y, X1, X2 int
 	Alpha     uint32
@@ -27,3 +29,51 @@ func cropScanlines(lines []Scanline, w, h int) []Scanline {
 	}
 	return lines[:i]
 }
+
+// dilateLines returns the pixels within n of lines, horizontally and
+// vertically, as scanlines clipped to a w by h image. For n of 0 or less it
+// returns lines unchanged.
+func dilateLines(lines []Scanline, n, w, h int) []Scanline {
+	if n <= 0 || len(lines) == 0 {
+		return lines
+	}
+	y0, y1 := lines[0].Y, lines[0].Y
+	for _, line := range lines {
+		y0 = minInt(y0, line.Y)
+		y1 = maxInt(y1, line.Y)
+	}
+	y0 = maxInt(y0-n, 0)
+	y1 = minInt(y1+n, h-1)
+	if y0 > y1 {
+		return nil
+	}
+	rows := make([][][2]int, y1-y0+1)
+	for _, line := range lines {
+		x1 := maxInt(line.X1-n, 0)
+		x2 := minInt(line.X2+n, w-1)
+		if x1 > x2 {
+			continue
+		}
+		for y := maxInt(line.Y-n, y0); y <= minInt(line.Y+n, y1); y++ {
+			rows[y-y0] = append(rows[y-y0], [2]int{x1, x2})
+		}
+	}
+	var result []Scanline
+	for i, spans := range rows {
+		if len(spans) == 0 {
+			continue
+		}
+		sort.Slice(spans, func(a, b int) bool { return spans[a][0] < spans[b][0] })
+		span := spans[0]
+		for _, s := range spans[1:] {
+			if s[0] <= span[1]+1 {
+				span[1] = maxInt(span[1], s[1])
+				continue
+			}
+			result = append(result, Scanline{y0 + i, span[0], span[1], 0xffff})
+			span = s
+		}
+		result = append(result, Scanline{y0 + i, span[0], span[1], 0xffff})
+	}
+	return result
+}
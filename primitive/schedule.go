@@ -0,0 +1,74 @@
+package primitive
+
+import "math"
+
+// A MutationSchedule maps the index of the shape being searched for to a
+// multiplier on the size of mutation moves. A multiplier of 1 gives the
+// standard step sizes.
+type MutationSchedule func(step int) float64
+
+func ConstantSchedule(scale float64) MutationSchedule {
+	return func(step int) float64 {
+		return scale
+	}
+}
+
+// LinearSchedule moves from `from` to `to` over the given number of steps and
+// then holds at `to`.
+func LinearSchedule(from, to float64, steps int) MutationSchedule {
+	return func(step int) float64 {
+		if step >= steps {
+			return to
+		}
+		t := float64(step) / float64(steps)
+		return from + (to-from)*t
+	}
+}
+
+// ExponentialSchedule decays geometrically from `from` to `to` over the given
+// number of steps and then holds at `to`.
+func ExponentialSchedule(from, to float64, steps int) MutationSchedule {
+	return func(step int) float64 {
+		if step >= steps {
+			return to
+		}
+		t := float64(step) / float64(steps)
+		return from * math.Pow(to/from, t)
+	}
+}
+
+// DefaultMutationSchedules returns tuned schedules for a run of the given
+// number of shapes. Round shapes benefit most from large early moves that
+// shrink as the image fills in; straight-edged shapes decay more gently.
+// Models use constant schedules unless these are installed.
+func DefaultMutationSchedules(steps int) map[ShapeType]MutationSchedule {
+	return map[ShapeType]MutationSchedule{
+		ShapeTypeTriangle:         ExponentialSchedule(1.5, 0.5, steps),
+		ShapeTypeRectangle:        ExponentialSchedule(1.5, 0.5, steps),
+		ShapeTypeEllipse:          ExponentialSchedule(2, 0.25, steps),
+		ShapeTypeCircle:           ExponentialSchedule(2, 0.25, steps),
+		ShapeTypeRotatedRectangle: ExponentialSchedule(1.5, 0.5, steps),
+		ShapeTypeQuadratic:        LinearSchedule(1, 0.5, steps),
+		ShapeTypeRotatedEllipse:   ExponentialSchedule(2, 0.25, steps),
+		ShapeTypePolygon:          ExponentialSchedule(1.5, 0.5, steps),
+	}
+}
+
+// ExponentialRestarts gives a Model.RestartDecay that decays geometrically
+// from `from` random starts per shape to `to` over the given number of steps
+// and then holds at `to`.
+func ExponentialRestarts(from, to, steps int) func(step int) int {
+	schedule := ExponentialSchedule(float64(from), float64(to), steps)
+	return func(step int) int {
+		return int(math.Round(schedule(step)))
+	}
+}
+
+// DefaultRestartDecay returns a tuned Model.RestartDecay for a run of the
+// given number of shapes: half again the default random starts for the
+// first shape, falling to a quarter of them by the last. Early shapes are
+// large and costly to search, so it runs in about the same time as the
+// default for about the same score, with more of the effort where it pays.
+func DefaultRestartDecay(steps int) func(step int) int {
+	return ExponentialRestarts(3*DefaultCandidatesPerStep/2, DefaultCandidatesPerStep/4, steps)
+}
@@ -0,0 +1,367 @@
+package primitive
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// ShapeList is the JSON form of a model's shapes. Width and Height are the
+// working (target) dimensions that shape coordinates refer to.
+type ShapeList struct {
+	Width      int           `json:"width"`
+	Height     int           `json:"height"`
+	Background string        `json:"background"`
+	Shapes     []ShapeRecord `json:"shapes"`
+	Phases     []PhaseRecord `json:"phases,omitempty"`
+}
+
+// PhaseRecord is a serialized Phase. It is informational and is not used
+// when shapes are loaded.
+type PhaseRecord struct {
+	Type  string `json:"type"`
+	Count int    `json:"count"`
+}
+
+// ShapeRecord is one serialized shape. Params holds the shape's geometry in
+// working coordinates, in this order:
+//
+//	triangle:         x1 y1 x2 y2 x3 y3
+//	rectangle:        x1 y1 x2 y2
+//	ellipse:          x y rx ry
+//	circle:           x y r
+//	rotatedrectangle: x y sx sy angle
+//	quadratic:        x1 y1 x2 y2 x3 y3 width
+//	rotatedellipse:   x y rx ry angle
+//	polygon:          x1 y1 x2 y2 ...
+//
+// A gradient-filled shape also has Gradient, and its Color is the gradient's
+// mean.
+//
+// Shapes are replayed in ascending Z order, where a missing Z counts as zero.
+// Shapes with equal Z keep their order in the list, so a list without any Z
+// fields replays in array order.
+type ShapeRecord struct {
+	Type   string    `json:"type"`
+	Color  string    `json:"color"`
+	Z      *float64  `json:"z,omitempty"`
+	Params []float64 `json:"params"`
+
+	Gradient *GradientRecord `json:"gradient,omitempty"`
+}
+
+// GradientRecord is a serialized Gradient, in working coordinates.
+type GradientRecord struct {
+	X0   float64 `json:"x0"`
+	Y0   float64 `json:"y0"`
+	X1   float64 `json:"x1"`
+	Y1   float64 `json:"y1"`
+	From string  `json:"from"`
+	To   string  `json:"to"`
+}
+
+func gradientRecord(g *Gradient) *GradientRecord {
+	if g == nil {
+		return nil
+	}
+	return &GradientRecord{g.X0, g.Y0, g.X1, g.Y1, hexColor(g.From), hexColor(g.To)}
+}
+
+func (r *GradientRecord) gradient() (*Gradient, error) {
+	if r == nil {
+		return nil, nil
+	}
+	from, err := parseHexColor(r.From)
+	if err != nil {
+		return nil, err
+	}
+	to, err := parseHexColor(r.To)
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range []float64{r.X0, r.Y0, r.X1, r.Y1} {
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return nil, fmt.Errorf("gradient point is not a number")
+		}
+	}
+	if r.X0 == r.X1 && r.Y0 == r.Y1 {
+		return nil, fmt.Errorf("gradient has no length")
+	}
+	return &Gradient{r.X0, r.Y0, r.X1, r.Y1, from, to}, nil
+}
+
+var shapeTypeNames = map[ShapeType]string{
+	ShapeTypeTriangle:         "triangle",
+	ShapeTypeRectangle:        "rectangle",
+	ShapeTypeEllipse:          "ellipse",
+	ShapeTypeCircle:           "circle",
+	ShapeTypeRotatedRectangle: "rotatedrectangle",
+	ShapeTypeQuadratic:        "quadratic",
+	ShapeTypeRotatedEllipse:   "rotatedellipse",
+	ShapeTypePolygon:          "polygon",
+}
+
+func (t ShapeType) String() string {
+	if name, ok := shapeTypeNames[t]; ok {
+		return name
+	}
+	return "any"
+}
+
+// shapeTypeOf reports the type of a concrete shape.
+func shapeTypeOf(shape Shape) ShapeType {
+	switch s := shape.(type) {
+	case *Triangle:
+		return ShapeTypeTriangle
+	case *Rectangle:
+		return ShapeTypeRectangle
+	case *Ellipse:
+		if s.Circle {
+			return ShapeTypeCircle
+		}
+		return ShapeTypeEllipse
+	case *RotatedRectangle:
+		return ShapeTypeRotatedRectangle
+	case *Quadratic:
+		return ShapeTypeQuadratic
+	case *RotatedEllipse:
+		return ShapeTypeRotatedEllipse
+	case *Polygon:
+		return ShapeTypePolygon
+	}
+	return ShapeTypeAny
+}
+
+// ShapeStats counts the model's shapes by type. With ShapeTypeAny it shows
+// which types the search chose.
+func (model *Model) ShapeStats() map[ShapeType]int {
+	stats := make(map[ShapeType]int)
+	for _, shape := range model.Shapes {
+		stats[shapeTypeOf(shape)]++
+	}
+	return stats
+}
+
+func hexColor(c Color) string {
+	return fmt.Sprintf("#%02x%02x%02x%02x", c.R, c.G, c.B, c.A)
+}
+
+func parseHexColor(x string) (Color, error) {
+	switch len(strings.TrimPrefix(x, "#")) {
+	case 3, 4, 6, 8:
+		return MakeHexColor(x), nil
+	}
+	return Color{}, fmt.Errorf("invalid color %q", x)
+}
+
+func shapeParams(shape Shape) []float64 {
+	switch s := shape.(type) {
+	case *Triangle:
+		return []float64{float64(s.X1), float64(s.Y1), float64(s.X2), float64(s.Y2), float64(s.X3), float64(s.Y3)}
+	case *Rectangle:
+		return []float64{float64(s.X1), float64(s.Y1), float64(s.X2), float64(s.Y2)}
+	case *Ellipse:
+		if s.Circle {
+			return []float64{float64(s.X), float64(s.Y), float64(s.Rx)}
+		}
+		return []float64{float64(s.X), float64(s.Y), float64(s.Rx), float64(s.Ry)}
+	case *RotatedRectangle:
+		return []float64{float64(s.X), float64(s.Y), float64(s.Sx), float64(s.Sy), float64(s.Angle)}
+	case *Quadratic:
+		return []float64{s.X1, s.Y1, s.X2, s.Y2, s.X3, s.Y3, s.Width}
+	case *RotatedEllipse:
+		return []float64{s.X, s.Y, s.Rx, s.Ry, s.Angle}
+	case *Polygon:
+		params := make([]float64, 0, s.Order*2)
+		for i := 0; i < s.Order; i++ {
+			params = append(params, s.X[i], s.Y[i])
+		}
+		return params
+	}
+	return nil
+}
+
+// MaxShapeListSize is the largest width or height UnmarshalShapes accepts,
+// since replaying a list allocates a canvas of that size.
+const MaxShapeListSize = 4096
+
+// shapeReach is the margin, in working pixels, that newShape adds to the
+// canvas size when bounding params. The search keeps shapes within 16
+// pixels of the canvas and no larger than it, so the bound only turns away
+// shapes that would take far more memory and time to rasterize than they
+// could cover.
+const shapeReach = 64
+
+// newShape builds a shape of the named type from its params, bound to the
+// given worker. It validates the number of params and their range. Angles
+// may be any finite value and are wrapped to within a turn; the other
+// params must lie between -limit and 2*limit, where limit is the larger
+// side of the worker's canvas plus shapeReach.
+func newShape(worker *Worker, name string, p []float64) (Shape, error) {
+	want := map[string]int{
+		"triangle": 6, "rectangle": 4, "ellipse": 4, "circle": 3,
+		"rotatedrectangle": 5, "quadratic": 7, "rotatedellipse": 5,
+	}
+	if n, ok := want[name]; ok && len(p) != n {
+		return nil, fmt.Errorf("%s needs %d params, got %d", name, n, len(p))
+	}
+	p = append([]float64(nil), p...)
+	limit := float64(maxInt(worker.W, worker.H) + shapeReach)
+	for k, v := range p {
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return nil, fmt.Errorf("%s param %d is not a number", name, k)
+		}
+		if k == 4 && (name == "rotatedrectangle" || name == "rotatedellipse") {
+			p[k] = math.Mod(v, 360)
+			continue
+		}
+		if v < -limit || v > 2*limit {
+			return nil, fmt.Errorf("%s param %d, %g, is too far off the %dx%d canvas", name, k, v, worker.W, worker.H)
+		}
+	}
+	i := func(k int) int { return int(p[k]) }
+	switch name {
+	case "triangle":
+		return &Triangle{worker, i(0), i(1), i(2), i(3), i(4), i(5)}, nil
+	case "rectangle":
+		return &Rectangle{worker, i(0), i(1), i(2), i(3)}, nil
+	case "ellipse":
+		return &Ellipse{worker, i(0), i(1), i(2), i(3), false}, nil
+	case "circle":
+		return &Ellipse{worker, i(0), i(1), i(2), i(2), true}, nil
+	case "rotatedrectangle":
+		return &RotatedRectangle{worker, i(0), i(1), i(2), i(3), i(4)}, nil
+	case "quadratic":
+		return &Quadratic{worker, p[0], p[1], p[2], p[3], p[4], p[5], p[6]}, nil
+	case "rotatedellipse":
+		return &RotatedEllipse{worker, p[0], p[1], p[2], p[3], p[4]}, nil
+	case "polygon":
+		if len(p) < 6 || len(p)%2 != 0 {
+			return nil, fmt.Errorf("polygon needs an even number of params, at least 6, got %d", len(p))
+		}
+		n := len(p) / 2
+		x := make([]float64, n)
+		y := make([]float64, n)
+		for j := 0; j < n; j++ {
+			x[j] = p[j*2]
+			y[j] = p[j*2+1]
+		}
+		return &Polygon{worker, n, false, x, y}, nil
+	}
+	return nil, fmt.Errorf("unknown shape type %q", name)
+}
+
+// ShapeList returns the model's shapes in their serializable form.
+func (model *Model) ShapeList() *ShapeList {
+	size := model.Target.Bounds().Size()
+	list := &ShapeList{
+		Width:      size.X,
+		Height:     size.Y,
+		Background: hexColor(model.Background),
+		Shapes:     make([]ShapeRecord, len(model.Shapes)),
+	}
+	for _, phase := range model.Phases {
+		list.Phases = append(list.Phases, PhaseRecord{phase.Type.String(), phase.Count})
+	}
+	for i, shape := range model.Shapes {
+		list.Shapes[i] = ShapeRecord{
+			Type:     shapeTypeOf(shape).String(),
+			Color:    hexColor(model.Colors[i]),
+			Params:   shapeParams(shape),
+			Gradient: gradientRecord(model.Gradients[i]),
+		}
+	}
+	return list
+}
+
+func (model *Model) MarshalShapes() ([]byte, error) {
+	return json.Marshal(model.ShapeList())
+}
+
+func UnmarshalShapes(data []byte) (*ShapeList, error) {
+	var list ShapeList
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, err
+	}
+	if list.Width <= 0 || list.Height <= 0 || list.Width > MaxShapeListSize || list.Height > MaxShapeListSize {
+		return nil, fmt.Errorf("invalid size %dx%d", list.Width, list.Height)
+	}
+	if _, err := parseHexColor(list.Background); err != nil {
+		return nil, fmt.Errorf("background: %v", err)
+	}
+	return &list, nil
+}
+
+// LoadShapes replays serialized shapes, with their stored colors, on top of
+// the model's current canvas. The list must have been made for a target of
+// the same working size. Nothing is added if any shape is invalid.
+func (model *Model) LoadShapes(data []byte) error {
+	list, err := UnmarshalShapes(data)
+	if err != nil {
+		return err
+	}
+	return model.AddShapeList(list)
+}
+
+func (model *Model) AddShapeList(list *ShapeList) error {
+	size := model.Target.Bounds().Size()
+	if list.Width != size.X || list.Height != size.Y {
+		return fmt.Errorf("shapes are for a %dx%d canvas, model is %dx%d",
+			list.Width, list.Height, size.X, size.Y)
+	}
+	if len(model.Workers) == 0 {
+		return fmt.Errorf("model has no workers")
+	}
+	// the shapes are bound to the first worker, so it needs the model's
+	// settings for them to draw as they should
+	model.initWorker(model.Workers[0])
+	records := make([]ShapeRecord, len(list.Shapes))
+	copy(records, list.Shapes)
+	sort.SliceStable(records, func(i, j int) bool {
+		var zi, zj float64
+		if records[i].Z != nil {
+			zi = *records[i].Z
+		}
+		if records[j].Z != nil {
+			zj = *records[j].Z
+		}
+		return zi < zj
+	})
+	shapes := make([]Shape, len(records))
+	colors := make([]Color, len(records))
+	gradients := make([]*Gradient, len(records))
+	for i, record := range records {
+		shape, err := newShape(model.Workers[0], record.Type, record.Params)
+		if err != nil {
+			return fmt.Errorf("shape %d: %v", i, err)
+		}
+		color, err := parseHexColor(record.Color)
+		if err != nil {
+			return fmt.Errorf("shape %d: %v", i, err)
+		}
+		gradient, err := record.Gradient.gradient()
+		if err != nil {
+			return fmt.Errorf("shape %d: %v", i, err)
+		}
+		shapes[i] = shape
+		colors[i] = color
+		gradients[i] = gradient
+	}
+	for i, shape := range shapes {
+		model.addLines(shape, colors[i], gradients[i], model.shapeLines(shape))
+	}
+	return nil
+}
+
+// Merge appends other's shapes, with their colors and gradients, to the
+// model's, as if they had been added after its own, and updates the score
+// against the model's target. The two models must have the same working
+// size. other is not changed.
+func (model *Model) Merge(other *Model) error {
+	if err := model.AddShapeList(other.ShapeList()); err != nil {
+		return fmt.Errorf("merge: %v", err)
+	}
+	return nil
+}
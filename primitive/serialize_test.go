@@ -0,0 +1,52 @@
+package primitive
+
+import (
+	"image"
+	"strings"
+	"testing"
+)
+
+func TestUnmarshalShapesRejectsHugeCanvas(t *testing.T) {
+	_, err := UnmarshalShapes([]byte(`{"width":100000,"height":100000,"background":"#fff","shapes":[]}`))
+	if err == nil {
+		t.Fatal("a 100000x100000 list was accepted")
+	}
+}
+
+func TestAddShapeListRejectsFarOffShapes(t *testing.T) {
+	model := NewModel(image.NewNRGBA(image.Rect(0, 0, 64, 48)), MakeHexColor("#fff"), 128, 1)
+	for _, shape := range []string{
+		`{"type":"triangle","color":"#000","params":[0,0,10,2000000000,20,-2000000000]}`,
+		`{"type":"ellipse","color":"#000","params":[10,10,1e9,5]}`,
+		`{"type":"polygon","color":"#000","params":[0,0,10,0,-1e12,10]}`,
+	} {
+		list, err := UnmarshalShapes([]byte(`{"width":64,"height":48,"background":"#fff","shapes":[` + shape + `]}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := model.AddShapeList(list); err == nil || !strings.Contains(err.Error(), "too far off") {
+			t.Fatalf("%s: err = %v, want too far off the canvas", shape, err)
+		}
+	}
+	if len(model.Shapes) != 0 {
+		t.Fatalf("%d shapes were added", len(model.Shapes))
+	}
+
+	// shapes the search could make, and any angle, still load
+	list, err := UnmarshalShapes([]byte(`{"width":64,"height":48,"background":"#fff","shapes":[
+		{"type":"triangle","color":"#000","params":[-16,-16,79,10,20,63]},
+		{"type":"rotatedellipse","color":"#000","params":[10,10,63,5,12345]}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := model.AddShapeList(list); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNewModelExtremeAspect(t *testing.T) {
+	model := NewModel(image.NewNRGBA(image.Rect(0, 0, 4000, 1)), MakeHexColor("#fff"), 1024, 1)
+	if model.Sw < 1 || model.Sh < 1 {
+		t.Fatalf("output size %dx%d", model.Sw, model.Sh)
+	}
+}
@@ -0,0 +1,119 @@
+package primitive
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"math"
+
+	"github.com/fogleman/gg"
+)
+
+// shadowEnabled reports whether shapes cast a drop shadow.
+func (model *Model) shadowEnabled() bool {
+	return model.ShadowColor.A > 0
+}
+
+// drawShadow draws the shadow of a shape onto dc, whose transform scales
+// working coordinates by sx, sy. The shadow's opacity is ShadowColor's alpha
+// times the shape's.
+func (model *Model) drawShadow(dc *gg.Context, shape Shape, c Color, sx, sy float64) {
+	s := model.ShadowColor
+	mask := gg.NewContext(dc.Width(), dc.Height())
+	mask.Scale(sx, sy)
+	mask.Translate(0.5+model.ShadowOffset.X, 0.5+model.ShadowOffset.Y)
+	mask.SetRGBA255(s.R, s.G, s.B, s.A*c.A/255)
+	mask.SetLineJoin(model.StrokeJoin.lineJoin())
+	shape.Draw(mask, (sx+sy)/2)
+	mask.Fill()
+	im := mask.Image().(*image.RGBA)
+
+	bounds := opaqueBounds(im)
+	if bounds.Empty() {
+		return
+	}
+	radius := int(math.Round(model.ShadowBlur * (sx + sy) / 2))
+	if radius > 0 {
+		bounds = bounds.Inset(-radius * 3).Intersect(im.Rect)
+		// three box blurs of this radius approximate a gaussian with a
+		// standard deviation of about radius
+		for i := 0; i < 3; i++ {
+			boxBlur(im, bounds, radius)
+		}
+	}
+
+	draw.Draw(dc.Image().(*image.RGBA), bounds, im, bounds.Min, draw.Over)
+}
+
+// opaqueBounds returns the smallest rectangle holding every pixel of im
+// with non-zero alpha.
+func opaqueBounds(im *image.RGBA) image.Rectangle {
+	var r image.Rectangle
+	for y := im.Rect.Min.Y; y < im.Rect.Max.Y; y++ {
+		i := im.PixOffset(im.Rect.Min.X, y)
+		for x := im.Rect.Min.X; x < im.Rect.Max.X; x++ {
+			if im.Pix[i+3] != 0 {
+				r = r.Union(image.Rect(x, y, x+1, y+1))
+			}
+			i += 4
+		}
+	}
+	return r
+}
+
+// boxBlur blurs the pixels of im within r, horizontally then vertically,
+// with a box of the given radius. Pixels outside r are treated as clear.
+func boxBlur(im *image.RGBA, r image.Rectangle, radius int) {
+	w, h := r.Dx(), r.Dy()
+	n := 2*radius + 1
+	line := make([]int, maxInt(w, h)*4)
+	pass := func(count, length int, offset func(i, j int) int) {
+		for i := 0; i < count; i++ {
+			for j := 0; j < length; j++ {
+				p := offset(i, j)
+				copy4(line[j*4:], im.Pix[p:p+4])
+			}
+			var sum [4]int
+			for j := 0; j < radius && j < length; j++ {
+				for k := 0; k < 4; k++ {
+					sum[k] += line[j*4+k]
+				}
+			}
+			for j := 0; j < length; j++ {
+				if a := j + radius; a < length {
+					for k := 0; k < 4; k++ {
+						sum[k] += line[a*4+k]
+					}
+				}
+				if b := j - radius - 1; b >= 0 {
+					for k := 0; k < 4; k++ {
+						sum[k] -= line[b*4+k]
+					}
+				}
+				p := offset(i, j)
+				for k := 0; k < 4; k++ {
+					im.Pix[p+k] = uint8(sum[k] / n)
+				}
+			}
+		}
+	}
+	pass(h, w, func(y, x int) int { return im.PixOffset(r.Min.X+x, r.Min.Y+y) })
+	pass(w, h, func(x, y int) int { return im.PixOffset(r.Min.X+x, r.Min.Y+y) })
+}
+
+func copy4(dst []int, src []uint8) {
+	dst[0] = int(src[0])
+	dst[1] = int(src[1])
+	dst[2] = int(src[2])
+	dst[3] = int(src[3])
+}
+
+// svgShadowFilter returns the filter definition that approximates the
+// shadow in SVG output.
+func (model *Model) svgShadowFilter() string {
+	s := model.ShadowColor
+	return fmt.Sprintf("<defs><filter id=\"shadow\" x=\"-50%%\" y=\"-50%%\" width=\"200%%\" height=\"200%%\">"+
+		"<feDropShadow dx=\"%f\" dy=\"%f\" stdDeviation=\"%f\" flood-color=\"#%02x%02x%02x\" flood-opacity=\"%f\" />"+
+		"</filter></defs>",
+		model.ShadowOffset.X, model.ShadowOffset.Y, model.ShadowBlur, s.R, s.G, s.B, float64(s.A)/255)
+}
@@ -0,0 +1,59 @@
+package primitive
+
+import "image"
+
+// sharpenKernel is the Gaussian, of a standard deviation of one pixel, that
+// sharpenImage subtracts.
+var sharpenKernel = [5]int{1, 4, 6, 4, 1}
+
+// sharpenImage returns im with an unsharp mask of the given amount: each
+// pixel moves away from its blur, a Gaussian of one output pixel, by amount
+// times their difference, which crisps up the edges. The image is
+// premultiplied, so colors are kept within alpha. src is not changed.
+func sharpenImage(src image.Image, amount float64) image.Image {
+	im := imageToRGBA(src)
+	w, h := im.Rect.Dx(), im.Rect.Dy()
+	blur := make([]int, len(im.Pix))
+	rows := make([]int, len(im.Pix))
+	// rows then columns, taking pixels past the edges as the edge pixels
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var sum [4]int
+			for k, f := range sharpenKernel {
+				i := im.PixOffset(clampInt(x+k-2, 0, w-1), y)
+				for c := 0; c < 4; c++ {
+					sum[c] += f * int(im.Pix[i+c])
+				}
+			}
+			i := im.PixOffset(x, y)
+			for c := 0; c < 4; c++ {
+				rows[i+c] = sum[c]
+			}
+		}
+	}
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var sum [4]int
+			for k, f := range sharpenKernel {
+				i := im.PixOffset(x, clampInt(y+k-2, 0, h-1))
+				for c := 0; c < 4; c++ {
+					sum[c] += f * rows[i+c]
+				}
+			}
+			i := im.PixOffset(x, y)
+			for c := 0; c < 4; c++ {
+				blur[i+c] = sum[c]
+			}
+		}
+	}
+	for i := 0; i < len(im.Pix); i += 4 {
+		a := clampInt(int(float64(im.Pix[i+3])+amount*(float64(im.Pix[i+3])-float64(blur[i+3])/256)+0.5), 0, 255)
+		for c := 0; c < 3; c++ {
+			v := float64(im.Pix[i+c])
+			v += amount * (v - float64(blur[i+c])/256)
+			im.Pix[i+c] = uint8(clampInt(int(v+0.5), 0, a))
+		}
+		im.Pix[i+3] = uint8(a)
+	}
+	return im
+}
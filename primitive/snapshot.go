@@ -0,0 +1,355 @@
+package primitive
+
+import (
+	"encoding/gob"
+	"fmt"
+	"image"
+	"io"
+	"math/rand"
+
+	"github.com/fogleman/gg"
+)
+
+// replaySource is a rand.Source that counts its draws, so that its state
+// can be saved as its seed and draw count and restored by replaying them.
+// It draws the same numbers as rand.NewSource.
+type replaySource struct {
+	src   rand.Source64
+	seed  int64
+	draws uint64
+}
+
+func newReplaySource(seed int64) *replaySource {
+	return &replaySource{src: rand.NewSource(seed).(rand.Source64), seed: seed}
+}
+
+func (s *replaySource) Int63() int64 {
+	s.draws++
+	return s.src.Int63()
+}
+
+func (s *replaySource) Uint64() uint64 {
+	s.draws++
+	return s.src.Uint64()
+}
+
+func (s *replaySource) Seed(seed int64) {
+	s.src.Seed(seed)
+	s.seed = seed
+	s.draws = 0
+}
+
+// seedWorker gives worker a fresh random source seeded with seed.
+func seedWorker(worker *Worker, seed int64) {
+	worker.source = newReplaySource(seed)
+	worker.Rnd = rand.New(worker.source)
+}
+
+// modelSnapshot is what SaveState writes.
+type modelSnapshot struct {
+	Target     *image.RGBA
+	Size       int
+	Background Color
+	Score      float64
+	Sources    []sourceSnapshot
+	Shapes     []shapeSnapshot
+	Settings   modelSettings
+
+	Weights        []float64
+	WeightNorm     float64
+	Masks          []maskSnapshot
+	ChannelWeights [4]float64
+	MaskCombine    MaskCombine
+	MaskWeights    []float64
+	Alpha          *image.Alpha
+	Placement      []bool
+	PreserveAlpha  bool
+	LinearLight    bool
+	BorderWidth    int
+	BorderColor    Color
+	HalftoneCell   int
+	HalftoneAngle  float64
+}
+
+type sourceSnapshot struct {
+	Seed  int64
+	Draws uint64
+}
+
+type shapeSnapshot struct {
+	Type     string
+	Params   []float64
+	Color    Color
+	Gradient *Gradient
+}
+
+type maskSnapshot struct {
+	Weights []float64
+	Weight  float64
+}
+
+// modelSettings holds the Model fields that a caller sets, apart from
+// MutationSchedules, RestartDecay and TargetToneCurve, which are functions.
+type modelSettings struct {
+	RenderScale         int
+	BlendMode           BlendMode
+	DebugColorMode      DebugColorMode
+	OutputWidth         int
+	OutputHeight        int
+	QuantizeColors      int
+	BackgroundAlpha     int
+	ShadowOffset        gg.Point
+	ShadowBlur          float64
+	ShadowColor         Color
+	Phases              []Phase
+	SVGAnnotate         bool
+	SVGMergeByColor     bool
+	SVGColorTolerance   int
+	SVGShapeRendering   string
+	GradientFills       bool
+	AntialiasSearch     bool
+	FixedColor          *Color
+	EdgeFeather         float64
+	FeatherSearch       bool
+	Exposure            float64
+	ReverseDraw         bool
+	ConvexPolygons      bool
+	StrokeJoin          StrokeJoin
+	CandidatesPerStep   int
+	FixedShapeSize      float64
+	MaxAspectRatio      float64
+	MinShapeFraction    float64
+	MaxShapeFraction    float64
+	MinCenterSpacing    float64
+	GridSize            int
+	ColorSampleDilation int
+	AcceptWorseProb     float64
+	AcceptWorseTemp     float64
+	AcceptWorseSteps    int
+
+	MaxCumulativeCoverage float64
+	MaxPaintsPerPixel     int
+	MutationWeights       map[ShapeType][]float64
+	Sharpen               float64
+	Wireframe             bool
+	WireframeColor        *Color
+	WireframeWidth        float64
+	SVGPrecision          int
+	DetailWeighting       bool
+	BeamWidth             int
+	EdgeGuidedInit        bool
+	Margin                float64
+	JointAlpha            bool
+}
+
+func (model *Model) settings() modelSettings {
+	return modelSettings{
+		RenderScale:         model.RenderScale,
+		BlendMode:           model.BlendMode,
+		DebugColorMode:      model.DebugColorMode,
+		OutputWidth:         model.OutputWidth,
+		OutputHeight:        model.OutputHeight,
+		QuantizeColors:      model.QuantizeColors,
+		BackgroundAlpha:     model.BackgroundAlpha,
+		ShadowOffset:        model.ShadowOffset,
+		ShadowBlur:          model.ShadowBlur,
+		ShadowColor:         model.ShadowColor,
+		Phases:              model.Phases,
+		SVGAnnotate:         model.SVGAnnotate,
+		SVGMergeByColor:     model.SVGMergeByColor,
+		SVGColorTolerance:   model.SVGColorTolerance,
+		SVGShapeRendering:   model.SVGShapeRendering,
+		GradientFills:       model.GradientFills,
+		AntialiasSearch:     model.AntialiasSearch,
+		FixedColor:          model.FixedColor,
+		EdgeFeather:         model.EdgeFeather,
+		FeatherSearch:       model.FeatherSearch,
+		Exposure:            model.Exposure,
+		ReverseDraw:         model.ReverseDraw,
+		ConvexPolygons:      model.ConvexPolygons,
+		StrokeJoin:          model.StrokeJoin,
+		CandidatesPerStep:   model.CandidatesPerStep,
+		FixedShapeSize:      model.FixedShapeSize,
+		MaxAspectRatio:      model.MaxAspectRatio,
+		MinShapeFraction:    model.MinShapeFraction,
+		MaxShapeFraction:    model.MaxShapeFraction,
+		MinCenterSpacing:    model.MinCenterSpacing,
+		GridSize:            model.GridSize,
+		ColorSampleDilation: model.ColorSampleDilation,
+		AcceptWorseProb:     model.AcceptWorseProb,
+		AcceptWorseTemp:     model.AcceptWorseTemp,
+		AcceptWorseSteps:    model.AcceptWorseSteps,
+
+		MaxCumulativeCoverage: model.MaxCumulativeCoverage,
+		MaxPaintsPerPixel:     model.MaxPaintsPerPixel,
+		MutationWeights:       model.MutationWeights,
+		Sharpen:               model.Sharpen,
+		Wireframe:             model.Wireframe,
+		WireframeColor:        model.WireframeColor,
+		WireframeWidth:        model.WireframeWidth,
+		SVGPrecision:          model.SVGPrecision,
+		DetailWeighting:       model.DetailWeighting,
+		BeamWidth:             model.BeamWidth,
+		EdgeGuidedInit:        model.EdgeGuidedInit,
+		Margin:                model.Margin,
+		JointAlpha:            model.JointAlpha,
+	}
+}
+
+func (model *Model) applySettings(s modelSettings) {
+	model.RenderScale = s.RenderScale
+	model.BlendMode = s.BlendMode
+	model.DebugColorMode = s.DebugColorMode
+	model.OutputWidth = s.OutputWidth
+	model.OutputHeight = s.OutputHeight
+	model.QuantizeColors = s.QuantizeColors
+	model.BackgroundAlpha = s.BackgroundAlpha
+	model.ShadowOffset = s.ShadowOffset
+	model.ShadowBlur = s.ShadowBlur
+	model.ShadowColor = s.ShadowColor
+	model.Phases = s.Phases
+	model.SVGAnnotate = s.SVGAnnotate
+	model.SVGMergeByColor = s.SVGMergeByColor
+	model.SVGColorTolerance = s.SVGColorTolerance
+	model.SVGShapeRendering = s.SVGShapeRendering
+	model.GradientFills = s.GradientFills
+	model.AntialiasSearch = s.AntialiasSearch
+	model.FixedColor = s.FixedColor
+	model.EdgeFeather = s.EdgeFeather
+	model.FeatherSearch = s.FeatherSearch
+	model.Exposure = s.Exposure
+	model.ReverseDraw = s.ReverseDraw
+	model.ConvexPolygons = s.ConvexPolygons
+	model.StrokeJoin = s.StrokeJoin
+	model.CandidatesPerStep = s.CandidatesPerStep
+	model.FixedShapeSize = s.FixedShapeSize
+	model.MaxAspectRatio = s.MaxAspectRatio
+	model.MinShapeFraction = s.MinShapeFraction
+	model.MaxShapeFraction = s.MaxShapeFraction
+	model.MaxCumulativeCoverage = s.MaxCumulativeCoverage
+	model.MaxPaintsPerPixel = s.MaxPaintsPerPixel
+	model.MutationWeights = s.MutationWeights
+	model.Sharpen = s.Sharpen
+	model.Wireframe = s.Wireframe
+	model.WireframeColor = s.WireframeColor
+	model.WireframeWidth = s.WireframeWidth
+	model.SVGPrecision = s.SVGPrecision
+	model.DetailWeighting = s.DetailWeighting
+	model.BeamWidth = s.BeamWidth
+	model.EdgeGuidedInit = s.EdgeGuidedInit
+	model.Margin = s.Margin
+	model.JointAlpha = s.JointAlpha
+	model.MinCenterSpacing = s.MinCenterSpacing
+	model.GridSize = s.GridSize
+	model.ColorSampleDilation = s.ColorSampleDilation
+	model.AcceptWorseProb = s.AcceptWorseProb
+	model.AcceptWorseTemp = s.AcceptWorseTemp
+	model.AcceptWorseSteps = s.AcceptWorseSteps
+}
+
+// SaveState writes everything needed to resume the model with gob: the
+// target, as the search sees it, the background, the output size, the
+// settings, the weighting, the shapes and the state of each worker's random
+// source. LoadState then continues the same trajectory that the model
+// itself would have taken, step for step. Mutation schedules, the restart
+// decay and the rejection callback are functions and are not saved, so
+// callers that set them must set them again. Worker
+// sources are only tracked from NewWorker and Seed, so a worker whose Rnd
+// was replaced directly does not resume exactly. Call it between steps.
+func (model *Model) SaveState(w io.Writer) error {
+	s := modelSnapshot{
+		Target:         model.Target,
+		Size:           maxInt(model.Sw, model.Sh),
+		Background:     model.Background,
+		Score:          model.Score,
+		Settings:       model.settings(),
+		Weights:        model.weights,
+		WeightNorm:     model.weightNorm,
+		ChannelWeights: model.channelWeights,
+		MaskCombine:    model.maskCombine,
+		MaskWeights:    model.maskWeights,
+		Alpha:          model.alpha,
+		Placement:      model.placement,
+		PreserveAlpha:  model.preserveAlpha,
+		LinearLight:    model.linearLight,
+		BorderWidth:    model.borderWidth,
+		BorderColor:    model.borderColor,
+		HalftoneCell:   model.halftoneCell,
+		HalftoneAngle:  model.halftoneAngle,
+	}
+	for _, worker := range model.Workers {
+		if worker.source == nil {
+			return fmt.Errorf("state: worker has no tracked random source")
+		}
+		s.Sources = append(s.Sources, sourceSnapshot{worker.source.seed, worker.source.draws})
+	}
+	for _, mask := range model.masks {
+		s.Masks = append(s.Masks, maskSnapshot{mask.weights, mask.weight})
+	}
+	for i, shape := range model.Shapes {
+		s.Shapes = append(s.Shapes, shapeSnapshot{
+			Type:     shapeTypeOf(shape).String(),
+			Params:   shapeParams(shape),
+			Color:    model.Colors[i],
+			Gradient: model.Gradients[i],
+		})
+	}
+	return gob.NewEncoder(w).Encode(&s)
+}
+
+// LoadState reads a model written by SaveState. The shapes are replayed
+// onto the canvas, and each worker's random source is reseeded and advanced
+// past the draws it had made, which takes a moment for long runs.
+func LoadState(r io.Reader) (*Model, error) {
+	var s modelSnapshot
+	if err := gob.NewDecoder(r).Decode(&s); err != nil {
+		return nil, fmt.Errorf("state: %v", err)
+	}
+	if s.Target == nil || len(s.Sources) == 0 {
+		return nil, fmt.Errorf("state: no target or no workers")
+	}
+	// the saved target is opaque and already converted, so it is taken as
+	// it is and the settings that would convert it are restored directly
+	model := NewModel(s.Target, s.Background, s.Size, len(s.Sources))
+	model.applySettings(s.Settings)
+	model.masks = nil
+	for _, mask := range s.Masks {
+		model.masks = append(model.masks, weightMask{mask.Weights, mask.Weight})
+	}
+	model.channelWeights = s.ChannelWeights
+	model.maskCombine = s.MaskCombine
+	model.maskWeights = s.MaskWeights
+	model.alpha = s.Alpha
+	model.placement = s.Placement
+	model.preserveAlpha = s.PreserveAlpha
+	model.linearLight = s.LinearLight
+	model.borderWidth = s.BorderWidth
+	model.borderColor = s.BorderColor
+	model.halftoneCell = s.HalftoneCell
+	model.halftoneAngle = s.HalftoneAngle
+	model.weights = s.Weights
+	model.weightNorm = s.WeightNorm
+	model.detailApplied = model.DetailWeighting
+	model.repaint()
+
+	model.initWorker(model.Workers[0])
+	for i, record := range s.Shapes {
+		shape, err := newShape(model.Workers[0], record.Type, record.Params)
+		if err != nil {
+			return nil, fmt.Errorf("state: shape %d: %v", i, err)
+		}
+		model.addLines(shape, record.Color, record.Gradient, model.shapeLines(shape))
+	}
+	if model.Score != s.Score {
+		return nil, fmt.Errorf("state: replayed score %v does not match the saved %v", model.Score, s.Score)
+	}
+
+	for i, worker := range model.Workers {
+		seedWorker(worker, s.Sources[i].Seed)
+		for n := uint64(0); n < s.Sources[i].Draws; n++ {
+			worker.source.src.Uint64()
+		}
+		worker.source.draws = s.Sources[i].Draws
+	}
+	return model, nil
+}
@@ -0,0 +1,73 @@
+package primitive
+
+import (
+	"math"
+
+	"github.com/fogleman/gg"
+)
+
+// centerGrid buckets shape centers into square cells one spacing wide, so
+// that checking a point only looks at the cells around it.
+type centerGrid struct {
+	spacing    float64
+	cols, rows int
+	cells      [][]gg.Point
+}
+
+// centerGrid returns the added shapes' centers bucketed for
+// MinCenterSpacing, or nil if it is not set.
+func (model *Model) centerGrid() *centerGrid {
+	d := model.MinCenterSpacing
+	if d <= 0 {
+		return nil
+	}
+	size := model.Target.Bounds().Size()
+	g := &centerGrid{spacing: d}
+	g.cols = int(math.Ceil(float64(size.X)/d)) + 1
+	g.rows = int(math.Ceil(float64(size.Y)/d)) + 1
+	g.cells = make([][]gg.Point, g.cols*g.rows)
+	for _, p := range model.centers {
+		i := g.cell(p)
+		g.cells[i] = append(g.cells[i], p)
+	}
+	return g
+}
+
+func (g *centerGrid) cell(p gg.Point) int {
+	x := clampInt(int(p.X/g.spacing), 0, g.cols-1)
+	y := clampInt(int(p.Y/g.spacing), 0, g.rows-1)
+	return y*g.cols + x
+}
+
+// clear reports whether no center lies within the spacing of p.
+func (g *centerGrid) clear(p gg.Point) bool {
+	cx := clampInt(int(p.X/g.spacing), 0, g.cols-1)
+	cy := clampInt(int(p.Y/g.spacing), 0, g.rows-1)
+	d2 := g.spacing * g.spacing
+	for y := maxInt(cy-1, 0); y <= minInt(cy+1, g.rows-1); y++ {
+		for x := maxInt(cx-1, 0); x <= minInt(cx+1, g.cols-1); x++ {
+			for _, q := range g.cells[y*g.cols+x] {
+				dx, dy := p.X-q.X, p.Y-q.Y
+				if dx*dx+dy*dy < d2 {
+					return false
+				}
+			}
+		}
+	}
+	return true
+}
+
+// linesCenter returns the centroid of the pixels lines cover.
+func linesCenter(lines []Scanline) gg.Point {
+	var sx, sy, n float64
+	for _, line := range lines {
+		w := float64(line.X2 - line.X1 + 1)
+		sx += w * float64(line.X1+line.X2) / 2
+		sy += w * float64(line.Y)
+		n += w
+	}
+	if n == 0 {
+		return gg.Point{}
+	}
+	return gg.Point{X: sx / n, Y: sy / n}
+}
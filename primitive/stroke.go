@@ -0,0 +1,39 @@
+package primitive
+
+import (
+	"github.com/fogleman/gg"
+	"github.com/golang/freetype/raster"
+)
+
+// StrokeJoin is how stroked shapes, which are the quadratic curves, join
+// where their flattened segments meet. Neither gg nor the rasterizer draws
+// miters, so StrokeJoinMiter renders and searches as a bevel and is only
+// exact in the SVG.
+type StrokeJoin int
+
+const (
+	StrokeJoinRound StrokeJoin = iota
+	StrokeJoinBevel
+	StrokeJoinMiter
+)
+
+// svgStrokeJoins maps stroke joins to SVG stroke-linejoin values.
+var svgStrokeJoins = map[StrokeJoin]string{
+	StrokeJoinRound: "round",
+	StrokeJoinBevel: "bevel",
+	StrokeJoinMiter: "miter",
+}
+
+func (j StrokeJoin) lineJoin() gg.LineJoin {
+	if j == StrokeJoinRound {
+		return gg.LineJoinRound
+	}
+	return gg.LineJoinBevel
+}
+
+func (j StrokeJoin) joiner() raster.Joiner {
+	if j == StrokeJoinRound {
+		return raster.RoundJoiner
+	}
+	return raster.BevelJoiner
+}
@@ -0,0 +1,38 @@
+package primitive
+
+import (
+	"image"
+	"strings"
+	"testing"
+
+	"github.com/fogleman/gg"
+)
+
+func TestStrokeJoinInSVG(t *testing.T) {
+	model := NewModel(image.NewNRGBA(image.Rect(0, 0, 32, 32)), MakeHexColor("#fff"), 64, 1)
+	model.FixedColor = &Color{0, 0, 0, 255}
+	model.Add(&Quadratic{model.Workers[0], 2, 2, 30, 4, 4, 30, 3}, 255)
+	for _, c := range []struct {
+		join StrokeJoin
+		attr string
+		gg   gg.LineJoin
+	}{
+		{StrokeJoinRound, `stroke-linejoin="round"`, gg.LineJoinRound},
+		{StrokeJoinBevel, `stroke-linejoin="bevel"`, gg.LineJoinBevel},
+		{StrokeJoinMiter, `stroke-linejoin="miter"`, gg.LineJoinBevel},
+	} {
+		model.StrokeJoin = c.join
+		svg := model.SVG()
+		if strings.Count(svg, "stroke-linejoin=") != 1 || !strings.Contains(svg, c.attr) {
+			t.Fatalf("join %d: svg does not carry %s once:\n%s", c.join, c.attr, svg)
+		}
+		if got := c.join.lineJoin(); got != c.gg {
+			t.Fatalf("join %d draws with gg join %v, want %v", c.join, got, c.gg)
+		}
+	}
+
+	// the zero value, and so the default, is round
+	if !strings.Contains(NewModel(image.NewNRGBA(image.Rect(0, 0, 8, 8)), Color{}, 8, 1).SVG(), `stroke-linejoin="round"`) {
+		t.Fatal("a new model's svg does not join round")
+	}
+}
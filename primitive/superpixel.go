@@ -0,0 +1,300 @@
+package primitive
+
+import "math"
+
+const (
+	// superpixelCompactness is SLIC's m, which trades how closely the
+	// segments follow color for how compact they stay.
+	superpixelCompactness = 10
+	superpixelIterations  = 10
+	// superpixelFloor is the weight, against 1 on the boundaries, of the
+	// pixels far from any boundary between segments.
+	superpixelFloor = 0.25
+	// superpixelContrast is the difference in CIELAB between two segments'
+	// mean colors at which the boundary between them gets its full weight.
+	// Weaker boundaries, such as those SLIC draws across flat areas, get
+	// less.
+	superpixelContrast = 20
+)
+
+// UseSuperpixels segments the target into about count superpixels with
+// SLIC, compact clusters of similar color that follow the image's regions,
+// and weights the error so that pixels on the boundaries between segments
+// of clearly different colors count four times as much as those deep
+// inside them, falling off over a quarter of a segment's width. That steers
+// the search toward shapes whose edges follow the regions. The weighting is
+// added as a weight mask, as AddWeightMask adds one with a weight of 1, so
+// SetWeightMask removes it. A count below 2 does nothing. Like
+// SetWeightMask, call it before the first Step.
+func (model *Model) UseSuperpixels(count int) {
+	w, h := model.Target.Rect.Dx(), model.Target.Rect.Dy()
+	if count < 2 || w*h < count {
+		return
+	}
+	step := math.Sqrt(float64(w*h) / float64(count))
+	lab := model.targetLab()
+	labels := superpixelLabels(lab, w, h, step)
+	falloff := math.Max(step/4, 2)
+	model.masks = append(model.masks, weightMask{boundaryWeights(lab, labels, w, h, falloff), 1})
+	model.combineMasks()
+}
+
+// targetLab returns the target's pixels in CIELAB, row by row.
+func (model *Model) targetLab() [][3]float64 {
+	im := model.Target
+	lab := make([][3]float64, im.Rect.Dx()*im.Rect.Dy())
+	for i := range lab {
+		var rgb [3]float64
+		for c := range rgb {
+			v := float64(im.Pix[i*4+c]) / 255
+			if !model.linearLight {
+				v = linearOf(v)
+			}
+			rgb[c] = v
+		}
+		lab[i] = labOf(rgb)
+	}
+	return lab
+}
+
+// labOf converts a color in linear light to CIELAB under D65.
+func labOf(rgb [3]float64) [3]float64 {
+	r, g, b := rgb[0], rgb[1], rgb[2]
+	x := (0.4124*r + 0.3576*g + 0.1805*b) / 0.95047
+	y := 0.2126*r + 0.7152*g + 0.0722*b
+	z := (0.0193*r + 0.1192*g + 0.9505*b) / 1.08883
+	f := func(t float64) float64 {
+		if t > 0.008856 {
+			return math.Cbrt(t)
+		}
+		return 7.787*t + 16.0/116
+	}
+	fx, fy, fz := f(x), f(y), f(z)
+	return [3]float64{116*fy - 16, 500 * (fx - fy), 200 * (fy - fz)}
+}
+
+// slicCenter is a segment's mean color, in CIELAB, and position.
+type slicCenter struct {
+	color [3]float64
+	x, y  float64
+}
+
+// superpixelLabels segments a w x h image, given in CIELAB, with SLIC into
+// segments about step pixels across, and returns each pixel's segment.
+// Every segment is connected: pieces cut off from their segment are merged
+// into a neighbor.
+func superpixelLabels(lab [][3]float64, w, h int, step float64) []int {
+	var centers []slicCenter
+	for y := step / 2; y < float64(h); y += step {
+		for x := step / 2; x < float64(w); x += step {
+			// start from the smoothest pixel nearby, so no center sits on
+			// an edge
+			px, py := int(x), int(y)
+			best := math.Inf(1)
+			for dy := -1; dy <= 1; dy++ {
+				for dx := -1; dx <= 1; dx++ {
+					if g := labGradient(lab, w, h, int(x)+dx, int(y)+dy); g < best {
+						best = g
+						px, py = int(x)+dx, int(y)+dy
+					}
+				}
+			}
+			centers = append(centers, slicCenter{lab[py*w+px], float64(px), float64(py)})
+		}
+	}
+
+	labels := make([]int, w*h)
+	dist := make([]float64, w*h)
+	spatial := superpixelCompactness * superpixelCompactness / (step * step)
+	window := int(math.Ceil(step))
+	for iter := 0; iter < superpixelIterations; iter++ {
+		for i := range dist {
+			dist[i] = math.Inf(1)
+		}
+		for k, c := range centers {
+			cx, cy := int(c.x), int(c.y)
+			for y := maxInt(cy-window, 0); y < minInt(cy+window+1, h); y++ {
+				for x := maxInt(cx-window, 0); x < minInt(cx+window+1, w); x++ {
+					i := y*w + x
+					p := lab[i]
+					dl, da, db := p[0]-c.color[0], p[1]-c.color[1], p[2]-c.color[2]
+					dx, dy := float64(x)-c.x, float64(y)-c.y
+					if d := dl*dl + da*da + db*db + (dx*dx+dy*dy)*spatial; d < dist[i] {
+						dist[i] = d
+						labels[i] = k
+					}
+				}
+			}
+		}
+		sums := make([]slicCenter, len(centers))
+		counts := make([]int, len(centers))
+		for i, k := range labels {
+			s := &sums[k]
+			for c := range s.color {
+				s.color[c] += lab[i][c]
+			}
+			s.x += float64(i % w)
+			s.y += float64(i / w)
+			counts[k]++
+		}
+		for k, n := range counts {
+			if n == 0 {
+				continue
+			}
+			s := sums[k]
+			f := 1 / float64(n)
+			centers[k] = slicCenter{[3]float64{s.color[0] * f, s.color[1] * f, s.color[2] * f}, s.x * f, s.y * f}
+		}
+	}
+	return connectSegments(labels, w, h, int(step*step/4))
+}
+
+// labGradient returns the squared color gradient of a CIELAB image at x, y,
+// or +Inf at the edges.
+func labGradient(lab [][3]float64, w, h, x, y int) float64 {
+	if x < 1 || y < 1 || x >= w-1 || y >= h-1 {
+		return math.Inf(1)
+	}
+	var g float64
+	for c := 0; c < 3; c++ {
+		gx := lab[y*w+x+1][c] - lab[y*w+x-1][c]
+		gy := lab[(y+1)*w+x][c] - lab[(y-1)*w+x][c]
+		g += gx*gx + gy*gy
+	}
+	return g
+}
+
+// connectSegments relabels segments so that each is one connected piece,
+// merging pieces of fewer than minSize pixels into the segment beside them.
+func connectSegments(labels []int, w, h, minSize int) []int {
+	result := make([]int, len(labels))
+	for i := range result {
+		result[i] = -1
+	}
+	offsets := [4][2]int{{-1, 0}, {1, 0}, {0, -1}, {0, 1}}
+	next := 0
+	var piece []int
+	for start := range labels {
+		if result[start] >= 0 {
+			continue
+		}
+		// a neighbor already relabeled takes in the piece if it is small
+		adjacent := -1
+		sx, sy := start%w, start/w
+		for _, o := range offsets {
+			x, y := sx+o[0], sy+o[1]
+			if x >= 0 && y >= 0 && x < w && y < h && result[y*w+x] >= 0 {
+				adjacent = result[y*w+x]
+			}
+		}
+		piece = append(piece[:0], start)
+		result[start] = next
+		for j := 0; j < len(piece); j++ {
+			px, py := piece[j]%w, piece[j]/w
+			for _, o := range offsets {
+				x, y := px+o[0], py+o[1]
+				if x < 0 || y < 0 || x >= w || y >= h {
+					continue
+				}
+				if i := y*w + x; result[i] < 0 && labels[i] == labels[start] {
+					result[i] = next
+					piece = append(piece, i)
+				}
+			}
+		}
+		if len(piece) < minSize && adjacent >= 0 {
+			for _, i := range piece {
+				result[i] = adjacent
+			}
+			continue
+		}
+		next++
+	}
+	return result
+}
+
+// boundaryWeights returns the weight of each pixel of a w x h segmentation
+// of a CIELAB image: 1 on the boundaries between segments whose mean colors
+// differ by superpixelContrast or more, and less on weaker ones, falling
+// linearly with the distance from the boundary to superpixelFloor at
+// falloff pixels.
+func boundaryWeights(lab [][3]float64, labels []int, w, h int, falloff float64) []float64 {
+	n := 0
+	for _, k := range labels {
+		n = maxInt(n, k+1)
+	}
+	means := make([][3]float64, n)
+	counts := make([]float64, n)
+	for i, k := range labels {
+		for c := range means[k] {
+			means[k][c] += lab[i][c]
+		}
+		counts[k]++
+	}
+	for k := range means {
+		for c := range means[k] {
+			means[k][c] /= counts[k]
+		}
+	}
+	strength := func(a, b int) float64 {
+		if a == b {
+			return 0
+		}
+		var d float64
+		for c := range means[a] {
+			d += (means[a][c] - means[b][c]) * (means[a][c] - means[b][c])
+		}
+		return math.Min(math.Sqrt(d)/superpixelContrast, 1)
+	}
+
+	// each pixel takes the strongest of the boundaries near it, less its
+	// distance from each, spread by a chamfer transform in two passes
+	near := make([]float64, len(labels))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			i := y*w + x
+			var s float64
+			if x+1 < w {
+				s = math.Max(s, strength(labels[i], labels[i+1]))
+			}
+			if y+1 < h {
+				s = math.Max(s, strength(labels[i], labels[i+w]))
+			}
+			if x > 0 {
+				s = math.Max(s, strength(labels[i], labels[i-1]))
+			}
+			if y > 0 {
+				s = math.Max(s, strength(labels[i], labels[i-w]))
+			}
+			near[i] = s
+		}
+	}
+	relax := func(i, x, y int, d float64) {
+		if x >= 0 && y >= 0 && x < w && y < h {
+			near[i] = math.Max(near[i], near[y*w+x]-d/falloff)
+		}
+	}
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			i := y*w + x
+			relax(i, x-1, y, 1)
+			relax(i, x, y-1, 1)
+			relax(i, x-1, y-1, math.Sqrt2)
+			relax(i, x+1, y-1, math.Sqrt2)
+		}
+	}
+	for y := h - 1; y >= 0; y-- {
+		for x := w - 1; x >= 0; x-- {
+			i := y*w + x
+			relax(i, x+1, y, 1)
+			relax(i, x, y+1, 1)
+			relax(i, x+1, y+1, math.Sqrt2)
+			relax(i, x-1, y+1, math.Sqrt2)
+		}
+	}
+	weights := make([]float64, len(near))
+	for i, t := range near {
+		weights[i] = superpixelFloor + (1-superpixelFloor)*math.Max(t, 0)
+	}
+	return weights
+}
@@ -0,0 +1,132 @@
+package primitive
+
+import (
+	"fmt"
+	"image"
+	"strings"
+)
+
+// svgGroup is one element of a merged SVG: a run of shapes, by index, that
+// share a fill, or a single shape written as usual.
+type svgGroup struct {
+	first  int // position in draw order of the first shape
+	color  Color
+	shapes []int
+	merged bool
+}
+
+// svgMergedShapes returns the SVG lines for the shapes with those of the
+// same color merged into compound paths, as SVGMergeByColor describes.
+func (model *Model) svgMergedShapes() []string {
+	order := model.drawOrder()
+	boxes := make([]image.Rectangle, len(order))
+	var groups []*svgGroup
+	for p, i := range order {
+		boxes[p] = svgBounds(model.Shapes[i])
+		c, gradient := model.shapeFill(i)
+		if gradient != nil || svgPathData(model.Shapes[i]) == "" {
+			groups = append(groups, &svgGroup{first: p, shapes: []int{i}})
+			continue
+		}
+		var group *svgGroup
+		for k := len(groups) - 1; k >= 0; k-- {
+			if g := groups[k]; g.merged && colorsNear(g.color, c, model.SVGColorTolerance) {
+				group = g
+				break
+			}
+		}
+		// a later group with this color would move the shape even less, so
+		// only the latest one can take it
+		if group != nil {
+			for q := group.first; q < p; q++ {
+				if boxes[q].Overlaps(boxes[p]) {
+					group = nil
+					break
+				}
+			}
+		}
+		if group == nil {
+			group = &svgGroup{first: p, color: c, merged: true}
+			groups = append(groups, group)
+		}
+		group.shapes = append(group.shapes, i)
+	}
+
+	var lines []string
+	for _, g := range groups {
+		if !g.merged {
+			lines = append(lines, model.svgShape(g.shapes[0])...)
+			continue
+		}
+		paths := make([]string, len(g.shapes))
+		for k, i := range g.shapes {
+			if model.SVGAnnotate {
+				lines = append(lines, model.svgAnnotation(i))
+			}
+			paths[k] = svgPathData(model.Shapes[i])
+		}
+		lines = append(lines, fmt.Sprintf("<path %s d=\"%s\" />", model.svgFill(g.color), strings.Join(paths, " ")))
+	}
+	return lines
+}
+
+// svgBounds returns the pixels a shape may touch, with a pixel to spare for
+// antialiasing.
+func svgBounds(shape Shape) image.Rectangle {
+	var r image.Rectangle
+	for k, line := range shape.Rasterize() {
+		b := image.Rect(line.X1, line.Y, line.X2+1, line.Y+1)
+		if k == 0 {
+			r = b
+		} else {
+			r = r.Union(b)
+		}
+	}
+	return r.Inset(-1)
+}
+
+func colorsNear(a, b Color, tolerance int) bool {
+	d := maxInt(maxInt(absInt(a.R-b.R), absInt(a.G-b.G)), maxInt(absInt(a.B-b.B), absInt(a.A-b.A)))
+	return d <= tolerance
+}
+
+// svgPathData returns a closed subpath drawing the same outline as the
+// shape's own SVG element, or "" for shapes that cannot share a fill.
+func svgPathData(shape Shape) string {
+	switch s := shape.(type) {
+	case *Triangle:
+		return fmt.Sprintf("M%d %d L%d %d L%d %d Z", s.X1, s.Y1, s.X2, s.Y2, s.X3, s.Y3)
+	case *Rectangle:
+		x1, y1, x2, y2 := s.bounds()
+		return fmt.Sprintf("M%d %d h%d v%d h%d Z", x1, y1, x2-x1+1, y2-y1+1, -(x2 - x1 + 1))
+	case *Ellipse:
+		return svgEllipsePath(float64(s.X), float64(s.Y), float64(s.Rx), float64(s.Ry), 0)
+	case *RotatedEllipse:
+		return svgEllipsePath(s.X, s.Y, s.Rx, s.Ry, s.Angle)
+	case *RotatedRectangle:
+		sx, sy := float64(s.Sx)/2, float64(s.Sy)/2
+		a := radians(float64(s.Angle))
+		var points []string
+		for _, p := range [][2]float64{{-sx, -sy}, {sx, -sy}, {sx, sy}, {-sx, sy}} {
+			x, y := rotate(p[0], p[1], a)
+			points = append(points, fmt.Sprintf("%f %f", float64(s.X)+x, float64(s.Y)+y))
+		}
+		return "M" + strings.Join(points, " L") + " Z"
+	case *Polygon:
+		x, y := s.outline()
+		points := make([]string, len(x))
+		for i := range x {
+			points[i] = fmt.Sprintf("%f %f", x[i], y[i])
+		}
+		return "M" + strings.Join(points, " L") + " Z"
+	}
+	return ""
+}
+
+// svgEllipsePath draws an ellipse centered at x, y rotated by angle degrees
+// as two half arcs.
+func svgEllipsePath(x, y, rx, ry, angle float64) string {
+	dx, dy := rotate(rx, 0, radians(angle))
+	return fmt.Sprintf("M%f %f A%f %f %f 1 0 %f %f A%f %f %f 1 0 %f %f Z",
+		x-dx, y-dy, rx, ry, angle, x+dx, y+dy, rx, ry, angle, x-dx, y-dy)
+}
@@ -0,0 +1,45 @@
+package primitive
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// svgGeometry matches the attributes that hold a shape's coordinates.
+var svgGeometry = regexp.MustCompile(`\s(points|d|transform|cx|cy|rx|ry|x|y|x1|y1|x2|y2|width|height)="([^"]*)"`)
+
+// svgNumber matches a number with a fraction.
+var svgNumber = regexp.MustCompile(`-?[0-9]*\.[0-9]+`)
+
+// svgRound returns an SVG line with the numbers in its coordinates rounded
+// to SVGPrecision decimal places, without trailing zeros. Other attributes,
+// such as opacities and stroke widths, are left as they are, and so is the
+// line when SVGPrecision is not positive.
+func (model *Model) svgRound(line string) string {
+	if model.SVGPrecision <= 0 {
+		return line
+	}
+	return svgGeometry.ReplaceAllStringFunc(line, func(attr string) string {
+		return svgNumber.ReplaceAllStringFunc(attr, func(s string) string {
+			v, err := strconv.ParseFloat(s, 64)
+			if err != nil {
+				return s
+			}
+			return formatFixed(v, model.SVGPrecision)
+		})
+	})
+}
+
+// formatFixed formats v with at most prec decimal places, dropping trailing
+// zeros, and the point if nothing follows it.
+func formatFixed(v float64, prec int) string {
+	s := strconv.FormatFloat(v, 'f', prec, 64)
+	if strings.Contains(s, ".") {
+		s = strings.TrimRight(strings.TrimRight(s, "0"), ".")
+	}
+	if s == "-0" {
+		return "0"
+	}
+	return s
+}
@@ -0,0 +1,60 @@
+package primitive
+
+import (
+	"image"
+	"testing"
+)
+
+func TestFormatFixed(t *testing.T) {
+	for _, c := range []struct {
+		v    float64
+		prec int
+		want string
+	}{
+		{1.4, 0, "1"},
+		{120, 0, "120"},
+		{-0.4, 0, "0"},
+		{1.5, 1, "1.5"},
+		{2.04, 1, "2"},
+		{100, 1, "100"},
+		{-0.04, 1, "0"},
+		{3.1, 2, "3.1"},
+		{12.345678, 2, "12.35"},
+		{-0.001, 2, "0"},
+		{-0.04, 2, "-0.04"},
+	} {
+		if got := formatFixed(c.v, c.prec); got != c.want {
+			t.Fatalf("formatFixed(%v, %d) = %q, want %q", c.v, c.prec, got, c.want)
+		}
+	}
+}
+
+func TestSVGRoundOnlyTouchesGeometry(t *testing.T) {
+	ellipse := `<g transform="translate(12.345678 -0.004000) rotate(90.000000) scale(3.100000 2.000000)">` +
+		`<ellipse fill="#0a1b2c" fill-opacity="0.501961" stroke-width="1.500000" cx="0" cy="0" rx="1" ry="1" /></g>`
+	polygon := `<polygon fill="#102030" fill-opacity="0.250000" points="1.260000,-0.040000 10.000000,2.556000 3.000000,4.949000" />`
+	model := NewModel(image.NewNRGBA(image.Rect(0, 0, 8, 8)), MakeHexColor("#fff"), 16, 1)
+	for _, c := range []struct {
+		prec          int
+		ellipse, poly string
+	}{
+		// 0 leaves the lines as they are
+		{0, ellipse, polygon},
+		{1,
+			`<g transform="translate(12.3 0) rotate(90) scale(3.1 2)">` +
+				`<ellipse fill="#0a1b2c" fill-opacity="0.501961" stroke-width="1.500000" cx="0" cy="0" rx="1" ry="1" /></g>`,
+			`<polygon fill="#102030" fill-opacity="0.250000" points="1.3,0 10,2.6 3,4.9" />`},
+		{2,
+			`<g transform="translate(12.35 0) rotate(90) scale(3.1 2)">` +
+				`<ellipse fill="#0a1b2c" fill-opacity="0.501961" stroke-width="1.500000" cx="0" cy="0" rx="1" ry="1" /></g>`,
+			`<polygon fill="#102030" fill-opacity="0.250000" points="1.26,-0.04 10,2.56 3,4.95" />`},
+	} {
+		model.SVGPrecision = c.prec
+		if got := model.svgRound(ellipse); got != c.ellipse {
+			t.Fatalf("precision %d:\n got %s\nwant %s", c.prec, got, c.ellipse)
+		}
+		if got := model.svgRound(polygon); got != c.poly {
+			t.Fatalf("precision %d:\n got %s\nwant %s", c.prec, got, c.poly)
+		}
+	}
+}
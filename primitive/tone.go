@@ -0,0 +1,45 @@
+package primitive
+
+import "math"
+
+// GammaToneCurve returns a TargetToneCurve that raises each color channel,
+// as a fraction of full, to the power 1/gamma, so a gamma above 1 brightens
+// the shadows most and leaves black and white as they are, and one below 1
+// darkens them. A gamma of zero or less is taken as 1.
+func GammaToneCurve(gamma float64) func(Color) Color {
+	if gamma <= 0 {
+		gamma = 1
+	}
+	var t [256]uint8
+	for i := range t {
+		t[i] = uint8(math.Round(math.Pow(float64(i)/255, 1/gamma) * 255))
+	}
+	return func(c Color) Color {
+		return Color{int(t[clampInt(c.R, 0, 255)]), int(t[clampInt(c.G, 0, 255)]), int(t[clampInt(c.B, 0, 255)]), c.A}
+	}
+}
+
+// applyTargetToneCurve maps the target and the background through
+// TargetToneCurve and repaints the bare canvas, so the score is of the
+// adjusted image. Weights drawn from the target are recomputed.
+func (model *Model) applyTargetToneCurve() {
+	curve := model.TargetToneCurve
+	im := model.Target
+	for i := 0; i < len(im.Pix); i += 4 {
+		c := model.outputColor(Color{int(im.Pix[i]), int(im.Pix[i+1]), int(im.Pix[i+2]), 255})
+		c = model.canvasColor(toneColor(curve, c))
+		im.Pix[i], im.Pix[i+1], im.Pix[i+2] = uint8(c.R), uint8(c.G), uint8(c.B)
+	}
+	model.Background = toneColor(curve, model.Background)
+	model.toneApplied = true
+	model.detailWeights = nil
+	model.updateWeights()
+	model.repaint()
+}
+
+// toneColor maps c through curve, keeping its alpha and clamping the
+// channels the curve returns.
+func toneColor(curve func(Color) Color, c Color) Color {
+	t := curve(c)
+	return Color{clampInt(t.R, 0, 255), clampInt(t.G, 0, 255), clampInt(t.B, 0, 255), c.A}
+}
@@ -16,8 +16,7 @@ type Triangle struct {
 
 func NewRandomTriangle(worker *Worker) *Triangle {
 	rnd := worker.Rnd
-	x1 := rnd.Intn(worker.W)
-	y1 := rnd.Intn(worker.H)
+	x1, y1 := worker.randomPoint()
 	x2 := x1 + rnd.Intn(31) - 15
 	y2 := y1 + rnd.Intn(31) - 15
 	x3 := x1 + rnd.Intn(31) - 15
@@ -51,17 +50,18 @@ func (t *Triangle) Mutate() {
 	h := t.Worker.H
 	rnd := t.Worker.Rnd
 	const m = 16
-	for {
-		switch rnd.Intn(3) {
+	d := 16 * t.Worker.mutationScale(ShapeTypeTriangle)
+	for tries := 0; ; tries++ {
+		switch t.Worker.mutationChoice(ShapeTypeTriangle, 3, tries) {
 		case 0:
-			t.X1 = clampInt(t.X1+int(rnd.NormFloat64()*16), -m, w-1+m)
-			t.Y1 = clampInt(t.Y1+int(rnd.NormFloat64()*16), -m, h-1+m)
+			t.X1 = clampInt(t.X1+int(rnd.NormFloat64()*d), -m, w-1+m)
+			t.Y1 = clampInt(t.Y1+int(rnd.NormFloat64()*d), -m, h-1+m)
 		case 1:
-			t.X2 = clampInt(t.X2+int(rnd.NormFloat64()*16), -m, w-1+m)
-			t.Y2 = clampInt(t.Y2+int(rnd.NormFloat64()*16), -m, h-1+m)
+			t.X2 = clampInt(t.X2+int(rnd.NormFloat64()*d), -m, w-1+m)
+			t.Y2 = clampInt(t.Y2+int(rnd.NormFloat64()*d), -m, h-1+m)
 		case 2:
-			t.X3 = clampInt(t.X3+int(rnd.NormFloat64()*16), -m, w-1+m)
-			t.Y3 = clampInt(t.Y3+int(rnd.NormFloat64()*16), -m, h-1+m)
+			t.X3 = clampInt(t.X3+int(rnd.NormFloat64()*d), -m, w-1+m)
+			t.Y3 = clampInt(t.Y3+int(rnd.NormFloat64()*d), -m, h-1+m)
 		}
 		if t.Valid() {
 			break
@@ -71,6 +71,9 @@ func (t *Triangle) Mutate() {
 
 func (t *Triangle) Valid() bool {
 	const minDegrees = 15
+	if t.degenerate() {
+		return false
+	}
 	var a1, a2, a3 float64
 	{
 		x1 := float64(t.X2 - t.X1)
@@ -102,8 +105,23 @@ func (t *Triangle) Valid() bool {
 	return a1 > minDegrees && a2 > minDegrees && a3 > minDegrees
 }
 
+func (t *Triangle) degenerate() bool {
+	a := (t.X2-t.X1)*(t.Y3-t.Y1) - (t.Y2-t.Y1)*(t.X3-t.X1)
+	return a == 0
+}
+
 func (t *Triangle) Rasterize() []Scanline {
 	buf := t.Worker.Lines[:0]
+	if t.degenerate() {
+		return buf
+	}
+	if t.Worker.AntialiasSearch {
+		// pixel centers, as the scanlines below cover them
+		return fillPath(t.Worker, polygonPath(
+			float64(t.X1)+0.5, float64(t.Y1)+0.5,
+			float64(t.X2)+0.5, float64(t.Y2)+0.5,
+			float64(t.X3)+0.5, float64(t.Y3)+0.5))
+	}
 	lines := rasterizeTriangle(t.X1, t.Y1, t.X2, t.Y2, t.X3, t.Y3, buf)
 	return cropScanlines(lines, t.Worker.W, t.Worker.H)
 }
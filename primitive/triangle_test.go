@@ -0,0 +1,42 @@
+package primitive
+
+import (
+	"image"
+	"math"
+	"testing"
+)
+
+func TestCollapsedTriangle(t *testing.T) {
+	target := image.NewNRGBA(image.Rect(0, 0, 32, 32))
+	for i := range target.Pix {
+		target.Pix[i] = uint8(i)
+	}
+	model := NewModel(target, MakeHexColor("#808080"), 64, 1)
+	worker := model.Workers[0]
+	model.initWorker(worker)
+
+	for _, tri := range []*Triangle{
+		{worker, 5, 5, 5, 5, 5, 5},     // one point
+		{worker, 2, 2, 10, 10, 20, 20}, // on a line
+		{worker, 3, 7, 30, 7, 12, 7},   // on a row
+	} {
+		if lines := tri.Rasterize(); len(lines) != 0 {
+			t.Fatalf("%v: %d scanlines, want none", *tri, len(lines))
+		}
+		energy := worker.Energy(tri, 128)
+		if math.IsNaN(energy) || energy != worker.Score {
+			t.Fatalf("%v: energy %v, want the unchanged score %v", *tri, energy, worker.Score)
+		}
+	}
+}
+
+func TestRandomStateCoversPixels(t *testing.T) {
+	model := NewModel(image.NewNRGBA(image.Rect(0, 0, 4, 4)), MakeHexColor("#fff"), 16, 1)
+	worker := model.Workers[0]
+	model.initWorker(worker)
+	for i := 0; i < 100; i++ {
+		if lines := worker.RandomState(ShapeTypeTriangle, 128).Shape.Rasterize(); len(lines) == 0 {
+			t.Fatalf("random state %d covers nothing", i)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package primitive
+
+import (
+	"image"
+	"runtime"
+	"time"
+
+	xdraw "golang.org/x/image/draw"
+)
+
+const (
+	autoWorkerSampleSize = 64
+	autoWorkerBudget     = 60 * time.Millisecond
+)
+
+// AutoWorkerCount benchmarks a few worker counts on a downscaled copy of
+// sample and returns the one with the best throughput, measured in energy
+// evaluations per second. It tries powers of two up to runtime.NumCPU and
+// takes well under a second. It shares no state between calls, so it is safe
+// to call from several goroutines, although concurrent calls will skew each
+// other's timings.
+func AutoWorkerCount(sample image.Image) int {
+	cpus := runtime.NumCPU()
+	if sample == nil || sample.Bounds().Empty() || cpus == 1 {
+		return cpus
+	}
+	target := downscaleSample(sample, autoWorkerSampleSize)
+	bg := MakeColor(AverageImageColor(target))
+
+	var counts []int
+	for n := 1; n < cpus; n *= 2 {
+		counts = append(counts, n)
+	}
+	counts = append(counts, cpus)
+
+	best, bestRate := 1, 0.0
+	for _, n := range counts {
+		rate := benchmarkWorkers(target, bg, n)
+		// require a clear win before paying for more goroutines and buffers
+		if rate > bestRate*1.05 {
+			best, bestRate = n, rate
+		}
+	}
+	return best
+}
+
+func benchmarkWorkers(target image.Image, bg Color, n int) float64 {
+	model := NewModel(target, bg, autoWorkerSampleSize, n)
+	model.Seed(0)
+	evaluations := 0
+	start := time.Now()
+	for time.Since(start) < autoWorkerBudget {
+		evaluations += model.Step(ShapeTypeTriangle, 128, 0)
+	}
+	return float64(evaluations) / time.Since(start).Seconds()
+}
+
+func downscaleSample(im image.Image, size int) *image.RGBA {
+	w := im.Bounds().Dx()
+	h := im.Bounds().Dy()
+	if w <= size && h <= size {
+		return targetToRGBA(im)
+	}
+	if w >= h {
+		w, h = size, maxInt(h*size/w, 1)
+	} else {
+		w, h = maxInt(w*size/h, 1), size
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	xdraw.BiLinear.Scale(dst, dst.Rect, im, im.Bounds(), xdraw.Src, nil)
+	return dst
+}
@@ -61,7 +61,7 @@ func SaveJPG(path string, im image.Image, quality int) error {
 		return err
 	}
 	defer file.Close()
-	return jpeg.Encode(file, im, &jpeg.Options{quality})
+	return jpeg.Encode(file, im, &jpeg.Options{Quality: quality})
 }
 
 func SaveGIF(path string, frames []image.Image, delay, lastDelay int) error {
@@ -161,6 +161,13 @@ func maxInt(a, b int) int {
 	return b
 }
 
+func absInt(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
 func rotate(x, y, theta float64) (rx, ry float64) {
 	rx = x*math.Cos(theta) - y*math.Sin(theta)
 	ry = x*math.Sin(theta) + y*math.Cos(theta)
@@ -169,37 +176,175 @@ func rotate(x, y, theta float64) (rx, ry float64) {
 
 func imageToRGBA(src image.Image) *image.RGBA {
 	dst := image.NewRGBA(src.Bounds())
-	draw.Draw(dst, dst.Rect, src, image.ZP, draw.Src)
+	draw.Draw(dst, dst.Rect, src, src.Bounds().Min, draw.Src)
 	return dst
 }
 
+// targetToRGBA converts an input image into a target buffer. Shapes are
+// matched against an opaque canvas, so the target holds each pixel's
+// straight, un-premultiplied color with full alpha; a translucent edge pixel
+// keeps its true color rather than being darkened by its coverage.
+func targetToRGBA(src image.Image) *image.RGBA {
+	dst := image.NewRGBA(src.Bounds())
+	drawTarget(dst, src)
+	return dst
+}
+
+// drawTarget fills dst, which must have the same bounds as src, as
+// targetToRGBA does.
+func drawTarget(dst *image.RGBA, src image.Image) {
+	nrgba, ok := src.(*image.NRGBA)
+	if !ok || nrgba.Rect != dst.Rect {
+		nrgba = image.NewNRGBA(dst.Rect)
+		draw.Draw(nrgba, nrgba.Rect, src, src.Bounds().Min, draw.Src)
+	}
+	w := dst.Rect.Dx() * 4
+	for y := dst.Rect.Min.Y; y < dst.Rect.Max.Y; y++ {
+		d := dst.Pix[dst.PixOffset(dst.Rect.Min.X, y):][:w]
+		copy(d, nrgba.Pix[nrgba.PixOffset(dst.Rect.Min.X, y):][:w])
+		for i := 3; i < w; i += 4 {
+			d[i] = 255
+		}
+	}
+}
+
 func copyRGBA(src *image.RGBA) *image.RGBA {
 	dst := image.NewRGBA(src.Bounds())
 	copy(dst.Pix, src.Pix)
 	return dst
 }
 
+func downsampleRGBA(src *image.RGBA, factor int) *image.RGBA {
+	size := src.Bounds().Size()
+	w, h := size.X/factor, size.Y/factor
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	n := uint32(factor * factor)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var r, g, b, a uint32
+			for sy := y * factor; sy < (y+1)*factor; sy++ {
+				i := src.PixOffset(x*factor, sy)
+				for sx := 0; sx < factor; sx++ {
+					r += uint32(src.Pix[i+0])
+					g += uint32(src.Pix[i+1])
+					b += uint32(src.Pix[i+2])
+					a += uint32(src.Pix[i+3])
+					i += 4
+				}
+			}
+			j := dst.PixOffset(x, y)
+			dst.Pix[j+0] = uint8(r / n)
+			dst.Pix[j+1] = uint8(g / n)
+			dst.Pix[j+2] = uint8(b / n)
+			dst.Pix[j+3] = uint8(a / n)
+		}
+	}
+	return dst
+}
+
 func uniformRGBA(r image.Rectangle, c color.Color) *image.RGBA {
 	im := image.NewRGBA(r)
 	draw.Draw(im, im.Bounds(), &image.Uniform{c}, image.ZP, draw.Src)
 	return im
 }
 
+// AverageImageColor returns the mean color of im, weighting each pixel by its
+// alpha so that transparent pixels do not pull the average toward black.
 func AverageImageColor(im image.Image) color.NRGBA {
+	var sums colorSums
+	sums.add(im)
+	return sums.mean()
+}
+
+// CornerBackgroundColor returns the mean color of the four sampleSize x
+// sampleSize squares in the corners of im. For a subject on a plain backdrop
+// this is closer to the backdrop than the mean of the whole image. The
+// squares are clipped to the image, so they overlap on small images.
+func CornerBackgroundColor(im image.Image, sampleSize int) color.Color {
+	b := im.Bounds()
+	n := maxInt(sampleSize, 1)
+	corners := []image.Rectangle{
+		image.Rect(b.Min.X, b.Min.Y, b.Min.X+n, b.Min.Y+n),
+		image.Rect(b.Max.X-n, b.Min.Y, b.Max.X, b.Min.Y+n),
+		image.Rect(b.Min.X, b.Max.Y-n, b.Min.X+n, b.Max.Y),
+		image.Rect(b.Max.X-n, b.Max.Y-n, b.Max.X, b.Max.Y),
+	}
+	var sums colorSums
+	for _, r := range corners {
+		sums.add(subImage(im, r.Intersect(b)))
+	}
+	return sums.mean()
+}
+
+// colorSums accumulates premultiplied color for alpha weighted means.
+type colorSums struct {
+	r, g, b, a int
+}
+
+func (s *colorSums) add(im image.Image) {
 	rgba := imageToRGBA(im)
 	size := rgba.Bounds().Size()
-	w, h := size.X, size.Y
-	var r, g, b int
-	for y := 0; y < h; y++ {
-		for x := 0; x < w; x++ {
-			c := rgba.RGBAAt(x, y)
-			r += int(c.R)
-			g += int(c.G)
-			b += int(c.B)
+	for y := 0; y < size.Y; y++ {
+		i := rgba.PixOffset(rgba.Rect.Min.X, rgba.Rect.Min.Y+y)
+		for x := 0; x < size.X; x++ {
+			s.r += int(rgba.Pix[i])
+			s.g += int(rgba.Pix[i+1])
+			s.b += int(rgba.Pix[i+2])
+			s.a += int(rgba.Pix[i+3])
+			i += 4
+		}
+	}
+}
+
+func (s *colorSums) mean() color.NRGBA {
+	if s.a == 0 {
+		return color.NRGBA{0, 0, 0, 255}
+	}
+	// the pixels are premultiplied, so dividing by the total alpha gives the
+	// straight mean color
+	return color.NRGBA{uint8(s.r * 255 / s.a), uint8(s.g * 255 / s.a), uint8(s.b * 255 / s.a), 255}
+}
+
+// subImage returns the part of im within r, copying only if im has no
+// SubImage method.
+func subImage(im image.Image, r image.Rectangle) image.Image {
+	if s, ok := im.(interface {
+		SubImage(image.Rectangle) image.Image
+	}); ok {
+		return s.SubImage(r)
+	}
+	dst := image.NewRGBA(r)
+	draw.Draw(dst, r, im, r.Min, draw.Src)
+	return dst
+}
+
+// MedianImageColor returns the per-channel median color of im, which unlike
+// the mean is not pulled toward a small very bright or very dark area. Each
+// pixel counts in proportion to its alpha.
+func MedianImageColor(im image.Image) color.Color {
+	nrgba := image.NewNRGBA(im.Bounds())
+	draw.Draw(nrgba, nrgba.Rect, im, im.Bounds().Min, draw.Src)
+	var hist [3][256]int
+	total := 0
+	for i := 0; i < len(nrgba.Pix); i += 4 {
+		a := int(nrgba.Pix[i+3])
+		hist[0][nrgba.Pix[i]] += a
+		hist[1][nrgba.Pix[i+1]] += a
+		hist[2][nrgba.Pix[i+2]] += a
+		total += a
+	}
+	var c [3]uint8
+	if total > 0 {
+		for j := range hist {
+			sum := 0
+			for v, n := range hist[j] {
+				sum += n
+				if sum*2 >= total {
+					c[j] = uint8(v)
+					break
+				}
+			}
 		}
 	}
-	r /= w * h
-	g /= w * h
-	b /= w * h
-	return color.NRGBA{uint8(r), uint8(g), uint8(b), 255}
+	return color.NRGBA{c[0], c[1], c[2], 255}
 }
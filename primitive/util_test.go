@@ -0,0 +1,78 @@
+package primitive
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+// premultipliedSprite returns a 12 x 12 red square on transparency in
+// premultiplied RGBA, with a one pixel edge at half coverage, as a decoder
+// hands over an antialiased sprite.
+func premultipliedSprite() *image.RGBA {
+	im := image.NewRGBA(image.Rect(0, 0, 12, 12))
+	for y := 2; y < 10; y++ {
+		for x := 2; x < 10; x++ {
+			c := color.RGBA{255, 0, 0, 255}
+			if x == 2 || y == 2 || x == 9 || y == 9 {
+				c = color.RGBA{128, 0, 0, 128}
+			}
+			im.SetRGBA(x, y, c)
+		}
+	}
+	return im
+}
+
+func TestTargetUnpremultipliesSprite(t *testing.T) {
+	target := targetToRGBA(premultipliedSprite())
+	for _, p := range []image.Point{{5, 5}, {2, 5}, {9, 9}} {
+		if got := target.RGBAAt(p.X, p.Y); got != (color.RGBA{255, 0, 0, 255}) {
+			t.Fatalf("target at %v = %v, want opaque red", p, got)
+		}
+	}
+	if got := AverageImageColor(premultipliedSprite()); got != (color.NRGBA{255, 0, 0, 255}) {
+		t.Fatalf("average color = %v, want red", got)
+	}
+}
+
+func TestMedianIgnoresBrightSky(t *testing.T) {
+	// a dark green landscape under a white sky a third of its height
+	im := image.NewNRGBA(image.Rect(0, 0, 30, 30))
+	for y := 0; y < 30; y++ {
+		for x := 0; x < 30; x++ {
+			c := color.NRGBA{40, 90, 30, 255}
+			if y < 10 {
+				c = color.NRGBA{255, 255, 255, 255}
+			}
+			im.SetNRGBA(x, y, c)
+		}
+	}
+	median := MedianImageColor(im).(color.NRGBA)
+	if median != (color.NRGBA{40, 90, 30, 255}) {
+		t.Fatalf("median = %v, want the landscape's green", median)
+	}
+	mean := AverageImageColor(im)
+	if mean.R < 100 || mean.B < 100 {
+		t.Fatalf("mean = %v, want it pulled toward the sky", mean)
+	}
+}
+
+func TestCornerBackgroundOfCenteredSubject(t *testing.T) {
+	// a large dark subject centered on a light gray backdrop
+	im := image.NewNRGBA(image.Rect(0, 0, 40, 40))
+	for y := 0; y < 40; y++ {
+		for x := 0; x < 40; x++ {
+			c := color.NRGBA{220, 220, 220, 255}
+			if x >= 6 && x < 34 && y >= 6 && y < 34 {
+				c = color.NRGBA{30, 40, 50, 255}
+			}
+			im.SetNRGBA(x, y, c)
+		}
+	}
+	if got := CornerBackgroundColor(im, 4); got != (color.NRGBA{220, 220, 220, 255}) {
+		t.Fatalf("corner color = %v, want the backdrop", got)
+	}
+	if mean := AverageImageColor(im); mean.R > 150 {
+		t.Fatalf("mean = %v, want it pulled toward the subject", mean)
+	}
+}
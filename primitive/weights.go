@@ -0,0 +1,263 @@
+package primitive
+
+import (
+	"image"
+	"math"
+
+	xdraw "golang.org/x/image/draw"
+)
+
+// MaskCombine is how AddWeightMask combines several masks.
+type MaskCombine int
+
+const (
+	// MaskMultiply multiplies the masks, each raised to the power of its
+	// weight, so a pixel counts only where every mask is bright.
+	MaskMultiply MaskCombine = iota
+	// MaskSum takes the mean of the masks weighted by their weights, so a
+	// pixel counts where any mask is bright.
+	MaskSum
+)
+
+type weightMask struct {
+	weights []float64
+	weight  float64
+}
+
+// SetWeightMask makes the error of each pixel count in proportion to the
+// mask's luminance there, so bright areas of the mask are reproduced more
+// faithfully than dark ones. The mask is scaled to the target's size. It
+// replaces any masks added before; a nil or all-black mask removes the
+// weighting. Call it before the first Step, since it changes how the score
+// is measured.
+func (model *Model) SetWeightMask(mask image.Image) {
+	model.masks = nil
+	if mask != nil {
+		model.AddWeightMask(mask, 1)
+		return
+	}
+	model.combineMasks()
+}
+
+// AddWeightMask adds a mask to those set by SetWeightMask and earlier calls.
+// The masks are combined as SetMaskCombine chooses, by default multiplied.
+// A weight of 0 or less leaves the mask out.
+func (model *Model) AddWeightMask(mask image.Image, weight float64) {
+	model.masks = append(model.masks, weightMask{weightsFromMask(mask, model.Target.Bounds()), weight})
+	model.combineMasks()
+}
+
+// SetMaskCombine sets how the masks added by AddWeightMask are combined.
+func (model *Model) SetMaskCombine(mode MaskCombine) {
+	model.maskCombine = mode
+	model.combineMasks()
+}
+
+// combineMasks builds maskWeights from the masks and updates the weights.
+func (model *Model) combineMasks() {
+	model.maskWeights = nil
+	var masks []weightMask
+	var total float64
+	for _, m := range model.masks {
+		if m.weight > 0 {
+			masks = append(masks, m)
+			total += m.weight
+		}
+	}
+	if len(masks) > 0 {
+		weights := make([]float64, len(masks[0].weights))
+		for i := range weights {
+			if model.maskCombine == MaskSum {
+				var sum float64
+				for _, m := range masks {
+					sum += m.weight * m.weights[i]
+				}
+				weights[i] = sum / total
+			} else {
+				w := 1.0
+				for _, m := range masks {
+					w *= math.Pow(m.weights[i], m.weight)
+				}
+				weights[i] = w
+			}
+		}
+		model.maskWeights = weights
+	}
+	model.updateWeights()
+}
+
+// SetPreserveAlpha keeps the input's transparency. Fully transparent target
+// pixels are left out of the score, so shapes are not drawn toward empty
+// space, and Render's output takes its alpha from the input. It has no
+// effect on opaque inputs. Like SetWeightMask, call it before the first
+// Step.
+func (model *Model) SetPreserveAlpha(on bool) {
+	model.preserveAlpha = on
+	model.updateWeights()
+}
+
+// SetChannelWeights scales the squared error of the red, green and blue
+// channels in the score by r, g and b, so that reconstruction favors the
+// channels that matter for the image, such as red and green for skin
+// tones. Alpha keeps a weight of one, and negative weights count as zero.
+// The default, 1, 1, 1, scores exactly as before. Like SetWeightMask, call it
+// before the first Step.
+func (model *Model) SetChannelWeights(r, g, b float64) {
+	model.channelWeights = [4]float64{math.Max(r, 0), math.Max(g, 0), math.Max(b, 0), 1}
+	model.updateWeights()
+}
+
+// channels returns the per-channel weights, red, green, blue and alpha.
+func (model *Model) channels() [4]float64 {
+	if model.channelWeights == [4]float64{} {
+		return unitChannels
+	}
+	return model.channelWeights
+}
+
+var unitChannels = [4]float64{1, 1, 1, 1}
+
+// updateWeights combines the weight mask and the detail map with the
+// transparency of the input and the placement mask, and recomputes the
+// score.
+func (model *Model) updateWeights() {
+	masked := model.detailWeighting()
+	weights := masked
+	if model.preserveAlpha && model.alpha != nil {
+		weights = make([]float64, len(model.alpha.Pix))
+		for i, a := range model.alpha.Pix {
+			switch {
+			case a == 0:
+				weights[i] = 0
+			case masked != nil:
+				weights[i] = masked[i]
+			default:
+				weights[i] = 1
+			}
+		}
+	}
+	if model.placement != nil {
+		// outside the placement mask the error does not count
+		placed := make([]float64, len(model.placement))
+		for i, in := range model.placement {
+			switch {
+			case !in:
+				placed[i] = 0
+			case weights != nil:
+				placed[i] = weights[i]
+			default:
+				placed[i] = 1
+			}
+		}
+		weights = placed
+	}
+	channels := model.channels()
+	if weights == nil && channels != unitChannels {
+		// channel weights need the weighted scoring even with no mask
+		weights = make([]float64, model.Target.Rect.Dx()*model.Target.Rect.Dy())
+		for i := range weights {
+			weights[i] = 1
+		}
+	}
+	model.weights = nil
+	model.weightNorm = 0
+	var sum float64
+	for _, w := range weights {
+		sum += w
+	}
+	if sum > 0 {
+		model.weights = weights
+		model.weightNorm = sum * (channels[0] + channels[1] + channels[2] + channels[3])
+	}
+	model.Score = model.differenceFull()
+}
+
+// alphaOf returns the alpha channel of im, or nil if im is opaque.
+func alphaOf(im image.Image) *image.Alpha {
+	if o, ok := im.(interface{ Opaque() bool }); ok && o.Opaque() {
+		return nil
+	}
+	b := im.Bounds()
+	alpha := image.NewAlpha(image.Rect(0, 0, b.Dx(), b.Dy()))
+	opaque := true
+	for y := 0; y < b.Dy(); y++ {
+		for x := 0; x < b.Dx(); x++ {
+			_, _, _, a := im.At(b.Min.X+x, b.Min.Y+y).RGBA()
+			alpha.Pix[y*alpha.Stride+x] = uint8(a >> 8)
+			if a != 0xffff {
+				opaque = false
+			}
+		}
+	}
+	if opaque {
+		return nil
+	}
+	return alpha
+}
+
+// applyAlpha scales the alpha of im, and with it the premultiplied color, by
+// the input's alpha stretched to fit. With a BackgroundAlpha the result is
+// composited over the background color at that alpha.
+func (model *Model) applyAlpha(src image.Image) image.Image {
+	im := imageToRGBA(src)
+	alpha := model.alpha
+	if im.Bounds().Size() != alpha.Bounds().Size() {
+		scaled := image.NewAlpha(image.Rect(0, 0, im.Rect.Dx(), im.Rect.Dy()))
+		xdraw.BiLinear.Scale(scaled, scaled.Rect, alpha, alpha.Rect, xdraw.Src, nil)
+		alpha = scaled
+	}
+	ba := uint32(clampInt(model.BackgroundAlpha, 0, 255))
+	bg := model.Background
+	backdrop := [4]uint32{uint32(bg.R) * ba / 255, uint32(bg.G) * ba / 255, uint32(bg.B) * ba / 255, ba}
+	for y := 0; y < im.Rect.Dy(); y++ {
+		i := im.PixOffset(im.Rect.Min.X, im.Rect.Min.Y+y)
+		for x := 0; x < im.Rect.Dx(); x++ {
+			a := uint32(alpha.Pix[y*alpha.Stride+x])
+			for j := 0; j < 4; j++ {
+				im.Pix[i+j] = uint8((uint32(im.Pix[i+j])*a + backdrop[j]*(255-a)) / 255)
+			}
+			i += 4
+		}
+	}
+	return im
+}
+
+func weightsFromMask(mask image.Image, bounds image.Rectangle) []float64 {
+	gray := image.NewGray(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	xdraw.BiLinear.Scale(gray, gray.Rect, mask, mask.Bounds(), xdraw.Src, nil)
+	weights := make([]float64, len(gray.Pix))
+	for i, v := range gray.Pix {
+		weights[i] = float64(v) / 255
+	}
+	return weights
+}
+
+// NormalizedScore returns the error of the canvas against the target in a
+// form that compares across image sizes and settings: the root mean squared
+// difference of the red, green and blue channels, averaged over the pixels
+// and the three channels, over 255, so 0 for an exact match and 1 for black
+// against white:
+//
+//	sqrt(sum((target - current)^2) / (w*h*3)) / 255
+//
+// Unlike Score it counts no alpha channel and ignores DetailWeighting,
+// ChannelWeights and the other weights, so a threshold on it means the same
+// on any image and run. For an opaque target without weights it is Score
+// times 2/sqrt(3). It is measured at the working resolution.
+func (model *Model) NormalizedScore() float64 {
+	return normalizedDifference(model.Target, model.Current)
+}
+
+func (model *Model) differenceFull() float64 {
+	if model.weights != nil {
+		return differenceFullWeighted(model.Target, model.Current, model.weights, model.channels(), model.weightNorm)
+	}
+	return differenceFull(model.Target, model.Current)
+}
+
+func (model *Model) differencePartial(before *image.RGBA, lines []Scanline) float64 {
+	if model.weights != nil {
+		return differencePartialWeighted(model.Target, before, model.Current, model.weights, model.channels(), model.weightNorm, model.Score, lines)
+	}
+	return differencePartial(model.Target, before, model.Current, model.Score, lines)
+}
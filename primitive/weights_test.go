@@ -0,0 +1,89 @@
+package primitive
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+// circularSprite returns a w x w orange disc on full transparency.
+func circularSprite(w int) *image.NRGBA {
+	im := image.NewNRGBA(image.Rect(0, 0, w, w))
+	r := w / 3
+	for y := 0; y < w; y++ {
+		for x := 0; x < w; x++ {
+			dx, dy := x-w/2, y-w/2
+			if dx*dx+dy*dy <= r*r {
+				im.SetNRGBA(x, y, color.NRGBA{240, 130, 20, 255})
+			}
+		}
+	}
+	return im
+}
+
+func TestPreserveAlphaIgnoresTransparentPixels(t *testing.T) {
+	orange := Color{240, 130, 20, 255}
+	model := NewModel(circularSprite(32), orange, 64, 1)
+	if model.Score == 0 {
+		t.Fatal("without PreserveAlpha the transparent pixels should count")
+	}
+	model.SetPreserveAlpha(true)
+	if model.Score != 0 {
+		t.Fatalf("score = %v over the disc's own color, want 0", model.Score)
+	}
+
+	// a white shape in a transparent corner changes nothing that is scored
+	model.FixedColor = &Color{255, 255, 255, 255}
+	model.Add(&Rectangle{model.Workers[0], 0, 0, 4, 4}, 255)
+	if model.Score != 0 {
+		t.Fatalf("score = %v after a shape off the disc, want 0", model.Score)
+	}
+	// and one on the disc does
+	model.Add(&Rectangle{model.Workers[0], 14, 14, 18, 18}, 255)
+	if model.Score == 0 {
+		t.Fatal("a shape on the disc left the score at 0")
+	}
+}
+
+// grayMask returns a w x h mask of value v on the left half and 255 on the
+// right.
+func grayMask(w, h int, v uint8) *image.Gray {
+	im := image.NewGray(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			if x < w/2 {
+				im.SetGray(x, y, color.Gray{v})
+			} else {
+				im.SetGray(x, y, color.Gray{255})
+			}
+		}
+	}
+	return im
+}
+
+func TestAddWeightMaskCombines(t *testing.T) {
+	model := NewModel(image.NewNRGBA(image.Rect(0, 0, 8, 8)), MakeHexColor("#fff"), 16, 1)
+	a := grayMask(8, 8, 51)  // 0.2 on the left
+	b := grayMask(8, 8, 153) // 0.6 on the left
+	near := func(got, want float64) bool { return got > want-1e-9 && got < want+1e-9 }
+
+	model.SetWeightMask(a)
+	model.AddWeightMask(b, 2)
+	left, right := model.maskWeights[0], model.maskWeights[7]
+	if want := 0.2 * 0.6 * 0.6; !near(left, want) || !near(right, 1) {
+		t.Fatalf("product weights %v and %v, want %v and 1", left, right, want)
+	}
+
+	model.SetMaskCombine(MaskSum)
+	left, right = model.maskWeights[0], model.maskWeights[7]
+	if want := (0.2 + 2*0.6) / 3; !near(left, want) || !near(right, 1) {
+		t.Fatalf("summed weights %v and %v, want %v and 1", left, right, want)
+	}
+
+	// a weight of zero leaves a mask out
+	model.SetMaskCombine(MaskMultiply)
+	model.AddWeightMask(grayMask(8, 8, 0), 0)
+	if want := 0.2 * 0.6 * 0.6; !near(model.maskWeights[0], want) {
+		t.Fatalf("weight %v with a zero weight mask added, want %v", model.maskWeights[0], want)
+	}
+}
@@ -0,0 +1,112 @@
+package primitive
+
+import (
+	"fmt"
+
+	"github.com/fogleman/gg"
+)
+
+// wireframeFill returns the paint of a shape's outline: WireframeColor, if
+// set, or the shape's own fill.
+func (model *Model) wireframeFill(c Color, g *Gradient) (Color, *Gradient) {
+	if model.WireframeColor != nil {
+		return *model.WireframeColor, nil
+	}
+	return c, g
+}
+
+// wireframeWidth returns the width of the outlines in working pixels.
+func (model *Model) wireframeWidth() float64 {
+	if model.WireframeWidth > 0 {
+		return model.WireframeWidth
+	}
+	return 1
+}
+
+// drawWireframe strokes a shape's outline onto dc, whose transform scales
+// working coordinates by sx, sy, without filling it. c and g are in canvas
+// colors. Quadratics stroke themselves and are drawn as they are.
+func (model *Model) drawWireframe(dc *gg.Context, shape Shape, c Color, g *Gradient, sx, sy float64) {
+	dc.SetLineJoin(model.StrokeJoin.lineJoin())
+	if g != nil {
+		p := gradientPattern(g, sx, sy)
+		dc.SetFillStyle(p)
+		dc.SetStrokeStyle(p)
+	} else {
+		dc.SetRGBA255(c.R, c.G, c.B, c.A)
+	}
+	scale := (sx + sy) / 2
+	if !outlinePath(dc, shape) {
+		shape.Draw(dc, scale)
+		return
+	}
+	dc.SetLineWidth(model.wireframeWidth() * scale)
+	dc.Stroke()
+}
+
+// outlinePath adds a shape's outline to dc's path, as its Draw method does
+// before filling it. It reports false for shapes that are not filled, the
+// quadratics, and leaves the path as it was.
+func outlinePath(dc *gg.Context, shape Shape) bool {
+	switch s := shape.(type) {
+	case *Triangle:
+		dc.NewSubPath()
+		dc.LineTo(float64(s.X1), float64(s.Y1))
+		dc.LineTo(float64(s.X2), float64(s.Y2))
+		dc.LineTo(float64(s.X3), float64(s.Y3))
+		dc.ClosePath()
+	case *Rectangle:
+		x1, y1, x2, y2 := s.bounds()
+		dc.DrawRectangle(float64(x1), float64(y1), float64(x2-x1+1), float64(y2-y1+1))
+	case *Ellipse:
+		dc.DrawEllipse(float64(s.X), float64(s.Y), float64(s.Rx), float64(s.Ry))
+	case *RotatedEllipse:
+		dc.Push()
+		dc.RotateAbout(radians(s.Angle), s.X, s.Y)
+		dc.DrawEllipse(s.X, s.Y, s.Rx, s.Ry)
+		dc.Pop()
+	case *RotatedRectangle:
+		sx, sy := float64(s.Sx), float64(s.Sy)
+		dc.Push()
+		dc.Translate(float64(s.X), float64(s.Y))
+		dc.Rotate(radians(float64(s.Angle)))
+		dc.DrawRectangle(-sx/2, -sy/2, sx, sy)
+		dc.Pop()
+	case *Polygon:
+		x, y := s.outline()
+		dc.NewSubPath()
+		for i := range x {
+			dc.LineTo(x[i], y[i])
+		}
+		dc.ClosePath()
+	default:
+		return false
+	}
+	return true
+}
+
+// svgWireframe returns the attributes that outline a shape in c, or in the
+// gradient with the given id if it is not empty, with no fill.
+func (model *Model) svgWireframe(c Color, gradient string) string {
+	stroke := fmt.Sprintf("stroke=\"#%02x%02x%02x\" stroke-opacity=\"%f\"", c.R, c.G, c.B, float64(c.A)/255)
+	if gradient != "" {
+		stroke = fmt.Sprintf("stroke=\"url(#%s)\"", gradient)
+	}
+	return fmt.Sprintf("fill=\"none\" %s stroke-width=\"%f\"", stroke, model.wireframeWidth())
+}
+
+// lottieWireframe replaces the fills among a shape's Lottie items with
+// strokes of the given width in the same paint.
+func lottieWireframe(items []lottieObject, width float64) {
+	for i, item := range items {
+		if item["ty"] == "fl" {
+			items[i] = lottieObject{
+				"ty": "st",
+				"c":  item["c"],
+				"o":  item["o"],
+				"w":  lottieStatic(width),
+				"lc": 1, "lj": 1,
+			}
+		}
+	}
+}
@@ -2,7 +2,9 @@ package primitive
 
 import (
 	"image"
+	"math"
 	"math/rand"
+	"sync/atomic"
 	"time"
 
 	"github.com/golang/freetype/raster"
@@ -19,6 +21,43 @@ type Worker struct {
 	Rnd        *rand.Rand
 	Score      float64
 	Counter    int
+
+	// evaluations counts candidate evaluations since the worker was made
+	// or its model reset. It is only updated atomically.
+	evaluations int64
+
+	// source is Rnd's source, which SaveState records.
+	source *replaySource
+
+	Step                int
+	MutationSchedules   map[ShapeType]MutationSchedule
+	MutationWeights     map[ShapeType][]float64
+	BlendMode           BlendMode
+	GradientFills       bool
+	AntialiasSearch     bool
+	JointAlpha          bool
+	StrokeJoin          StrokeJoin
+	ConvexPolygons      bool
+	FixedShapeSize      float64
+	MaxAspectRatio      float64
+	MinShapeFraction    float64
+	MaxShapeFraction    float64
+	Centers             *centerGrid
+	EdgeSegments        []edgeSegment
+	Placement           []bool
+	Region              image.Rectangle
+	Paints              []uint16
+	MaxPaints           int
+	GridSize            int
+	ColorSampleDilation int
+	Exposure            *[256]uint8
+	FixedColor          *Color
+	Feather             int
+	AcceptWorseProb     float64
+	AcceptWorseTemp     float64
+	Weights             []float64
+	ChannelWeights      [4]float64
+	WeightNorm          float64
 }
 
 func NewWorker(target *image.RGBA) *Worker {
@@ -32,7 +71,7 @@ func NewWorker(target *image.RGBA) *Worker {
 	worker.Rasterizer = raster.NewRasterizer(w, h)
 	worker.Lines = make([]Scanline, 0, 4096) // TODO: based on height
 	worker.Heatmap = NewHeatmap(w, h)
-	worker.Rnd = rand.New(rand.NewSource(time.Now().UnixNano()))
+	seedWorker(&worker, time.Now().UnixNano())
 	return &worker
 }
 
@@ -43,31 +82,181 @@ func (worker *Worker) Init(current *image.RGBA, score float64) {
 	worker.Heatmap.Clear()
 }
 
+// fixedSize returns a size, in pixels, within fixedSizeJitter of
+// FixedShapeSize, and whether FixedShapeSize is set.
+func (worker *Worker) fixedSize() (float64, bool) {
+	if worker.FixedShapeSize <= 0 {
+		return 0, false
+	}
+	s := worker.FixedShapeSize * float64(maxInt(worker.W, worker.H))
+	s *= 1 + fixedSizeJitter*(2*worker.Rnd.Float64()-1)
+	return math.Max(s, 1), true
+}
+
+// limitAspect shortens the longer of two sides, if need be, to within
+// MaxAspectRatio times the shorter.
+func (worker *Worker) limitAspect(a, b float64) (float64, float64) {
+	if worker.MaxAspectRatio <= 0 {
+		return a, b
+	}
+	r := math.Max(worker.MaxAspectRatio, 1)
+	if a > b*r {
+		a = b * r
+	} else if b > a*r {
+		b = a * r
+	}
+	return a, b
+}
+
+// gridded reports whether shapes that support it are snapped to a grid.
+func (worker *Worker) gridded() bool {
+	return worker.GridSize > 1
+}
+
+// offset returns a random mutation move of typical size d. On a grid it is
+// a whole number of cells, at least one, so that snapping does not undo it.
+func (worker *Worker) offset(d float64) int {
+	v := worker.Rnd.NormFloat64() * d
+	if !worker.gridded() {
+		return int(v)
+	}
+	g := float64(worker.GridSize)
+	cells := math.Round(v / g)
+	if cells == 0 {
+		cells = math.Copysign(1, v)
+	}
+	return int(cells * g)
+}
+
+// snap rounds v to the nearest grid line within [0, n).
+func (worker *Worker) snap(v, n int) int {
+	g := worker.GridSize
+	last := (n - 1) / g * g
+	return clampInt(int(math.Round(float64(v)/float64(g)))*g, 0, last)
+}
+
+// mutationScale returns the multiplier on mutation step sizes for a shape
+// type at the current step.
+func (worker *Worker) mutationScale(t ShapeType) float64 {
+	if schedule, ok := worker.MutationSchedules[t]; ok {
+		return schedule(worker.Step)
+	}
+	return 1
+}
+
 func (worker *Worker) Energy(shape Shape, alpha int) float64 {
 	worker.Counter++
+	atomic.AddInt64(&worker.evaluations, 1)
 	lines := shape.Rasterize()
+	if len(lines) == 0 {
+		// degenerate shapes cover nothing, so they can never improve the score
+		return worker.Score
+	}
+	if !worker.shapeAllowed(lines) {
+		// scoring out of bounds shapes as no improvement makes the search
+		// reject moves to them
+		return worker.Score
+	}
+	if worker.Feather > 0 {
+		lines = featherLines(lines, worker.Feather, worker.W, worker.H)
+	}
 	// worker.Heatmap.Add(lines)
-	color := computeColor(worker.Target, worker.Current, lines, alpha)
+	return worker.fillEnergy(lines, alpha)
+}
+
+// fillEnergy is the energy of a shape with the rasterization lines, fitted
+// and drawn without the size and placement checks.
+func (worker *Worker) fillEnergy(lines []Scanline, alpha int) float64 {
+	var color Color
+	var gradient *Gradient
+	if worker.FixedColor != nil {
+		color = worker.FixedColor.withAlpha(alpha)
+	} else {
+		sample := dilateLines(lines, worker.ColorSampleDilation, worker.W, worker.H)
+		color, gradient = fitFill(worker.Target, worker.Current, sample, alpha, worker.BlendMode, worker.GradientFills, worker.AntialiasSearch || worker.Feather > 0, worker.JointAlpha)
+		color, gradient = exposeFill(color, gradient, worker.Exposure)
+	}
 	copyLines(worker.Buffer, worker.Current, lines)
-	drawLines(worker.Buffer, color, lines)
+	drawFill(worker.Buffer, color, gradient, lines, worker.BlendMode)
+	if worker.Weights != nil {
+		return differencePartialWeighted(worker.Target, worker.Current, worker.Buffer, worker.Weights, worker.ChannelWeights, worker.WeightNorm, worker.Score, lines)
+	}
 	return differencePartial(worker.Target, worker.Current, worker.Buffer, worker.Score, lines)
 }
 
+// shapeAllowed reports whether lines are within the size bounds, mostly
+// inside any placement mask, wholly inside any region, within the paint cap
+// and, with MinCenterSpacing, far enough from the added shapes.
+func (worker *Worker) shapeAllowed(lines []Scanline) bool {
+	if !worker.sizeAllowed(lines) {
+		return false
+	}
+	if worker.Placement != nil && insideFraction(lines, worker.Placement, worker.W) < minInsidePlacement {
+		return false
+	}
+	if !worker.Region.Empty() && !linesWithin(lines, worker.Region) {
+		return false
+	}
+	if !worker.paintsAllowed(lines) {
+		return false
+	}
+	return worker.Centers == nil || worker.Centers.clear(linesCenter(lines))
+}
+
+// sizeAllowed reports whether lines cover a fraction of the image within
+// MinShapeFraction and MaxShapeFraction.
+func (worker *Worker) sizeAllowed(lines []Scanline) bool {
+	if worker.MinShapeFraction <= 0 && worker.MaxShapeFraction <= 0 {
+		return true
+	}
+	f := float64(linesArea(lines)) / float64(worker.W*worker.H)
+	if f < worker.MinShapeFraction {
+		return false
+	}
+	return worker.MaxShapeFraction <= 0 || f <= worker.MaxShapeFraction
+}
+
+// hillClimb climbs from state, taking worse moves as AcceptWorseProb and
+// AcceptWorseTemp allow.
+func (worker *Worker) hillClimb(state *State, age int) *State {
+	if worker.AcceptWorseProb <= 0 {
+		return HillClimb(state, age).(*State)
+	}
+	accept := func(change float64) bool {
+		// a change of zero is not a regression, but taking it would let
+		// the climb wander without limit on flat ground
+		if change == 0 {
+			return false
+		}
+		p := worker.AcceptWorseProb * math.Exp(-change/worker.AcceptWorseTemp)
+		return worker.Rnd.Float64() < p
+	}
+	return hillClimbAccepting(state, age, accept).(*State)
+}
+
 func (worker *Worker) BestHillClimbState(t ShapeType, a, n, age, m int) *State {
-	var bestEnergy float64
-	var bestState *State
+	if states := worker.bestHillClimbStates(t, a, n, age, m, 1); len(states) > 0 {
+		return states[0]
+	}
+	return nil
+}
+
+// bestHillClimbStates is BestHillClimbState keeping the k best of the m
+// climbs, best first.
+func (worker *Worker) bestHillClimbStates(t ShapeType, a, n, age, m, k int) []*State {
+	states := make([]*State, 0, m)
 	for i := 0; i < m; i++ {
 		state := worker.BestRandomState(t, a, n)
 		before := state.Energy()
-		state = HillClimb(state, age).(*State)
-		energy := state.Energy()
-		vv("%dx random: %.6f -> %dx hill climb: %.6f\n", n, before, age, energy)
-		if i == 0 || energy < bestEnergy {
-			bestEnergy = energy
-			bestState = state
-		}
+		state = worker.hillClimb(state, age)
+		vv("%dx random: %.6f -> %dx hill climb: %.6f\n", n, before, age, state.Energy())
+		states = append(states, state)
 	}
-	return bestState
+	sortStates(states)
+	if len(states) > k {
+		states = states[:k]
+	}
+	return states
 }
 
 func (worker *Worker) BestRandomState(t ShapeType, a, n int) *State {
@@ -84,10 +273,26 @@ func (worker *Worker) BestRandomState(t ShapeType, a, n int) *State {
 	return bestState
 }
 
+// maxEmptyMutations is how many times RandomState mutates a shape that
+// covers nothing before it gives up and returns it as it is, which scores
+// as no improvement.
+const maxEmptyMutations = 100
+
 func (worker *Worker) RandomState(t ShapeType, a int) *State {
+	state := worker.randomState(t, a)
+	for i := 0; i < maxEmptyMutations && len(state.Shape.Rasterize()) == 0; i++ {
+		state.Shape.Mutate()
+	}
+	return state
+}
+
+func (worker *Worker) randomState(t ShapeType, a int) *State {
+	if shape := worker.edgeShape(t); shape != nil {
+		return NewState(worker, shape, a)
+	}
 	switch t {
 	default:
-		return worker.RandomState(ShapeType(worker.Rnd.Intn(8)+1), a)
+		return worker.randomState(ShapeType(worker.Rnd.Intn(8)+1), a)
 	case ShapeTypeTriangle:
 		return NewState(worker, NewRandomTriangle(worker), a)
 	case ShapeTypeRectangle:
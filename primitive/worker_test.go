@@ -0,0 +1,88 @@
+package primitive
+
+import (
+	"image"
+	"math"
+	"testing"
+)
+
+// areaLines returns scanlines covering area pixels in rows of width w.
+func areaLines(area, w int) []Scanline {
+	var lines []Scanline
+	for y := 0; area > 0; y++ {
+		n := minInt(area, w)
+		lines = append(lines, Scanline{y, 0, n - 1, 0xffff})
+		area -= n
+	}
+	return lines
+}
+
+func TestSizeAllowedAtBothBounds(t *testing.T) {
+	worker := NewWorker(image.NewRGBA(image.Rect(0, 0, 10, 10)))
+	worker.MinShapeFraction = 0.1
+	worker.MaxShapeFraction = 0.3
+	for _, c := range []struct {
+		area    int
+		allowed bool
+	}{
+		{9, false}, {10, true}, {30, true}, {31, false},
+	} {
+		if got := worker.sizeAllowed(areaLines(c.area, 10)); got != c.allowed {
+			t.Fatalf("area %d of 100: allowed = %v, want %v", c.area, got, c.allowed)
+		}
+	}
+}
+
+func TestShapeFractionBoundsTheSearch(t *testing.T) {
+	const min, max = 0.05, 0.15
+	model := NewModel(testTarget(24, 24), MakeHexColor("#808080"), 48, 1)
+	model.MinShapeFraction = min
+	model.MaxShapeFraction = max
+	for i := 0; i < 10; i++ {
+		model.Step(ShapeTypeRectangle, 128, 0)
+	}
+	if len(model.Shapes) == 0 {
+		t.Fatal("no shape within the bounds was added")
+	}
+	for i, shape := range model.Shapes {
+		if f := model.areaFraction(shape.Rasterize()); f < min || f > max {
+			t.Fatalf("shape %d covers %v of the image, outside [%v, %v]", i, f, min, max)
+		}
+	}
+}
+
+// sides returns the side lengths, or radii, of the shapes MaxAspectRatio
+// applies to.
+func sides(shape Shape) (float64, float64) {
+	switch s := shape.(type) {
+	case *Rectangle:
+		x1, y1, x2, y2 := s.bounds()
+		return float64(x2 - x1 + 1), float64(y2 - y1 + 1)
+	case *RotatedRectangle:
+		return float64(s.Sx), float64(s.Sy)
+	case *Ellipse:
+		return float64(s.Rx), float64(s.Ry)
+	case *RotatedEllipse:
+		return s.Rx, s.Ry
+	}
+	panic("no sides")
+}
+
+func TestMaxAspectRatioKeepsShapesNearSquare(t *testing.T) {
+	const ratio = 1.2
+	model := NewModel(testTarget(48, 48), MakeHexColor("#808080"), 96, 1)
+	model.MaxAspectRatio = ratio
+	worker := model.Workers[0]
+	model.initWorker(worker)
+	for _, st := range []ShapeType{ShapeTypeRectangle, ShapeTypeRotatedRectangle, ShapeTypeEllipse, ShapeTypeRotatedEllipse} {
+		for i := 0; i < 100; i++ {
+			shape := worker.RandomState(st, 128).Shape
+			for j := 0; j < 20; j++ {
+				if a, b := sides(shape); math.Max(a, b) > ratio*math.Min(a, b) {
+					t.Fatalf("%v after %d mutations is %v by %v, past %v to 1", st, j, a, b, ratio)
+				}
+				shape.Mutate()
+			}
+		}
+	}
+}
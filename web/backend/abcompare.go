@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"log"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/fogleman/primitive/primitive"
+)
+
+// compareBlock is one side of POST /api/compare. Fields it leaves out take
+// the shared form's values.
+type compareBlock struct {
+	Mode  int `json:"mode"`
+	Count int `json:"count"`
+	Alpha int `json:"alpha"`
+}
+
+// compareSides are the form fields holding the blocks, in output order.
+var compareSides = []string{"a", "b"}
+
+// handleCompare renders one upload with two parameter blocks, one after the
+// other through processImageSync, and returns them side by side in a JPEG,
+// each captioned with its settings, for A/B tuning.
+func handleCompare(c *gin.Context) {
+	if c.ContentType() == "application/json" {
+		c.JSON(400, gin.H{"error": "compare takes a multipart form"})
+		return
+	}
+	upload, req, ok := readMultipartRequest(c)
+	if !ok {
+		return
+	}
+	defer upload.Close()
+	if req.Video || req.Layers > 0 || req.ContactSheet || req.Compare || req.Metrics || req.Phases != "" {
+		c.JSON(400, gin.H{"error": "compare cannot be combined with video, layers, contactsheet, compare, metrics or phases"})
+		return
+	}
+
+	sides := make([]ProcessRequest, len(compareSides))
+	for i, name := range compareSides {
+		block := compareBlock{Mode: req.Mode, Count: req.Count, Alpha: req.Alpha}
+		if str := c.PostForm(name); str != "" {
+			if err := json.Unmarshal([]byte(str), &block); err != nil {
+				c.JSON(400, gin.H{"error": fmt.Sprintf("%s must be a JSON object of mode, count and alpha", name)})
+				return
+			}
+		}
+		if block.Mode < 0 || block.Mode > maxShapeType {
+			c.JSON(400, gin.H{"error": fmt.Sprintf("%s: mode must be between 0 and %d", name, maxShapeType)})
+			return
+		}
+		side := req
+		side.Mode, side.Count, side.Alpha = block.Mode, block.Count, block.Alpha
+		side.Format = "png"
+		if !validateRequest(c, side) {
+			return
+		}
+		sides[i] = side
+	}
+	if _, ok := checkFormat(c, upload); !ok {
+		return
+	}
+
+	tiles := make([]image.Image, len(sides))
+	labels := make([]string, len(sides))
+	for i, side := range sides {
+		if _, err := upload.Seek(0, io.SeekStart); err != nil {
+			c.JSON(500, gin.H{"error": fmt.Sprintf("failed to read image: %v", err)})
+			return
+		}
+		log.Printf("Comparing %s: count=%d, mode=%d, alpha=%d", compareSides[i], side.Count, side.Mode, side.Alpha)
+		result, err := processImageSync(upload, side)
+		var reqErr requestError
+		if errors.As(err, &reqErr) {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		tiles[i], _, err = image.Decode(bytes.NewReader(result.Data))
+		if err != nil {
+			c.JSON(500, gin.H{"error": fmt.Sprintf("failed to decode result: %v", err)})
+			return
+		}
+		labels[i] = fmt.Sprintf("%s: %s, %d shapes, alpha %d, score %.4f", compareSides[i],
+			primitive.ShapeType(side.Mode), result.Metrics.Shapes, side.Alpha, result.Metrics.FinalScore)
+	}
+
+	var buf bytes.Buffer
+	sheet := primitive.ContactSheet(tiles, labels, len(tiles))
+	if err := primitive.EncodeJPEG(&buf, sheet, 95, req.DPI); err != nil {
+		c.JSON(500, gin.H{"error": fmt.Sprintf("failed to encode result: %v", err)})
+		return
+	}
+	c.Data(200, "image/jpeg", buf.Bytes())
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/json"
+	"image"
+	"image/color"
+	"io"
+	"log"
+	"math/bits"
+	"sync"
+
+	"github.com/nfnt/resize"
+
+	"github.com/fogleman/primitive/primitive"
+)
+
+// Defaults for the result cache. RESULT_CACHE_SIZE sets the number of
+// results kept, 0 turning the cache off. By default uploads match only by
+// their exact SHA-256. A RESULT_CACHE_DISTANCE of 0 or more matches them
+// perceptually instead, allowing that many of the 64 perceptual hash bits
+// to differ.
+const (
+	defaultCacheSize     = 32
+	defaultCacheDistance = -1
+)
+
+// cacheColorTolerance is how far, per channel, the mean colors of two
+// uploads may differ for a perceptual match. The hash sees only the
+// structure of the luma, so without it images of the same layout in other
+// colors would share results.
+const cacheColorTolerance = 8
+
+// Results larger than this are not cached, so a few frame ZIPs cannot
+// crowd out everything else.
+const maxCachedResult = 4 << 20
+
+// resultCache is an LRU cache of finished results, keyed by the request's
+// parameters and its image. A nil cache caches nothing.
+type resultCache struct {
+	mu       sync.Mutex
+	size     int
+	distance int
+	entries  *list.List // of *cacheEntry, most recently used first
+}
+
+// cacheKey identifies a request. Either sum is used, or hash and mean
+// together, as the cache's distance says.
+type cacheKey struct {
+	params string
+	hash   uint64
+	mean   color.NRGBA
+	sum    [sha256.Size]byte
+}
+
+type cacheEntry struct {
+	key    cacheKey
+	result *ProcessResult
+}
+
+// resultCacheFromEnv builds the cache from the environment. It returns nil
+// when caching is turned off.
+func resultCacheFromEnv() *resultCache {
+	size := envInt("RESULT_CACHE_SIZE", defaultCacheSize)
+	distance := envInt("RESULT_CACHE_DISTANCE", defaultCacheDistance)
+	if size <= 0 {
+		log.Printf("Result cache disabled")
+		return nil
+	}
+	if distance < 0 {
+		log.Printf("Caching %d results by exact image", size)
+	} else {
+		log.Printf("Caching %d results by perceptual hash (distance %d)", size, distance)
+	}
+	return &resultCache{size: size, distance: distance, entries: list.New()}
+}
+
+func (rc *resultCache) exact() bool {
+	return rc.distance < 0
+}
+
+// sum returns the SHA-256 of the upload when the cache matches exact images,
+// and rewinds the upload for decoding.
+func (rc *resultCache) sum(upload io.ReadSeeker) ([sha256.Size]byte, error) {
+	var sum [sha256.Size]byte
+	if rc == nil || !rc.exact() {
+		return sum, nil
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, upload); err != nil {
+		return sum, err
+	}
+	copy(sum[:], h.Sum(nil))
+	_, err := upload.Seek(0, io.SeekStart)
+	return sum, err
+}
+
+// key builds the cache key for a request with its decoded image and, for
+// exact matching, the upload's sum.
+func (rc *resultCache) key(req ProcessRequest, input image.Image, sum [sha256.Size]byte) cacheKey {
+	if rc == nil {
+		return cacheKey{}
+	}
+	params, _ := json.Marshal(req)
+	key := cacheKey{params: string(params)}
+	if rc.exact() {
+		key.sum = sum
+	} else {
+		key.hash = dHash(input)
+		key.mean = primitive.AverageImageColor(input)
+	}
+	return key
+}
+
+func (rc *resultCache) matches(a, b cacheKey) bool {
+	if a.params != b.params {
+		return false
+	}
+	if rc.exact() {
+		return a.sum == b.sum
+	}
+	return bits.OnesCount64(a.hash^b.hash) <= rc.distance && colorsNear(a.mean, b.mean)
+}
+
+// colorsNear reports whether every channel of a and b is within
+// cacheColorTolerance.
+func colorsNear(a, b color.NRGBA) bool {
+	for _, d := range []int{int(a.R) - int(b.R), int(a.G) - int(b.G), int(a.B) - int(b.B)} {
+		if d < -cacheColorTolerance || d > cacheColorTolerance {
+			return false
+		}
+	}
+	return true
+}
+
+// get returns the most recently used result matching key, or nil.
+func (rc *resultCache) get(key cacheKey) *ProcessResult {
+	if rc == nil {
+		return nil
+	}
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	for e := rc.entries.Front(); e != nil; e = e.Next() {
+		if entry := e.Value.(*cacheEntry); rc.matches(entry.key, key) {
+			rc.entries.MoveToFront(e)
+			return entry.result
+		}
+	}
+	return nil
+}
+
+// put adds a result, evicting the least recently used one if the cache is
+// full.
+func (rc *resultCache) put(key cacheKey, result *ProcessResult) {
+	if rc == nil || len(result.Data) > maxCachedResult {
+		return
+	}
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.entries.PushFront(&cacheEntry{key, result})
+	for rc.entries.Len() > rc.size {
+		rc.entries.Remove(rc.entries.Back())
+	}
+}
+
+// dHash is the difference hash of im: the image is shrunk to 9x8 gray
+// pixels and each bit says whether a pixel is brighter than its right hand
+// neighbor. Recompressing or rescaling an image rarely flips more than a few
+// bits.
+func dHash(im image.Image) uint64 {
+	small := resize.Resize(9, 8, im, resize.Bilinear)
+	var hash uint64
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			a := color.GrayModel.Convert(small.At(x, y)).(color.Gray).Y
+			b := color.GrayModel.Convert(small.At(x+1, y)).(color.Gray).Y
+			hash <<= 1
+			if a > b {
+				hash |= 1
+			}
+		}
+	}
+	return hash
+}
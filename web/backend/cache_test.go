@@ -0,0 +1,49 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"testing"
+)
+
+// flatPNG returns a 32x32 PNG of one color, its bytes and the decoded image.
+func flatPNG(t *testing.T, c color.Color) ([]byte, image.Image) {
+	im := image.NewNRGBA(image.Rect(0, 0, 32, 32))
+	draw.Draw(im, im.Rect, &image.Uniform{c}, image.Point{}, draw.Src)
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, im); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes(), im
+}
+
+func TestResultCacheSeparatesFlatColors(t *testing.T) {
+	red, redImage := flatPNG(t, color.NRGBA{220, 20, 20, 255})
+	blue, blueImage := flatPNG(t, color.NRGBA{20, 20, 220, 255})
+	req := defaultProcessRequest()
+
+	for _, distance := range []string{"", "4"} {
+		t.Setenv("RESULT_CACHE_SIZE", "4")
+		t.Setenv("RESULT_CACHE_DISTANCE", distance)
+		rc := resultCacheFromEnv()
+		key := func(data []byte, input image.Image) cacheKey {
+			sum, err := rc.sum(bytes.NewReader(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			return rc.key(req, input, sum)
+		}
+
+		result := &ProcessResult{Data: []byte("red render")}
+		rc.put(key(red, redImage), result)
+		if got := rc.get(key(blue, blueImage)); got != nil {
+			t.Fatalf("distance %q: the blue upload got the red upload's result", distance)
+		}
+		if got := rc.get(key(red, redImage)); got != result {
+			t.Fatalf("distance %q: the red upload missed its own result", distance)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"log"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// defaultMaxConcurrent is how many renders run at once, across all clients.
+// MAX_CONCURRENT_RENDERS overrides it; 0 turns the limit off.
+const defaultMaxConcurrent = 4
+
+// busyRetryAfter is the Retry-After, in seconds, sent with a 503. Typical
+// renders finish within it.
+const busyRetryAfter = 5
+
+// renderLimiter bounds the renders in flight for the whole instance. Each
+// render runs workerCount workers and holds several full size buffers, so
+// past a few at once they only thrash and risk running out of memory.
+// Unlike the rate limiter it does not care who is asking.
+type renderLimiter struct {
+	slots chan struct{}
+}
+
+func newRenderLimiter(n int) *renderLimiter {
+	return &renderLimiter{slots: make(chan struct{}, n)}
+}
+
+// renderLimiterFromEnv builds the limiter from the environment. It returns
+// nil when the limit is turned off.
+func renderLimiterFromEnv() *renderLimiter {
+	n := envInt("MAX_CONCURRENT_RENDERS", defaultMaxConcurrent)
+	if n <= 0 {
+		log.Printf("Concurrent render limit disabled")
+		return nil
+	}
+	log.Printf("Running at most %d renders at once", n)
+	return newRenderLimiter(n)
+}
+
+// tryAcquire takes a slot if one is free, without waiting. A nil limiter
+// always has one.
+func (l *renderLimiter) tryAcquire() bool {
+	if l == nil {
+		return true
+	}
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (l *renderLimiter) release() {
+	if l != nil {
+		<-l.slots
+	}
+}
+
+// middleware rejects requests while every slot is taken with 503 and a
+// Retry-After header, rather than queuing them.
+func (l *renderLimiter) middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !l.tryAcquire() {
+			renderBusy(c)
+			return
+		}
+		defer l.release()
+		c.Next()
+	}
+}
+
+// renderBusy answers a request that found every render slot taken.
+func renderBusy(c *gin.Context) {
+	c.Header("Retry-After", strconv.Itoa(busyRetryAfter))
+	c.AbortWithStatusJSON(503, gin.H{"error": "Server busy, try again shortly"})
+}
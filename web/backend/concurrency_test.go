@@ -0,0 +1,47 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRenderLimiterTripsAtLimit(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(newRenderLimiter(1).middleware())
+	started, finish := make(chan struct{}), make(chan struct{})
+	r.GET("/slow", func(c *gin.Context) {
+		close(started)
+		<-finish
+		c.Status(200)
+	})
+	r.GET("/fast", func(c *gin.Context) { c.Status(200) })
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w
+	}
+
+	slow := make(chan int)
+	go func() { slow <- get("/slow").Code }()
+	<-started
+	w := get("/fast")
+	if w.Code != 503 {
+		t.Fatalf("request with every slot taken: status %d, want 503", w.Code)
+	}
+	if retry := w.Header().Get("Retry-After"); retry != strconv.Itoa(busyRetryAfter) {
+		t.Fatalf("Retry-After = %q, want %d", retry, busyRetryAfter)
+	}
+
+	close(finish)
+	if code := <-slow; code != 200 {
+		t.Fatalf("request holding the slot: status %d", code)
+	}
+	if w := get("/fast"); w.Code != 200 {
+		t.Fatalf("request after the slot was freed: status %d", w.Code)
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"image"
+	"time"
+
+	"github.com/fogleman/primitive/primitive"
+)
+
+// contactSheetModes are the shape types on a contactsheet=1 sheet, in order.
+var contactSheetModes = []primitive.ShapeType{
+	primitive.ShapeTypeTriangle,
+	primitive.ShapeTypeRectangle,
+	primitive.ShapeTypeEllipse,
+	primitive.ShapeTypeCircle,
+}
+
+// Each sheet tile adds at most contactSheetCount shapes and is rendered to
+// fit a contactSheetTile box, two tiles to a row.
+const (
+	contactSheetCount   = 50
+	contactSheetTile    = 384
+	contactSheetColumns = 2
+)
+
+// renderContactSheet runs a short search of each of contactSheetModes and
+// tiles the renders, labelled with their mode, into one image. The modes run
+// at once through primitive.RunMany, sharing the request's workers.
+func renderContactSheet(rl *requestLog, input image.Image, bg primitive.Color, mask image.Image, workers int, req ProcessRequest) (image.Image, error) {
+	count := min(req.Count, contactSheetCount)
+	configs := make([]primitive.RunConfig, len(contactSheetModes))
+	for i, mode := range contactSheetModes {
+		configs[i] = primitive.RunConfig{
+			Target:     input,
+			Background: bg,
+			Size:       1024,
+			Workers:    max(workers/len(contactSheetModes), 1),
+			Shape:      mode,
+			Count:      count,
+			Alpha:      req.Alpha,
+			Configure: func(model *primitive.Model) {
+				configureModel(model, req)
+				model.SetWeightMask(mask)
+			},
+		}
+	}
+	start := time.Now()
+	models, err := primitive.RunMany(configs, workers)
+	if err != nil {
+		return nil, err
+	}
+	rl.Printf("⏱️  Contact sheet searches (%d shapes each): %v", count, time.Since(start))
+	tiles := make([]image.Image, len(models))
+	labels := make([]string, len(models))
+	for i, model := range models {
+		s := float64(contactSheetTile) / float64(max(model.Sw, model.Sh))
+		model.OutputWidth = max(int(float64(model.Sw)*s), 1)
+		model.OutputHeight = max(int(float64(model.Sh)*s), 1)
+		tiles[i] = model.Render()
+		labels[i] = contactSheetModes[i].String()
+		rl.Printf("⏱️  Contact sheet %s: score=%.6f", contactSheetModes[i], model.Score)
+		modelPool.Put(model)
+	}
+	return primitive.ContactSheet(tiles, labels, contactSheetColumns), nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"github.com/fogleman/primitive/primitive"
+)
+
+// debugAllowed says whether requests may ask for debug=1. It is off unless
+// ALLOW_DEBUG=1, as the logs show server details such as the worker count.
+var debugAllowed bool
+
+// maxDebugLines bounds the log lines returned with debug=1, so a long run
+// cannot grow the response header without limit.
+const maxDebugLines = 200
+
+// maxDebugHeader bounds X-Primitive-Debug, in bytes, well under the 8KB
+// many proxies allow for all of a response's headers.
+const maxDebugHeader = 4096
+
+// DebugInfo is what debug=1 returns alongside the result: the choices the
+// server made and the request's own log lines.
+type DebugInfo struct {
+	Background string   `json:"background"`
+	Workers    int      `json:"workers"`
+	FinalScore float64  `json:"finalScore"`
+	Logs       []string `json:"logs"`
+	// Truncated says whether log lines were left out to fit the header.
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+// requestLog writes to the server log like log.Printf and, for debug=1
+// requests, keeps each line for the response. Requests run concurrently, so
+// the server log interleaves them; the kept lines are the request's own.
+type requestLog struct {
+	capture bool
+	lines   []string
+}
+
+func (rl *requestLog) Printf(format string, args ...interface{}) {
+	log.Printf(format, args...)
+	if rl.capture && len(rl.lines) < maxDebugLines {
+		rl.lines = append(rl.lines, fmt.Sprintf(format, args...))
+	}
+}
+
+// info returns the request's debug output, or nil if it did not ask for it.
+func (rl *requestLog) info(bg primitive.Color, workers int, score float64) *DebugInfo {
+	if !rl.capture {
+		return nil
+	}
+	return &DebugInfo{
+		Background: fmt.Sprintf("#%02x%02x%02x", bg.R, bg.G, bg.B),
+		Workers:    workers,
+		FinalScore: score,
+		Logs:       rl.lines,
+	}
+}
+
+// header returns the debug output as base64 encoded JSON for
+// X-Primitive-Debug, leaving out the last log lines, and setting Truncated,
+// as needed to keep it within maxDebugHeader bytes.
+func (d DebugInfo) header() string {
+	for {
+		data, _ := json.Marshal(d)
+		if base64.StdEncoding.EncodedLen(len(data)) <= maxDebugHeader || len(d.Logs) == 0 {
+			return base64.StdEncoding.EncodeToString(data)
+		}
+		d.Logs = d.Logs[:len(d.Logs)-1]
+		d.Truncated = true
+	}
+}
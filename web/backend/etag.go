@@ -0,0 +1,38 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"strings"
+)
+
+// requestETag returns the ETag of a request: the SHA-256 of the upload's
+// bytes followed by the request's parameters, as the cache keys them, so
+// the same file with the same settings always has the same tag. The upload
+// is rewound afterwards.
+func requestETag(upload io.ReadSeeker, req ProcessRequest) (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, upload); err != nil {
+		return "", err
+	}
+	if _, err := upload.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	params, _ := json.Marshal(req)
+	h.Write(params)
+	return `"` + hex.EncodeToString(h.Sum(nil)) + `"`, nil
+}
+
+// etagMatches reports whether an If-None-Match header names etag, or is *.
+// Weak tags match their strong form, as If-None-Match compares weakly.
+func etagMatches(header, etag string) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == "*" || tag == etag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// decodeFormats are the input formats with a registered decoder. Building
+// with the extraformats tag adds bmp and tiff.
+var decodeFormats = []string{"png", "jpeg", "gif"}
+
+// knownFormats identifies common image formats by their leading bytes, so an
+// upload in a format without a decoder can be named in the error. A '?' in
+// a signature matches any byte.
+var knownFormats = []struct {
+	name, magic string
+}{
+	{"bmp", "BM"},
+	{"tiff", "II*\x00"},
+	{"tiff", "MM\x00*"},
+	{"webp", "RIFF????WEBP"},
+	{"heic", "????ftypheic"},
+	{"heic", "????ftypheix"},
+	{"heic", "????ftypmif1"},
+	{"avif", "????ftypavif"},
+	{"ico", "\x00\x00\x01\x00"},
+	{"psd", "8BPS"},
+}
+
+// sniffFormat names the format of an image from its leading bytes, or
+// returns "unknown".
+func sniffFormat(head []byte) string {
+	for _, f := range knownFormats {
+		if len(head) >= len(f.magic) && matchMagic(head, f.magic) {
+			return f.name
+		}
+	}
+	return "unknown"
+}
+
+func matchMagic(head []byte, magic string) bool {
+	for i := 0; i < len(magic); i++ {
+		if magic[i] != '?' && head[i] != magic[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// checkFormat reads the upload's header and rewinds it for decoding. An
+// upload no decoder recognizes gets a 415 naming the supported formats, and
+// checkFormat returns false. Headers that are recognized but unreadable
+// are left for the decoder to report, with a zero config.
+func checkFormat(c *gin.Context, upload io.ReadSeeker) (image.Config, bool) {
+	var head bytes.Buffer
+	config, _, err := image.DecodeConfig(io.TeeReader(upload, &head))
+	if _, seekErr := upload.Seek(0, io.SeekStart); seekErr != nil {
+		c.JSON(500, gin.H{"error": "Failed to read image"})
+		return image.Config{}, false
+	}
+	if errors.Is(err, image.ErrFormat) {
+		format := sniffFormat(head.Bytes())
+		c.JSON(415, gin.H{"error": fmt.Sprintf("unsupported image format %s; supported formats are %s", format, strings.Join(decodeFormats, ", "))})
+		return image.Config{}, false
+	}
+	if err != nil {
+		return image.Config{}, true
+	}
+	return config, true
+}
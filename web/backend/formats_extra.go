@@ -0,0 +1,12 @@
+//go:build extraformats
+
+package main
+
+import (
+	_ "golang.org/x/image/bmp"
+	_ "golang.org/x/image/tiff"
+)
+
+func init() {
+	decodeFormats = append(decodeFormats, "bmp", "tiff")
+}
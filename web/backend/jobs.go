@@ -0,0 +1,495 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"io"
+	"log"
+	mathrand "math/rand"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/fogleman/primitive/primitive"
+)
+
+// Defaults for the job registry. JOB_TTL_SECONDS sets how long a job may go
+// without a request before it is stopped and dropped, and MAX_JOBS how many
+// may exist at once.
+const (
+	defaultJobTTLSeconds = 600
+	defaultMaxJobs       = 8
+)
+
+// Job states, as reported to clients.
+const (
+	jobRunning = "running"
+	jobPaused  = "paused"
+	jobDone    = "done"
+)
+
+// jobOp is a command sent to a job's control channel.
+type jobOp int
+
+const (
+	jobPause jobOp = iota
+	jobResume
+	jobStop
+)
+
+// jobControl is a command and, unless nil, the channel its resulting state
+// is sent back on.
+type jobControl struct {
+	op    jobOp
+	reply chan string
+}
+
+// A job is a render that runs in the background between requests, so it
+// can be paused, inspected and resumed. Its own goroutine takes commands
+// from control until it is stopped, when it closes exited.
+//
+// The search holds modelMu for each step, and anything else that reads
+// the model takes it too. mu guards the progress the search publishes
+// after each step, which requests read without waiting for one, along with
+// the last JPEG rendered and the shape count it shows.
+type job struct {
+	id      string
+	control chan jobControl
+	exited  chan struct{}
+	phases  []primitive.Phase
+	alpha   int
+	dpi     int
+	seed    int64
+
+	modelMu sync.Mutex
+	model   *primitive.Model
+
+	mu         sync.Mutex
+	steps      int
+	shapes     int
+	score      float64
+	state      string
+	lastUsed   time.Time
+	jpeg       []byte
+	jpegShapes int
+}
+
+// jobRegistry maps job IDs to jobs and drops those not used within ttl.
+type jobRegistry struct {
+	mu   sync.Mutex
+	jobs map[string]*job
+	ttl  time.Duration
+	max  int
+}
+
+// jobRegistryFromEnv builds the registry from the environment and starts
+// its cleanup.
+func jobRegistryFromEnv() *jobRegistry {
+	ttl := time.Duration(envInt("JOB_TTL_SECONDS", defaultJobTTLSeconds)) * time.Second
+	if ttl <= 0 {
+		ttl = defaultJobTTLSeconds * time.Second
+	}
+	r := &jobRegistry{jobs: make(map[string]*job), ttl: ttl, max: envInt("MAX_JOBS", defaultMaxJobs)}
+	log.Printf("Keeping at most %d jobs, each for %v after its last request", r.max, ttl)
+	go r.cleanup()
+	return r
+}
+
+// cleanup stops and drops the jobs no request has touched within the TTL,
+// checking a few times per TTL.
+func (r *jobRegistry) cleanup() {
+	ticker := time.NewTicker(r.ttl / 4)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		r.mu.Lock()
+		var expired []*job
+		for id, j := range r.jobs {
+			j.mu.Lock()
+			idle := now.Sub(j.lastUsed)
+			j.mu.Unlock()
+			if idle > r.ttl {
+				expired = append(expired, j)
+				delete(r.jobs, id)
+			}
+		}
+		r.mu.Unlock()
+		for _, j := range expired {
+			log.Printf("Dropping job %s, idle for over %v", j.id, r.ttl)
+			j.control <- jobControl{op: jobStop}
+		}
+	}
+}
+
+// add registers j under a new ID. It returns false when the registry is
+// full.
+func (r *jobRegistry) add(j *job) bool {
+	var id [16]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return false
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.jobs) >= r.max {
+		return false
+	}
+	j.id = hex.EncodeToString(id[:])
+	r.jobs[j.id] = j
+	return true
+}
+
+// get returns the job with the ID, marking it used, or nil.
+func (r *jobRegistry) get(id string) *job {
+	r.mu.Lock()
+	j := r.jobs[id]
+	r.mu.Unlock()
+	if j != nil {
+		j.mu.Lock()
+		j.lastUsed = time.Now()
+		j.mu.Unlock()
+	}
+	return j
+}
+
+// run takes commands until the job is stopped. A search runs in its own
+// goroutine while the job is running, holding a render slot, so that jobs
+// count toward MAX_CONCURRENT_RENDERS like any other render; the first
+// one's slot is taken by the caller. Pausing cancels the search and
+// answers at once; the search stops after the step under way and then
+// frees its slot, and resuming before then waits for it. A resume that
+// finds no free slot leaves the job paused.
+func (j *job) run() {
+	var cancel context.CancelFunc
+	var searching chan struct{}
+	start := func() {
+		var ctx context.Context
+		ctx, cancel = context.WithCancel(context.Background())
+		searching = make(chan struct{})
+		go j.search(ctx, searching)
+	}
+	stop := func() {
+		if cancel != nil {
+			cancel()
+			<-searching
+			cancel, searching = nil, nil
+			renders.release()
+		}
+	}
+	start()
+	for {
+		select {
+		case <-searching:
+			// the search ended, on its own unless a pause cancelled it
+			cancel()
+			cancel, searching = nil, nil
+			renders.release()
+			if j.getState() == jobRunning {
+				j.setState(jobDone)
+			}
+		case cmd := <-j.control:
+			switch cmd.op {
+			case jobPause:
+				if j.getState() == jobRunning && cancel != nil {
+					cancel()
+					j.setState(jobPaused)
+				}
+			case jobResume:
+				if j.getState() == jobPaused {
+					stop()
+					if renders.tryAcquire() {
+						j.setState(jobRunning)
+						start()
+					}
+				}
+			case jobStop:
+				stop()
+				j.modelMu.Lock()
+				modelPool.Put(j.model)
+				j.model = nil
+				j.modelMu.Unlock()
+				j.setState(jobDone)
+				close(j.exited)
+				if cmd.reply != nil {
+					cmd.reply <- jobDone
+				}
+				return
+			}
+			if cmd.reply != nil {
+				cmd.reply <- j.getState()
+			}
+		}
+	}
+}
+
+// search runs the job's steps, one StepContext call each, until the phases
+// are done, the coverage cap is reached or ctx is cancelled, and then
+// closes done. As in POST /api/process, each phase takes its count of
+// steps whether or not they add a shape, so steps that size, spacing or
+// coverage options reject still end the search.
+func (j *job) search(ctx context.Context, done chan struct{}) {
+	defer close(done)
+	for {
+		j.mu.Lock()
+		n := j.steps
+		j.mu.Unlock()
+		t, ok := j.nextType(n)
+		if !ok {
+			return
+		}
+		j.modelMu.Lock()
+		_, err := j.model.StepContext(ctx, t, j.alpha, 0)
+		shapes, score := len(j.model.Shapes), j.model.NormalizedScore()
+		j.modelMu.Unlock()
+		j.mu.Lock()
+		if err == nil {
+			j.steps++
+		}
+		j.shapes, j.score = shapes, score
+		j.mu.Unlock()
+		if err != nil {
+			return
+		}
+	}
+}
+
+// nextType returns the shape type of step n, or false once the phases are
+// done.
+func (j *job) nextType(n int) (primitive.ShapeType, bool) {
+	for _, phase := range j.phases {
+		if n < phase.Count {
+			return phase.Type, true
+		}
+		n -= phase.Count
+	}
+	return 0, false
+}
+
+func (j *job) setState(state string) {
+	j.mu.Lock()
+	j.state = state
+	j.mu.Unlock()
+}
+
+func (j *job) getState() string {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.state
+}
+
+// status returns the job's ID, state, shape and step counts, the total
+// count of steps, score and seed.
+func (j *job) status() gin.H {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return gin.H{
+		"id":     j.id,
+		"state":  j.state,
+		"shapes": j.shapes,
+		"steps":  j.steps,
+		"count":  totalCount(j.phases),
+		"score":  j.score,
+		"seed":   j.seed,
+	}
+}
+
+// command sends op to the job and returns its resulting state, done if the
+// job has already stopped.
+func (j *job) command(op jobOp) string {
+	reply := make(chan string, 1)
+	select {
+	case j.control <- jobControl{op, reply}:
+		return <-reply
+	case <-j.exited:
+		return jobDone
+	}
+}
+
+// render encodes the model's canvas as the job's JPEG. It does nothing,
+// rather than wait, while a step holds the model, and once the job has
+// been dropped.
+func (j *job) render() error {
+	if !j.modelMu.TryLock() {
+		return nil
+	}
+	defer j.modelMu.Unlock()
+	if j.model == nil {
+		return nil
+	}
+	var buf bytes.Buffer
+	if err := primitive.EncodeJPEG(&buf, j.model.Render(), 95, j.dpi); err != nil {
+		return err
+	}
+	j.mu.Lock()
+	j.jpeg, j.jpegShapes = buf.Bytes(), len(j.model.Shapes)
+	j.mu.Unlock()
+	return nil
+}
+
+// startJob decodes an upload and sets up a model for it as POST
+// /api/process would for a single attempt, turned to the requested
+// orientation and weighted for the focus, and starts its search, which
+// takes over the render slot the caller holds. It returns a nil job when
+// the registry is full.
+func startJob(upload io.Reader, req ProcessRequest) (*job, error) {
+	input, _, err := image.Decode(upload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image: %v", err)
+	}
+	original := input.Bounds().Size()
+	input = thumbnail(input, req.workingSize(), req.Resample)
+	focus, focusPoints := req.Focus, req.FocusPoints
+	if needsTurn(req.Orient, original) {
+		input = turnClockwise(input)
+		focus, focusPoints = turnFocus(focus, focusPoints, original.Y)
+		original = image.Point{original.Y, original.X}
+	}
+	if req.Count <= 0 && req.Phases == "" {
+		req.Count = primitive.SuggestShapeCount(input, suggestedScore)
+	}
+	phases, err := req.phases()
+	if err != nil {
+		return nil, err
+	}
+	model := getModel(input, req.background(input), workerCount)
+	configureModel(model, req)
+	model.SetWeightMask(focusMask(focus, focusPoints, original, input.Bounds()))
+	seed := mathrand.Int63()
+	model.Seed(seed)
+	model.Phases = phases
+	j := &job{
+		control:  make(chan jobControl),
+		exited:   make(chan struct{}),
+		phases:   phases,
+		alpha:    req.Alpha,
+		dpi:      req.DPI,
+		seed:     seed,
+		model:    model,
+		score:    model.NormalizedScore(),
+		state:    jobRunning,
+		lastUsed: time.Now(),
+	}
+	// the bare canvas, so that there is always a JPEG to return
+	if err := j.render(); err != nil {
+		modelPool.Put(model)
+		return nil, fmt.Errorf("failed to encode result: %v", err)
+	}
+	if !jobs.add(j) {
+		modelPool.Put(model)
+		return nil, nil
+	}
+	go j.run()
+	return j, nil
+}
+
+// handleStartJob starts a job for a multipart upload with the fields of
+// POST /api/process that shape the search, and returns its status, with
+// the ID the other job endpoints take.
+func handleStartJob(c *gin.Context) {
+	upload, req, ok := readMultipartRequest(c)
+	if !ok {
+		return
+	}
+	defer upload.Close()
+	if req.Video || req.Layers > 0 || req.ContactSheet || req.Compare || req.Metrics || req.TopK > 0 || req.Canvas != "" || req.Attempts > 1 || req.MaxSVGBytes > 0 || len(req.InitialShapes) > 0 || req.BgStat == "optimize" {
+		c.JSON(400, gin.H{"error": "jobs cannot be combined with video, layers, contactsheet, compare, metrics, topk, canvas, attempts, maxSvgBytes, initialShapes or bgStat=optimize"})
+		return
+	}
+	if req.Format != "jpeg" && req.Format != "jpg" {
+		c.JSON(400, gin.H{"error": "jobs return jpeg only, so format must be jpeg"})
+		return
+	}
+	if !validateRequest(c, req) {
+		return
+	}
+	if _, ok := checkFormat(c, upload); !ok {
+		return
+	}
+	if !renders.tryAcquire() {
+		renderBusy(c)
+		return
+	}
+	j, err := startJob(upload, req)
+	if err != nil {
+		renders.release()
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if j == nil {
+		renders.release()
+		c.Header("Retry-After", strconv.Itoa(busyRetryAfter))
+		c.JSON(503, gin.H{"error": "too many jobs"})
+		return
+	}
+	log.Printf("Started job %s: count=%d, mode=%d, alpha=%d", j.id, totalCount(j.phases), req.Mode, req.Alpha)
+	c.JSON(200, j.status())
+}
+
+// lookupJob returns the job named in the path, answering with a 404 if
+// there is none.
+func lookupJob(c *gin.Context) *job {
+	j := jobs.get(c.Param("id"))
+	if j == nil {
+		c.JSON(404, gin.H{"error": "no such job"})
+	}
+	return j
+}
+
+// handleJobStatus returns a job's status.
+func handleJobStatus(c *gin.Context) {
+	if j := lookupJob(c); j != nil {
+		c.JSON(200, j.status())
+	}
+}
+
+// handlePauseJob stops a running job's search after the step under way,
+// and returns its status without waiting for the step.
+func handlePauseJob(c *gin.Context) {
+	if j := lookupJob(c); j != nil {
+		j.command(jobPause)
+		c.JSON(200, j.status())
+	}
+}
+
+// handleResumeJob restarts a paused job's search and returns its status,
+// or answers as busy if no render slot is free.
+func handleResumeJob(c *gin.Context) {
+	if j := lookupJob(c); j != nil {
+		if j.command(jobResume) == jobPaused {
+			renderBusy(c)
+			return
+		}
+		c.JSON(200, j.status())
+	}
+}
+
+// handleJobCurrent returns a JPEG of a job's shapes so far, with its state
+// and the number of shapes the JPEG shows in X-Primitive-Job-State and
+// X-Primitive-Shapes. The canvas is rendered again if shapes have been
+// added since the last JPEG, but while a step is under way the last one is
+// returned rather than waiting for the step.
+func handleJobCurrent(c *gin.Context) {
+	j := lookupJob(c)
+	if j == nil {
+		return
+	}
+	j.mu.Lock()
+	stale := j.jpegShapes != j.shapes
+	j.mu.Unlock()
+	if stale {
+		if err := j.render(); err != nil {
+			c.JSON(500, gin.H{"error": fmt.Sprintf("failed to encode result: %v", err)})
+			return
+		}
+	}
+	j.mu.Lock()
+	state, shapes, data := j.state, j.jpegShapes, j.jpeg
+	j.mu.Unlock()
+	c.Header("X-Primitive-Job-State", state)
+	c.Header("X-Primitive-Shapes", strconv.Itoa(shapes))
+	c.Data(200, "image/jpeg", data)
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ProcessJSONRequest is the application/json form of a process request. The
+// image is given either inline as base64 (optionally as a data URI) or as a
+// URL that the server fetches.
+type ProcessJSONRequest struct {
+	ProcessRequest
+	ImageBase64 string `json:"imageBase64"`
+	ImageURL    string `json:"imageUrl"`
+}
+
+const fetchTimeout = 10 * time.Second
+
+var errBlockedAddress = errors.New("address is not publicly routable")
+
+// fetchClient refuses to connect to loopback, private, link-local, shared
+// and other addresses that are not publicly routable. The check runs on the
+// resolved address at dial time, so it also covers redirects and DNS names
+// that point inward.
+var fetchClient = &http.Client{
+	Timeout: fetchTimeout,
+	Transport: &http.Transport{
+		Proxy: nil,
+		DialContext: (&net.Dialer{
+			Timeout: fetchTimeout,
+			Control: func(network, address string, _ syscall.RawConn) error {
+				host, _, err := net.SplitHostPort(address)
+				if err != nil {
+					return err
+				}
+				ip := net.ParseIP(host)
+				if ip == nil || !publicIP(ip) {
+					return errBlockedAddress
+				}
+				return nil
+			},
+		}).DialContext,
+		TLSHandshakeTimeout:   fetchTimeout,
+		ResponseHeaderTimeout: fetchTimeout,
+	},
+}
+
+// blockedNets are ranges that are not publicly routable but that the net.IP
+// checks in publicIP miss: "this network", 0.0.0.0/8, of which only 0.0.0.0
+// is unspecified, and carrier-grade NAT's shared address space.
+var blockedNets = []*net.IPNet{
+	mustParseCIDR("0.0.0.0/8"),
+	mustParseCIDR("100.64.0.0/10"),
+}
+
+func mustParseCIDR(s string) *net.IPNet {
+	_, n, err := net.ParseCIDR(s)
+	if err != nil {
+		panic(err)
+	}
+	return n
+}
+
+func publicIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() {
+		return false
+	}
+	for _, n := range blockedNets {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// memoryUpload is an image that arrived in the JSON body or was fetched, and
+// so is already in memory.
+type memoryUpload struct {
+	*bytes.Reader
+}
+
+func (memoryUpload) Close() error { return nil }
+
+// readJSONRequest reads the image and parameters from a JSON body. On
+// failure it writes the error response and returns false.
+func readJSONRequest(c *gin.Context) (io.ReadSeekCloser, ProcessRequest, bool) {
+	body := ProcessJSONRequest{ProcessRequest: defaultProcessRequest()}
+	decoder := json.NewDecoder(io.LimitReader(c.Request.Body, maxUploadSize*2))
+	if err := decoder.Decode(&body); err != nil {
+		log.Printf("Failed to parse JSON body: %v", err)
+		c.JSON(400, gin.H{"error": "Failed to parse JSON body"})
+		return nil, body.ProcessRequest, false
+	}
+
+	var fileData []byte
+	var err error
+	switch {
+	case body.ImageBase64 != "" && body.ImageURL != "":
+		c.JSON(400, gin.H{"error": "Provide only one of imageBase64 or imageUrl"})
+		return nil, body.ProcessRequest, false
+	case body.ImageBase64 != "":
+		fileData, err = decodeBase64Image(body.ImageBase64)
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return nil, body.ProcessRequest, false
+		}
+	case body.ImageURL != "":
+		fileData, err = fetchImage(body.ImageURL)
+		if err != nil {
+			log.Printf("Failed to fetch %s: %v", body.ImageURL, err)
+			c.JSON(400, gin.H{"error": fmt.Sprintf("Failed to fetch imageUrl: %v", err)})
+			return nil, body.ProcessRequest, false
+		}
+	default:
+		c.JSON(400, gin.H{"error": "One of imageBase64 or imageUrl is required"})
+		return nil, body.ProcessRequest, false
+	}
+
+	log.Printf("Received JSON image (%d bytes)", len(fileData))
+	return memoryUpload{bytes.NewReader(fileData)}, body.ProcessRequest, true
+}
+
+func decodeBase64Image(data string) ([]byte, error) {
+	// accept data URIs as well as bare base64
+	if strings.HasPrefix(data, "data:") {
+		i := strings.Index(data, ",")
+		if i < 0 || !strings.HasSuffix(data[:i], ";base64") {
+			return nil, errors.New("imageBase64 is not a base64 data URI")
+		}
+		data = data[i+1:]
+	}
+	if base64.StdEncoding.DecodedLen(len(data)) > maxUploadSize {
+		return nil, fmt.Errorf("image exceeds %d bytes", maxUploadSize)
+	}
+	fileData, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return nil, errors.New("imageBase64 is not valid base64")
+	}
+	return fileData, nil
+}
+
+func fetchImage(rawURL string) ([]byte, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, errors.New("imageUrl must be an absolute http or https URL")
+	}
+	resp, err := fetchClient.Get(u.String())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	if resp.ContentLength > maxUploadSize {
+		return nil, fmt.Errorf("image exceeds %d bytes", maxUploadSize)
+	}
+	fileData, err := io.ReadAll(io.LimitReader(resp.Body, maxUploadSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(fileData) > maxUploadSize {
+		return nil, fmt.Errorf("image exceeds %d bytes", maxUploadSize)
+	}
+	return fileData, nil
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/png"
+	"io"
+	"time"
+
+	"github.com/fogleman/primitive/primitive"
+)
+
+// maxLayers bounds the layers in a layers=N response. Long runs put more
+// shapes in each layer to stay under it.
+const maxLayers = 100
+
+// writeLayersZip writes the model's shapes to w as a ZIP of transparent PNG
+// layers of groupSize shapes each, 000000.png onward in draw order, over a
+// solid background.png. Layers are rendered and encoded one at a time to
+// bound memory.
+func writeLayersZip(w io.Writer, model *primitive.Model, groupSize int) error {
+	groupSize = max(groupSize, (len(model.Shapes)+maxLayers-1)/maxLayers)
+	now := time.Now()
+	zw := zip.NewWriter(w)
+	add := func(name string, im image.Image) error {
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, im); err != nil {
+			return err
+		}
+		return storeFile(zw, name, buf.Bytes(), now)
+	}
+	// the background matches the layers, which are the size Render is
+	ow, oh := model.Sw, model.Sh
+	if model.OutputWidth > 0 && model.OutputHeight > 0 {
+		ow, oh = model.OutputWidth, model.OutputHeight
+	}
+	background := image.NewNRGBA(image.Rect(0, 0, ow, oh))
+	draw.Draw(background, background.Rect, image.NewUniform(model.Background.NRGBA()), image.Point{}, draw.Src)
+	if err := add("background.png", background); err != nil {
+		return err
+	}
+	for k := 0; k < model.LayerCount(groupSize); k++ {
+		if err := add(fmt.Sprintf("%06d.png", k), model.LayerImage(k, groupSize)); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
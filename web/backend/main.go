@@ -2,116 +2,847 @@ package main
 
 import (
 	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"image"
-	"image/jpeg"
-	_ "image/png"
+	"image/color"
 	"io"
 	"log"
+	"math"
+	"math/rand"
+	"net/http"
 	"os"
 	"runtime"
+	"slices"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
-	
+
 	"github.com/fogleman/primitive/primitive"
-	"github.com/nfnt/resize"
 )
 
 type ProcessRequest struct {
-	Count int `json:"count"`
-	Mode  int `json:"mode"`
-	Alpha int `json:"alpha"`
+	Count    int  `json:"count"`
+	Mode     int  `json:"mode"`
+	Alpha    int  `json:"alpha"`
+	Attempts int  `json:"attempts"`
+	AA       int  `json:"aa"`
+	Metrics  bool `json:"metrics"`
+	Native   bool `json:"native"`
+	Compare  bool `json:"compare"`
+
+	// Video returns a ZIP of PNG frames showing the shapes being added
+	// instead of the final image.
+	Video bool `json:"video"`
+
+	// Layers returns a ZIP of transparent PNG layers of this many shapes
+	// each, over a background, instead of the final image.
+	Layers int `json:"layers"`
+
+	// Working returns the canvas the search scored, at the working
+	// resolution, instead of the render at the output size.
+	Working bool `json:"working"`
+
+	// DataURI returns the result as a JSON object holding it as a base64
+	// data URI, for embedding in an <img src> without another fetch.
+	DataURI bool `json:"datauri"`
+
+	// Thumb, when positive, returns a JSON object holding the result and a
+	// thumbnail of it fitting a Thumb x Thumb box, both base64.
+	Thumb int `json:"thumb"`
+
+	// TopK renders only the TopK shapes that lowered the score the most.
+	TopK int `json:"topk"`
+
+	// ContactSheet returns one image tiling short searches of each of
+	// contactSheetModes instead of a single render, for picking a mode.
+	ContactSheet bool `json:"contactSheet"`
+
+	// Detail is the working resolution: the input is shrunk to fit a
+	// Detail x Detail box before the search.
+	Detail int `json:"detail"`
+
+	// MaxSVGBytes, when positive, stops adding shapes before the SVG output
+	// would grow past this many bytes.
+	MaxSVGBytes int `json:"maxSvgBytes"`
+
+	// MaxCoverage, when positive, stops adding shapes once their areas add
+	// up to this many times the canvas's.
+	MaxCoverage float64 `json:"maxCoverage"`
+
+	// Margin, when positive, keeps the shapes this fraction of the shorter
+	// side away from the edges.
+	Margin float64 `json:"margin"`
+
+	// Resample is how the input is shrunk to the working resolution:
+	// bilinear, or area, which averages the pixels each one covers.
+	Resample string `json:"resample"`
+
+	// Sharpen, when positive, sharpens the output by this amount.
+	Sharpen float64 `json:"sharpen"`
+
+	// NoResize searches at the upload's own resolution, up to
+	// maxNoResizeSize, instead of at Detail.
+	NoResize bool `json:"noresize"`
+
+	// DPI is the print density recorded in JPEG and PNG output.
+	DPI int `json:"dpi"`
+
+	// PreserveAlpha keeps the input's transparency in the output and leaves
+	// transparent pixels out of the search.
+	PreserveAlpha bool `json:"preserveAlpha"`
+
+	// BgAlpha, with PreserveAlpha, fills the input's transparent parts with
+	// the background color at this alpha.
+	BgAlpha int `json:"bgAlpha"`
+
+	// Colors quantizes the output to this many colors; zero leaves it as is.
+	Colors int `json:"colors"`
+
+	// Phases runs several shape types one after another on the same canvas,
+	// as type:count pairs such as "1:200,4:100". It overrides Count and Mode.
+	Phases string `json:"phases"`
+
+	// Border frames the output in a solid border this many output pixels
+	// wide, in BorderColor, a hex color.
+	Border      int    `json:"border"`
+	BorderColor string `json:"borderColor"`
+
+	// Canvas, as WxH, letterboxes the JPEG or PNG output to that size: the
+	// render keeps the input's aspect and is centered on the background.
+	Canvas string `json:"canvas"`
+
+	// FixedColor, a hex color, is the color of every shape, at Alpha,
+	// instead of a fitted one; empty fits colors as usual.
+	FixedColor string `json:"fixedColor"`
+
+	// Wireframe draws the shapes as outlines with no fill, in
+	// WireframeColor, a hex color, or in their fitted colors if it is
+	// empty.
+	Wireframe      bool   `json:"wireframe"`
+	WireframeColor string `json:"wireframeColor"`
+
+	// Halftone, when positive, draws the shapes as dots on a screen of
+	// cells this many working pixels across, turned by HalftoneAngle
+	// degrees.
+	Halftone      int     `json:"halftone"`
+	HalftoneAngle float64 `json:"halftoneAngle"`
+
+	// onPreview, when set, is called with the model once previewShapes
+	// shapes have been added, for streaming a preview.
+	onPreview func(*primitive.Model)
+
+	// DebugColors draws the shapes in debug colors, by their index or their
+	// type, instead of their fitted ones: none, index or type.
+	DebugColors string `json:"debug_colors"`
+
+	// Orient turns the input a quarter turn, if needed, so that the output
+	// is landscape or portrait; auto leaves it as it is.
+	Orient string `json:"orient"`
+
+	// BgStat picks the background color: the image's mean, its median, the
+	// mean of its corners or the mean hill climbed to the lowest score.
+	BgStat string `json:"bgStat"`
+
+	// Format names a registered primitive.Encoder, such as jpeg, png or svg.
+	Format string `json:"format"`
+
+	// Focus is an x, y, w, h box in input pixels that is reproduced with
+	// higher fidelity than the rest of the image.
+	Focus []int `json:"focus"`
+
+	// FocusPoints are x, y pairs in input pixels around which the image is
+	// reproduced with higher fidelity, falling off with distance.
+	FocusPoints []int `json:"focusPoints"`
+
+	// Debug returns the request's log lines, the background color, the
+	// worker count and the final score with the result. It needs
+	// ALLOW_DEBUG=1 on the server.
+	Debug bool `json:"debug"`
+
+	// InitialShapes is a shape list, in the format=json schema, drawn on
+	// the canvas before the search adds Count shapes on top. Its size must
+	// be the working size of the input.
+	InitialShapes json.RawMessage `json:"initialShapes"`
+}
+
+// Uploads larger than this are rejected, whichever way they arrive.
+const maxUploadSize = 32 << 20 // 32MB
+
+// Multipart uploads larger than this are spooled to a temporary file rather
+// than held in memory, and decoded from there.
+const maxMultipartMemory = 1 << 20
+
+// multipartOverhead allows for the form fields and part headers around a
+// maxUploadSize file.
+const multipartOverhead = 1 << 20
+
+// Each attempt is a full search, so keep this small.
+const maxAttempts = 5
+
+// Supersampling renders at aa times the 1024px output, so 4 already means a
+// 4096px canvas.
+const maxAA = 4
+
+// Inputs are shrunk to fit inputSize before the search, or to fit the
+// request's detail, which must be one of detailSizes.
+const inputSize = 256
+
+var detailSizes = []int{128, 256, 384, 512}
+
+// With noresize=1 inputs are only shrunk if they exceed this size, which
+// bounds the search's buffers at about 4MB each. Search time grows with the
+// pixel count, so a 1024px input takes about 16 times as long as at 256.
+const maxNoResizeSize = 1024
+
+// Output records defaultDPI unless the request's dpi, at most maxDPI, says
+// otherwise.
+const (
+	defaultDPI = 72
+	maxDPI     = 2400
+)
+
+// A request without a count gets the number of shapes that
+// primitive.SuggestShapeCount expects to bring the normalized score to
+// suggestedScore, which is 0.06 in the units of the model's own score.
+// Its ETA, which is predicted before decoding, assumes etaShapeCount.
+const (
+	suggestedScore = 0.0693
+	etaShapeCount  = 100
+)
+
+// maxBorder bounds the border param, in output pixels.
+const maxBorder = 512
+
+// backgroundIterations bounds the rounds of bgStat=optimize.
+const backgroundIterations = 32
+
+// compareGap is the width of the separator in compare=1 output.
+const compareGap = 16
+
+// errorOverlayFormat is the format that returns the render's error as a
+// heat overlay on the input, as a PNG. It has no registered encoder, since
+// it needs the input.
+const errorOverlayFormat = "error-overlay"
+
+// errorOverlayOpacity is how strongly the heat covers the worst errors in
+// error-overlay output.
+const errorOverlayOpacity = 0.8
+
+// With bgStat=corners each corner sample is a square this fraction of the
+// resized input's shorter side.
+const cornerSampleDivisor = 10
+
+// Quantized output is paletted, which caps the color count.
+const maxColors = 256
+
+// Native renders are shrunk to fit this size, and aa is lowered as needed to
+// keep the supersampled canvas within maxRenderSize.
+const (
+	maxNativeSize = 4096
+	maxRenderSize = 8192
+)
+
+// ProcessMetrics describes a finished render. Durations are in milliseconds.
+type ProcessMetrics struct {
+	Shapes            int            `json:"shapes"`
+	ShapeTypes        map[string]int `json:"shapeTypes"`
+	FinalScore        float64        `json:"finalScore"`
+	NormalizedScore   float64        `json:"normalizedScore"`
+	ElapsedMs         float64        `json:"elapsedMs"`
+	Workers           int            `json:"workers"`
+	Background        string         `json:"background"`
+	WorkerEvaluations []int          `json:"workerEvaluations"`
+	Seed              int64          `json:"seed"`
+	Coverage          float64        `json:"coverage"`
+	CoverageCapped    bool           `json:"coverageCapped"`
+	Timings           PhaseTimings   `json:"timings"`
+	Debug             *DebugInfo     `json:"debug,omitempty"`
+}
+
+// PhaseTimings splits ElapsedMs by phase. Encoders render as they encode, so
+// Encode includes the final render.
+type PhaseTimings struct {
+	DecodeMs float64 `json:"decode"`
+	ResizeMs float64 `json:"resize"`
+	SearchMs float64 `json:"search"`
+	EncodeMs float64 `json:"encode"`
+}
+
+type ProcessResult struct {
+	Data        []byte
+	ContentType string
+	Metrics     ProcessMetrics
+}
+
+// modelPool holds idle models between requests. Reset reuses a pooled
+// model's buffers and workers when the new thumbnail has the same size as the
+// last one; locally that cut model setup for a 256px input with 8 workers
+// from about 2.1ms to 0.5ms and avoids several megabytes of garbage per
+// request. The pool grows to the number of models in use at once and the
+// garbage collector trims it when idle.
+var modelPool sync.Pool
+
+func getModel(input image.Image, bg primitive.Color, workers int) *primitive.Model {
+	if model, ok := modelPool.Get().(*primitive.Model); ok && len(model.Workers) == workers {
+		model.Reset(input, bg)
+		return model
+	}
+	return primitive.NewModel(input, bg, 1024, workers)
+}
+
+// configureModel applies the per-request settings. Pooled models keep
+// whatever the previous request set, so every setting is assigned here.
+func configureModel(model *primitive.Model, req ProcessRequest) {
+	model.RenderScale = req.AA
+	model.OutputWidth = 0
+	model.OutputHeight = 0
+	model.QuantizeColors = req.Colors
+	model.SetPreserveAlpha(req.PreserveAlpha)
+	model.BackgroundAlpha = req.BgAlpha
+	model.Border(req.Border, primitive.MakeHexColor(req.BorderColor))
+	model.DebugColorMode = debugColorModes[req.DebugColors]
+	model.FixedColor = nil
+	if req.FixedColor != "" {
+		c := primitive.MakeHexColor(req.FixedColor)
+		model.FixedColor = &c
+	}
+	model.Wireframe = req.Wireframe
+	model.WireframeColor = nil
+	if req.WireframeColor != "" {
+		c := primitive.MakeHexColor(req.WireframeColor)
+		model.WireframeColor = &c
+	}
+	model.Halftone(req.Halftone, req.HalftoneAngle)
+	model.MaxCumulativeCoverage = req.MaxCoverage
+	model.Sharpen = req.Sharpen
+	model.Margin = req.Margin
+}
+
+// debugColorModes maps the debug_colors param to primitive's modes.
+var debugColorModes = map[string]primitive.DebugColorMode{
+	"none":  primitive.DebugColorNone,
+	"index": primitive.DebugColorByIndex,
+	"type":  primitive.DebugColorByType,
+}
+
+// maxShapeType is the highest valid mode.
+const maxShapeType = int(primitive.ShapeTypePolygon)
+
+// phases returns the request's steps as phases: the parsed Phases param, or
+// a single phase of Count steps of Mode.
+func (req ProcessRequest) phases() ([]primitive.Phase, error) {
+	if req.Phases == "" {
+		return []primitive.Phase{{Type: primitive.ShapeType(req.Mode), Count: req.Count}}, nil
+	}
+	var phases []primitive.Phase
+	for _, part := range strings.Split(req.Phases, ",") {
+		fields := strings.Split(strings.TrimSpace(part), ":")
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("phase %q is not type:count", part)
+		}
+		t, err1 := strconv.Atoi(fields[0])
+		n, err2 := strconv.Atoi(fields[1])
+		if err1 != nil || err2 != nil {
+			return nil, fmt.Errorf("phase %q is not type:count", part)
+		}
+		if t < 0 || t > maxShapeType {
+			return nil, fmt.Errorf("phase %q: type must be between 0 and %d", part, maxShapeType)
+		}
+		if n < 1 {
+			return nil, fmt.Errorf("phase %q: count must be positive", part)
+		}
+		phases = append(phases, primitive.Phase{Type: primitive.ShapeType(t), Count: n})
+	}
+	return phases, nil
 }
 
-func processImageSync(inputData []byte, count, mode, alpha int) ([]byte, error) {
+// canvasSize parses Canvas, returning zeros if there is none.
+func (req ProcessRequest) canvasSize() (int, int, error) {
+	if req.Canvas == "" {
+		return 0, 0, nil
+	}
+	var w, h int
+	fields := strings.Split(req.Canvas, "x")
+	if len(fields) == 2 {
+		var err1, err2 error
+		w, err1 = strconv.Atoi(fields[0])
+		h, err2 = strconv.Atoi(fields[1])
+		if err1 != nil || err2 != nil {
+			w, h = 0, 0
+		}
+	}
+	if w < 1 || h < 1 || w > maxNativeSize || h > maxNativeSize {
+		return 0, 0, fmt.Errorf("canvas must be WxH, each between 1 and %d", maxNativeSize)
+	}
+	return w, h, nil
+}
+
+// initialShapes parses InitialShapes, returning nil if there are none.
+func (req ProcessRequest) initialShapes() (*primitive.ShapeList, error) {
+	if len(req.InitialShapes) == 0 || string(req.InitialShapes) == "null" {
+		return nil, nil
+	}
+	list, err := primitive.UnmarshalShapes(req.InitialShapes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid initialShapes: %v", err)
+	}
+	if len(list.Shapes) > maxRenderShapes {
+		return nil, fmt.Errorf("initialShapes may hold at most %d shapes", maxRenderShapes)
+	}
+	return list, nil
+}
+
+// requestError is a fault in the request that only shows once the image is
+// decoded. It is answered with a 400 rather than a 500.
+type requestError struct {
+	error
+}
+
+// background returns the background color BgStat picks for input. With
+// bgStat=optimize it is the mean, which the caller then refines.
+func (req ProcessRequest) background(input image.Image) primitive.Color {
+	switch req.BgStat {
+	case "median":
+		return primitive.MakeColor(primitive.MedianImageColor(input))
+	case "corners":
+		size := input.Bounds().Size()
+		return primitive.MakeColor(primitive.CornerBackgroundColor(input, max(min(size.X, size.Y)/cornerSampleDivisor, 1)))
+	}
+	return primitive.MakeColor(primitive.AverageImageColor(input))
+}
+
+// workingSize is the longest side the input is shrunk to before the search.
+func (req ProcessRequest) workingSize() int {
+	if req.NoResize {
+		return maxNoResizeSize
+	}
+	return req.Detail
+}
+
+func totalCount(phases []primitive.Phase) int {
+	total := 0
+	for _, phase := range phases {
+		total += phase.Count
+	}
+	return total
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+func processImageSync(upload io.ReadSeeker, req ProcessRequest) (*ProcessResult, error) {
 	start := time.Now()
-	
-	// Load input image from memory
+	result := &ProcessResult{}
+	metrics := &result.Metrics
+	rl := &requestLog{capture: req.Debug}
+
+	// Decode the input straight from the upload
 	t1 := time.Now()
-	reader := bytes.NewReader(inputData)
-	input, _, err := image.Decode(reader)
+	sum, err := results.sum(upload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image: %v", err)
+	}
+	input, _, err := image.Decode(upload)
 	if err != nil {
 		return nil, fmt.Errorf("failed to decode image: %v", err)
 	}
-	log.Printf("⏱️  Image decode: %v", time.Since(t1))
+	decoded := input
+	original := input.Bounds().Size()
+	metrics.Timings.DecodeMs = milliseconds(time.Since(t1))
+	rl.Printf("⏱️  Image decode: %v", time.Since(t1))
+
+	key := results.key(req, input, sum)
+	if cached := results.get(key); cached != nil {
+		rl.Printf("🎯 Returning cached result")
+		return cached, nil
+	}
 
 	// Resize input for faster processing
 	t2 := time.Now()
-	input = resize.Thumbnail(256, 256, input, resize.Bilinear)
-	log.Printf("⏱️  Image resize: %v", time.Since(t2))
+	size := req.workingSize()
+	input = thumbnail(input, size, req.Resample)
+	metrics.Timings.ResizeMs = milliseconds(time.Since(t2))
+	rl.Printf("⏱️  Image resize: %v", time.Since(t2))
+
+	// Turn the input to the requested orientation. The thumbnail is turned
+	// rather than the full image, which fits the same box either way, and
+	// the focus and the native output size follow it.
+	focus, focusPoints := req.Focus, req.FocusPoints
+	if needsTurn(req.Orient, original) {
+		input = turnClockwise(input)
+		if req.Compare {
+			decoded = turnClockwise(decoded)
+		}
+		focus, focusPoints = turnFocus(focus, focusPoints, original.Y)
+		original = image.Point{original.Y, original.X}
+		rl.Printf("Turned the input to %s", req.Orient)
+	}
+
+	// Without a count, pick one to suit the image's complexity
+	if req.Count <= 0 && req.Phases == "" {
+		req.Count = primitive.SuggestShapeCount(input, suggestedScore)
+		rl.Printf("Suggested %d shapes", req.Count)
+	}
 
 	// Setup background color
 	t3 := time.Now()
-	bg := primitive.MakeColor(primitive.AverageImageColor(input))
-	log.Printf("⏱️  Background color: %v", time.Since(t3))
+	bg := req.background(input)
+	rl.Printf("⏱️  Background color: %v", time.Since(t3))
+
+	// Build the weight mask for the focus box and points, if any
+	mask := focusMask(focus, focusPoints, original, input.Bounds())
+
+	// bgStat=optimize refines the mean against the request's own scoring,
+	// which the focus mask and preserveAlpha change
+	if req.BgStat == "optimize" {
+		t := time.Now()
+		m := getModel(input, bg, workerCount)
+		configureModel(m, req)
+		m.SetWeightMask(mask)
+		bg = m.OptimizeBackground(backgroundIterations)
+		modelPool.Put(m)
+		rl.Printf("⏱️  Background optimized to %v: %v", bg, time.Since(t))
+	}
+	metrics.Background = fmt.Sprintf("#%02x%02x%02x", bg.R, bg.G, bg.B)
 
 	// Create model with performance-based workers
 	t4 := time.Now()
-	
-	// Detect environment and use REAL core estimation
-	var workers int
-	if os.Getenv("RAILWAY_ENVIRONMENT") != "" {
-		// Railway: Use conservative real core count (ignore fake vCPUs)
-		workers = 2 // Railway trial actually has ~2 real cores worth of power
-		log.Printf("Railway detected: Using %d REAL workers (ignoring %d fake vCPUs)", workers, runtime.NumCPU())
-	} else {
-		// Local: Use actual cores but cap for sanity
-		workers = runtime.NumCPU()
-		if workers > 8 {
-			workers = 8 // Cap at 8 for memory efficiency
+
+	workers := workerCount
+	rl.Printf("Using %d workers", workers)
+	metrics.Workers = workers
+
+	rl.Printf("⏱️  Model setup: %v", time.Since(t4))
+
+	phases, err := req.phases()
+	if err != nil {
+		return nil, err
+	}
+	count := totalCount(phases)
+	initial, err := req.initialShapes()
+	if err != nil {
+		return nil, requestError{err}
+	}
+
+	if req.ContactSheet {
+		t5 := time.Now()
+		sheet, err := renderContactSheet(rl, input, bg, mask, workers, req)
+		if err != nil {
+			return nil, err
+		}
+		metrics.Timings.SearchMs = milliseconds(time.Since(t5))
+		t6 := time.Now()
+		var buf bytes.Buffer
+		if req.Format == "png" {
+			result.ContentType = "image/png"
+			err = primitive.EncodePNG(&buf, sheet, req.DPI)
+		} else {
+			result.ContentType = "image/jpeg"
+			err = primitive.EncodeJPEG(&buf, sheet, 95, req.DPI)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode result: %v", err)
 		}
-		log.Printf("Local detected: Using %d workers", workers)
+		metrics.Timings.EncodeMs = milliseconds(time.Since(t6))
+		result.Data = buf.Bytes()
+		metrics.ElapsedMs = milliseconds(time.Since(start))
+		rl.Printf("🎯 TOTAL PROCESSING TIME: %v (contact sheet)", time.Since(start))
+		metrics.Debug = rl.info(bg, workers, 0)
+		results.put(key, result)
+		return result, nil
 	}
-	
-	model := primitive.NewModel(input, bg, 1024, workers)
-	log.Printf("⏱️  Model creation: %v", time.Since(t4))
 
-	// Process shapes as fast as possible
+	// Run each attempt with its own seed and keep the lowest score. Attempts
+	// run sequentially so only two models are alive at once.
+	var model *primitive.Model
 	t5 := time.Now()
-	for i := 0; i < count; i++ {
-		stepStart := time.Now()
-		model.Step(primitive.ShapeType(mode), alpha, 0)
-		if (i+1)%10 == 0 || i == 0 { // Log every 10 steps
-			log.Printf("⏱️  Step %d/%d: %v (total: %v)", i+1, count, time.Since(stepStart), time.Since(t5))
+	for attempt := 0; attempt < req.Attempts; attempt++ {
+		attemptSeed := rand.Int63()
+		modelStart := time.Now()
+		candidate := getModel(input, bg, workers)
+		configureModel(candidate, req)
+		candidate.SetWeightMask(mask)
+		candidate.Seed(attemptSeed)
+		rl.Printf("⏱️  Model acquire: %v", time.Since(modelStart))
+		if initial != nil {
+			if err := candidate.AddShapeList(initial); err != nil {
+				modelPool.Put(candidate)
+				if model != nil {
+					modelPool.Put(model)
+				}
+				return nil, requestError{fmt.Errorf("initialShapes: %v", err)}
+			}
+			rl.Printf("Loaded %d initial shapes", len(initial.Shapes))
 		}
+
+		// Process shapes as fast as possible, one phase after another
+		attemptStart := time.Now()
+		candidate.Phases = phases
+		i := 0
+		for _, phase := range phases {
+			if req.MaxSVGBytes > 0 {
+				// a phase that runs out of budget adds nothing to
+				// the following ones but a rejected step each
+				added := candidate.StepUntilSVGBytes(phase.Type, req.Alpha, req.MaxSVGBytes, phase.Count)
+				i += added
+				rl.Printf("⏱️  Phase of %d/%d shapes within %d SVG bytes (total: %v)", added, phase.Count, req.MaxSVGBytes, time.Since(attemptStart))
+				continue
+			}
+			for j := 0; j < phase.Count && !candidate.CoverageLimitReached(); j++ {
+				stepStart := time.Now()
+				candidate.Step(phase.Type, req.Alpha, 0)
+				i++
+				if i == previewShapes && i < count && req.onPreview != nil {
+					req.onPreview(candidate)
+				}
+				if i%10 == 0 || i == 1 { // Log every 10 steps
+					rl.Printf("⏱️  Step %d/%d: %v (total: %v)", i, count, time.Since(stepStart), time.Since(attemptStart))
+				}
+			}
+		}
+		rl.Printf("⏱️  Algorithm processing (%d shapes, attempt %d/%d): %v, score=%.6f",
+			count, attempt+1, req.Attempts, time.Since(attemptStart), candidate.Score)
+		logWorkerStats(rl, candidate.WorkerStats())
+
+		if model == nil || candidate.Score < model.Score {
+			if model != nil {
+				modelPool.Put(model)
+			}
+			model = candidate
+			metrics.Seed = attemptSeed
+		} else {
+			modelPool.Put(candidate)
+		}
+	}
+	defer modelPool.Put(model)
+	metrics.Timings.SearchMs = milliseconds(time.Since(t5))
+	metrics.Shapes = len(model.Shapes)
+	metrics.ShapeTypes = make(map[string]int)
+	for t, n := range model.ShapeStats() {
+		metrics.ShapeTypes[t.String()] = n
+	}
+	metrics.FinalScore = model.Score
+	metrics.NormalizedScore = model.NormalizedScore()
+	metrics.WorkerEvaluations = model.WorkerStats()
+	metrics.Coverage = model.Coverage()
+	metrics.CoverageCapped = model.CoverageLimitReached()
+	if metrics.CoverageCapped {
+		rl.Printf("Stopped at %d shapes: coverage %.2f passed maxCoverage %g", metrics.Shapes, metrics.Coverage, req.MaxCoverage)
 	}
-	log.Printf("⏱️  Algorithm processing (%d shapes): %v", count, time.Since(t5))
 
-	// Encode result to high-quality JPEG
+	// Size the output, supersampled if requested. Raster encoders render it.
+	encoder, _ := lookupEncoder(req.Format, req.DPI)
 	t6 := time.Now()
+	if req.Native {
+		w, h := original.X, original.Y
+		if w > maxNativeSize || h > maxNativeSize {
+			s := float64(maxNativeSize) / float64(max(w, h))
+			w = max(int(float64(w)*s), 1)
+			h = max(int(float64(h)*s), 1)
+		}
+		for model.RenderScale > 1 && max(w, h)*model.RenderScale > maxRenderSize {
+			model.RenderScale--
+		}
+		model.OutputWidth = w
+		model.OutputHeight = h
+		rl.Printf("⏱️  Native output %dx%d (aa=%d)", w, h, model.RenderScale)
+	}
+	canvasW, canvasH, _ := req.canvasSize()
+	if canvasW > 0 {
+		for model.RenderScale > 1 && max(canvasW, canvasH)*model.RenderScale > maxRenderSize {
+			model.RenderScale--
+		}
+		rl.Printf("⏱️  Letterboxed output %dx%d (aa=%d)", canvasW, canvasH, model.RenderScale)
+	}
+
+	// Render and encode the result. Comparisons are always JPEG, and top-k
+	// renders and working canvases are PNG or JPEG.
 	var buf bytes.Buffer
-	opts := &jpeg.Options{Quality: 95}
-	err = jpeg.Encode(&buf, model.Context.Image(), opts)
+	if encoder != nil {
+		result.ContentType = encoder.ContentType()
+	}
+	switch {
+	case req.Video:
+		result.ContentType = "application/zip"
+		err = writeFramesZip(&buf, model)
+	case req.Layers > 0:
+		result.ContentType = "application/zip"
+		err = writeLayersZip(&buf, model, req.Layers)
+	case req.Compare:
+		result.ContentType = "image/jpeg"
+		err = primitive.EncodeJPEG(&buf, model.ComparisonImage(decoded, compareGap), 95, req.DPI)
+	case req.Format == errorOverlayFormat:
+		// the input shrunk to the output size, which the shapes are
+		// redrawn at
+		w, h := model.Sw, model.Sh
+		if model.OutputWidth > 0 && model.OutputHeight > 0 {
+			w, h = model.OutputWidth, model.OutputHeight
+		}
+		result.ContentType = "image/png"
+		err = primitive.EncodePNG(&buf, model.ErrorOverlay(thumbnail(decoded, max(w, h), "bilinear"), errorOverlayOpacity), req.DPI)
+	case req.TopK > 0 && req.Format == "png":
+		err = primitive.EncodePNG(&buf, model.RenderTopK(req.TopK), req.DPI)
+	case req.TopK > 0:
+		err = primitive.EncodeJPEG(&buf, model.RenderTopK(req.TopK), 95, req.DPI)
+	case req.Working && req.Format == "png":
+		err = primitive.EncodePNG(&buf, model.WorkingImage(), req.DPI)
+	case req.Working:
+		err = primitive.EncodeJPEG(&buf, model.WorkingImage(), 95, req.DPI)
+	case canvasW > 0 && req.Format == "png":
+		err = primitive.EncodePNG(&buf, model.LetterboxTo(canvasW, canvasH), req.DPI)
+	case canvasW > 0:
+		err = primitive.EncodeJPEG(&buf, model.LetterboxTo(canvasW, canvasH), 95, req.DPI)
+	default:
+		err = encoder.Encode(&buf, model)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to encode result: %v", err)
 	}
-	log.Printf("⏱️  JPEG encoding: %v", time.Since(t6))
-	
-	log.Printf("🎯 TOTAL PROCESSING TIME: %v", time.Since(start))
-	return buf.Bytes(), nil
+	metrics.Timings.EncodeMs = milliseconds(time.Since(t6))
+	rl.Printf("⏱️  %s render and encoding: %v", req.Format, time.Since(t6))
+
+	result.Data = buf.Bytes()
+	metrics.ElapsedMs = milliseconds(time.Since(start))
+	rl.Printf("🎯 TOTAL PROCESSING TIME: %v (seed %d, score %.6f)", time.Since(start), metrics.Seed, model.Score)
+	metrics.Debug = rl.info(bg, workers, model.Score)
+	results.put(key, result)
+	return result, nil
+}
+
+// logWorkerStats logs each worker's candidate evaluations and how far the
+// busiest is ahead of the least busy, which shows whether workers starve.
+func logWorkerStats(rl *requestLog, stats []int) {
+	lo, hi := stats[0], stats[0]
+	for _, n := range stats {
+		lo = min(lo, n)
+		hi = max(hi, n)
+	}
+	imbalance := 0.0
+	if lo > 0 {
+		imbalance = float64(hi)/float64(lo) - 1
+	}
+	rl.Printf("Worker evaluations: %v (imbalance %.1f%%)", stats, imbalance*100)
+}
+
+// lookupEncoder returns the registered encoder for format, set to record
+// dpi if it is a raster format.
+func lookupEncoder(format string, dpi int) (primitive.Encoder, bool) {
+	encoder, ok := primitive.LookupEncoder(format)
+	switch e := encoder.(type) {
+	case primitive.JPEGEncoder:
+		e.DPI = dpi
+		encoder = e
+	case primitive.PNGEncoder:
+		e.DPI = dpi
+		encoder = e
+	}
+	return encoder, ok
+}
+
+// results caches finished results so repeated uploads skip the search. It
+// is nil when caching is off.
+var results *resultCache
+
+// jobs holds the renders started with POST /api/jobs.
+var jobs *jobRegistry
+
+// renders bounds the renders in flight, including running jobs. It is nil
+// when the limit is off.
+var renders *renderLimiter
+
+// workerCount is the number of search workers per request. It is measured
+// once at startup, since vCPU counts on shared hosts overstate the real
+// parallelism available.
+var workerCount int
+
+// maxWorkers caps workerCount for memory efficiency.
+const maxWorkers = 8
+
+func chooseWorkerCount() int {
+	t := time.Now()
+	workers := primitive.AutoWorkerCount(benchmarkSample())
+	if workers > maxWorkers {
+		workers = maxWorkers
+	}
+	log.Printf("Benchmarked %d workers as fastest of %d CPUs in %v", workers, runtime.NumCPU(), time.Since(t))
+	return workers
+}
+
+// benchmarkSample is a synthetic image with smooth gradients and hard edges,
+// so the benchmark sees a realistic mix of shape sizes.
+func benchmarkSample() image.Image {
+	im := image.NewRGBA(image.Rect(0, 0, 64, 64))
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 64; x++ {
+			v := uint8(x * 4)
+			if (x/16+y/16)%2 == 0 {
+				v = 255 - uint8(y*4)
+			}
+			im.Set(x, y, color.NRGBA{v, uint8(y * 4), 255 - v, 255})
+		}
+	}
+	return im
+}
+
+// estimateETA predicts the search time for a request from the image header,
+// without decoding the pixels. It returns zero if the header is unreadable.
+func estimateETA(config image.Config, req ProcessRequest) time.Duration {
+	if config.Width == 0 || config.Height == 0 {
+		return 0
+	}
+	size := min(max(config.Width, config.Height), req.workingSize())
+	if req.Count <= 0 {
+		// the suggested count needs the pixels, so guess a typical one
+		req.Count = etaShapeCount
+	}
+	phases, err := req.phases()
+	if err != nil {
+		return 0
+	}
+	var eta time.Duration
+	for _, phase := range phases {
+		eta += primitive.EstimateDuration(size, phase.Count, workerCount, phase.Type)
+	}
+	return eta * time.Duration(req.Attempts)
 }
 
 func main() {
+	workerCount = chooseWorkerCount()
+	results = resultCacheFromEnv()
+	jobs = jobRegistryFromEnv()
+	debugAllowed = envInt("ALLOW_DEBUG", 0) == 1
+	// calibrate the estimate for the default mode now rather than during
+	// the first request
+	primitive.EstimateDuration(inputSize, 1, workerCount, primitive.ShapeType(defaultProcessRequest().Mode))
+
 	// Set Gin mode for production
 	if os.Getenv("RAILWAY_ENVIRONMENT") != "" {
 		gin.SetMode(gin.ReleaseMode)
 	}
 
 	r := gin.Default()
+	if err := r.SetTrustedProxies(trustedProxiesFromEnv()); err != nil {
+		log.Fatalf("Invalid TRUSTED_PROXIES: %v", err)
+	}
 
 	// CORS middleware for development
 	r.Use(func(c *gin.Context) {
 		c.Header("Access-Control-Allow-Origin", "*")
 		c.Header("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
 		c.Header("Access-Control-Allow-Headers", "Content-Type")
-		
+
 		if c.Request.Method == "OPTIONS" {
 			c.AbortWithStatus(204)
 			return
 		}
-		
+
 		c.Next()
 	})
 
@@ -125,8 +856,33 @@ func main() {
 	r.StaticFile("/", "./static/index.html")
 	r.Static("/static", "./static")
 
-	// Single API endpoint - upload and process in one shot
-	r.POST("/api/process", handleProcessImage)
+	// Upload and process in one shot, or re-render a JSON result. Only the
+	// API routes are rate limited, so health checks always get through.
+	// Rate limited requests do not take a render slot.
+	api := r.Group("/api")
+	if limiter := rateLimiterFromEnv(); limiter != nil {
+		api.Use(limiter.middleware())
+	}
+	// A job holds a render slot while it runs rather than while the
+	// request that starts it does, so it is routed before the middleware
+	// that would take a second one.
+	api.POST("/jobs", handleStartJob)
+	renders = renderLimiterFromEnv()
+	if renders != nil {
+		api.Use(renders.middleware())
+	}
+	api.POST("/process", handleProcessImage)
+	api.POST("/render", handleRender)
+	api.POST("/compare", handleCompare)
+	api.POST("/stream", handleStream)
+
+	// Commands and polling for a running job are cheap and come often, so
+	// they are not rate limited. Only starting a job is.
+	job := r.Group("/api/jobs/:id")
+	job.GET("", handleJobStatus)
+	job.POST("/pause", handlePauseJob)
+	job.POST("/resume", handleResumeJob)
+	job.GET("/current", handleJobCurrent)
 
 	// Get port from environment or default to 8081
 	port := os.Getenv("PORT")
@@ -135,18 +891,89 @@ func main() {
 	}
 
 	log.Printf("Server starting on port %s", port)
-	r.Run(":" + port)
+	serve(r, ":"+port)
 }
 
-func handleProcessImage(c *gin.Context) {
-	log.Printf("Received process request from %s", c.ClientIP())
-	
+func defaultProcessRequest() ProcessRequest {
+	return ProcessRequest{
+		Count:    0,   // suggested from the image
+		Mode:     1,   // triangles default
+		Alpha:    128, // default
+		Attempts: 1,
+		AA:       1,
+		Format:   "jpeg",
+		BgStat:   "mean",
+		Orient:   "auto",
+		Resample: "bilinear",
+		Detail:   inputSize,
+		DPI:      defaultDPI,
+
+		BorderColor:   "#ffffff",
+		DebugColors:   "none",
+		HalftoneAngle: 45,
+	}
+}
+
+// formInt overwrites *value with the named form field when it is present and
+// parses as an integer.
+func formInt(c *gin.Context, name string, value *int) {
+	if str := c.PostForm(name); str != "" {
+		if n, err := strconv.Atoi(str); err == nil {
+			*value = n
+		}
+	}
+}
+
+// formFloat is formInt for a number that may have a fraction.
+func formFloat(c *gin.Context, name string, value *float64) {
+	if str := c.PostForm(name); str != "" {
+		if f, err := strconv.ParseFloat(str, 64); err == nil {
+			*value = f
+		}
+	}
+}
+
+// isHexColor reports whether s is a color primitive.MakeHexColor reads: 3,
+// 4, 6 or 8 hex digits, optionally after a #.
+func isHexColor(s string) bool {
+	s = strings.TrimPrefix(s, "#")
+	switch len(s) {
+	case 3, 4, 6, 8:
+	default:
+		return false
+	}
+	_, err := strconv.ParseUint(s, 16, 32)
+	return err == nil
+}
+
+// parseInts parses a comma separated list of integers. It returns an empty,
+// non-nil slice if any element is malformed so that validation rejects it.
+func parseInts(str string) []int {
+	parts := strings.Split(str, ",")
+	result := make([]int, 0, len(parts))
+	for _, part := range parts {
+		n, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil {
+			return []int{}
+		}
+		result = append(result, n)
+	}
+	return result
+}
+
+// readMultipartRequest reads the parameters from a multipart form and opens
+// the uploaded file, which the caller must close. On failure it writes the
+// error response and returns false.
+func readMultipartRequest(c *gin.Context) (io.ReadSeekCloser, ProcessRequest, bool) {
+	req := defaultProcessRequest()
+
 	// Parse multipart form
-	err := c.Request.ParseMultipartForm(32 << 20) // 32MB max
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxUploadSize+multipartOverhead)
+	err := c.Request.ParseMultipartForm(maxMultipartMemory)
 	if err != nil {
 		log.Printf("Failed to parse multipart form: %v", err)
 		c.JSON(400, gin.H{"error": "Failed to parse form"})
-		return
+		return nil, req, false
 	}
 
 	// Get file
@@ -154,53 +981,353 @@ func handleProcessImage(c *gin.Context) {
 	if err != nil {
 		log.Printf("Failed to get file from form: %v", err)
 		c.JSON(400, gin.H{"error": "No file uploaded"})
-		return
+		return nil, req, false
+	}
+	if header.Size > maxUploadSize {
+		file.Close()
+		c.JSON(400, gin.H{"error": fmt.Sprintf("image exceeds %d bytes", maxUploadSize)})
+		return nil, req, false
 	}
-	defer file.Close()
-	
+
 	log.Printf("Received file: %s (%d bytes)", header.Filename, header.Size)
 
-	// Read file into memory
-	fileData, err := io.ReadAll(file)
+	// Parse parameters from form data
+	formInt(c, "count", &req.Count)
+	formInt(c, "mode", &req.Mode)
+	req.Phases = c.PostForm("phases")
+	formInt(c, "alpha", &req.Alpha)
+	formInt(c, "attempts", &req.Attempts)
+	formInt(c, "aa", &req.AA)
+	formInt(c, "colors", &req.Colors)
+	formInt(c, "detail", &req.Detail)
+	req.NoResize = c.PostForm("noresize") == "1"
+	formInt(c, "dpi", &req.DPI)
+	formInt(c, "topk", &req.TopK)
+	formInt(c, "thumb", &req.Thumb)
+	formInt(c, "layers", &req.Layers)
+	formInt(c, "maxSvgBytes", &req.MaxSVGBytes)
+	formFloat(c, "maxCoverage", &req.MaxCoverage)
+	formFloat(c, "margin", &req.Margin)
+	formFloat(c, "sharpen", &req.Sharpen)
+	formInt(c, "bgAlpha", &req.BgAlpha)
+	formInt(c, "border", &req.Border)
+	if borderColor := c.PostForm("borderColor"); borderColor != "" {
+		req.BorderColor = borderColor
+	}
+	if canvas := c.PostForm("canvas"); canvas != "" {
+		req.Canvas = canvas
+	}
+	req.FixedColor = c.PostForm("fixedColor")
+	req.Wireframe = c.PostForm("wireframe") == "1"
+	req.WireframeColor = c.PostForm("wireframeColor")
+	formInt(c, "halftone", &req.Halftone)
+	formFloat(c, "halftoneAngle", &req.HalftoneAngle)
+	// debugColors was the name before debug_colors, and still works
+	for _, name := range []string{"debugColors", "debug_colors"} {
+		if debugColors := c.PostForm(name); debugColors != "" {
+			req.DebugColors = debugColors
+		}
+	}
+	if orient := c.PostForm("orient"); orient != "" {
+		req.Orient = orient
+	}
+	if resample := c.PostForm("resample"); resample != "" {
+		req.Resample = resample
+	}
+	if bgStat := c.PostForm("bgStat"); bgStat != "" {
+		req.BgStat = bgStat
+	}
+	if format := c.PostForm("format"); format != "" {
+		req.Format = format
+	}
+	req.Metrics = c.PostForm("metrics") == "1"
+	req.Native = c.PostForm("native") == "1"
+	req.Compare = c.PostForm("compare") == "1"
+	req.Working = c.PostForm("working") == "1"
+	req.DataURI = c.PostForm("datauri") == "1"
+	req.Video = c.PostForm("video") == "1"
+	req.ContactSheet = c.PostForm("contactsheet") == "1"
+	req.PreserveAlpha = c.PostForm("preserveAlpha") == "1"
+	req.Debug = c.PostForm("debug") == "1"
+	if focusStr := c.PostForm("focus"); focusStr != "" {
+		req.Focus = parseInts(focusStr)
+	}
+	if pointsStr := c.PostForm("focusPoints"); pointsStr != "" {
+		req.FocusPoints = parseInts(pointsStr)
+	}
+	if shapes := c.PostForm("initialShapes"); shapes != "" {
+		req.InitialShapes = json.RawMessage(shapes)
+	}
+	return file, req, true
+}
+
+// validateRequest checks parameter ranges. On failure it writes the error
+// response and returns false.
+func validateRequest(c *gin.Context, req ProcessRequest) bool {
+	if req.Attempts < 1 || req.Attempts > maxAttempts {
+		c.JSON(400, gin.H{"error": fmt.Sprintf("attempts must be between 1 and %d", maxAttempts)})
+		return false
+	}
+	if req.AA < 1 || req.AA > maxAA {
+		c.JSON(400, gin.H{"error": fmt.Sprintf("aa must be between 1 and %d", maxAA)})
+		return false
+	}
+	if req.Colors != 0 && (req.Colors < 2 || req.Colors > maxColors) {
+		c.JSON(400, gin.H{"error": fmt.Sprintf("colors must be 0 or between 2 and %d", maxColors)})
+		return false
+	}
+	if _, err := req.phases(); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return false
+	}
+	if req.TopK < 0 {
+		c.JSON(400, gin.H{"error": "topk must not be negative"})
+		return false
+	}
+	if req.Video && (req.Compare || req.TopK > 0) {
+		c.JSON(400, gin.H{"error": "video cannot be combined with compare or topk"})
+		return false
+	}
+	if req.Layers < 0 {
+		c.JSON(400, gin.H{"error": "layers must not be negative"})
+		return false
+	}
+	if req.Layers > 0 && (req.Video || req.Compare || req.TopK > 0) {
+		c.JSON(400, gin.H{"error": "layers cannot be combined with video, compare or topk"})
+		return false
+	}
+	if req.Thumb < 0 || req.Thumb > maxThumbSize {
+		c.JSON(400, gin.H{"error": fmt.Sprintf("thumb must be between 0 and %d", maxThumbSize)})
+		return false
+	}
+	if req.Thumb > 0 && (req.Video || req.Layers > 0 || req.Metrics || !slices.Contains([]string{"jpeg", "jpg", "png", errorOverlayFormat}, req.Format)) {
+		c.JSON(400, gin.H{"error": "thumb needs format jpeg, png or error-overlay and cannot be combined with video, layers or metrics"})
+		return false
+	}
+	if req.DataURI && req.Metrics {
+		c.JSON(400, gin.H{"error": "datauri cannot be combined with metrics"})
+		return false
+	}
+	if req.Debug && !debugAllowed {
+		c.JSON(400, gin.H{"error": "debug is not enabled on this server"})
+		return false
+	}
+	initial, err := req.initialShapes()
 	if err != nil {
-		c.JSON(500, gin.H{"error": "Failed to read file"})
-		return
+		c.JSON(400, gin.H{"error": err.Error()})
+		return false
+	}
+	if req.ContactSheet && (req.Compare || req.Video || req.TopK > 0 || req.Layers > 0 || req.Phases != "" || initial != nil) {
+		c.JSON(400, gin.H{"error": "contactsheet cannot be combined with compare, video, topk, layers, phases or initialShapes"})
+		return false
+	}
+	if req.ContactSheet && !slices.Contains([]string{"jpeg", "jpg", "png"}, req.Format) {
+		c.JSON(400, gin.H{"error": "contactsheet needs format jpeg or png"})
+		return false
+	}
+	if req.Working && (!slices.Contains([]string{"jpeg", "jpg", "png"}, req.Format) || req.Native || req.Canvas != "" || req.Border > 0 ||
+		req.Compare || req.Video || req.Layers > 0 || req.TopK > 0 || req.ContactSheet) {
+		c.JSON(400, gin.H{"error": "working needs format jpeg or png and cannot be combined with native, canvas, border, compare, video, layers, topk or contactsheet"})
+		return false
+	}
+	if req.TopK > 0 && !slices.Contains([]string{"jpeg", "jpg", "png"}, req.Format) {
+		c.JSON(400, gin.H{"error": "topk needs format jpeg or png"})
+		return false
+	}
+	if req.MaxSVGBytes < 0 {
+		c.JSON(400, gin.H{"error": "maxSvgBytes must not be negative"})
+		return false
+	}
+	if req.MaxSVGBytes > 0 && (req.Format != "svg" || req.Compare || req.Video || req.Layers > 0 || req.ContactSheet) {
+		c.JSON(400, gin.H{"error": "maxSvgBytes needs format svg and cannot be combined with compare, video, layers or contactsheet"})
+		return false
+	}
+	if req.MaxCoverage < 0 || math.IsNaN(req.MaxCoverage) || math.IsInf(req.MaxCoverage, 0) {
+		c.JSON(400, gin.H{"error": "maxCoverage must be a non-negative number"})
+		return false
+	}
+	if !(req.Margin >= 0 && req.Margin < 0.5) {
+		c.JSON(400, gin.H{"error": "margin must be at least 0 and below 0.5"})
+		return false
+	}
+	if !(req.Sharpen >= 0 && req.Sharpen <= 5) {
+		c.JSON(400, gin.H{"error": "sharpen must be between 0 and 5"})
+		return false
+	}
+	if _, ok := debugColorModes[req.DebugColors]; !ok {
+		c.JSON(400, gin.H{"error": "debug_colors must be none, index or type"})
+		return false
+	}
+	if req.Border < 0 || req.Border > maxBorder {
+		c.JSON(400, gin.H{"error": fmt.Sprintf("border must be between 0 and %d", maxBorder)})
+		return false
+	}
+	if !isHexColor(req.BorderColor) {
+		c.JSON(400, gin.H{"error": "borderColor must be a hex color such as #ffffff"})
+		return false
+	}
+	if req.Border > 0 && (req.Video || req.Layers > 0 || req.ContactSheet || req.Format == "json" || req.Format == "lottie" || req.Format == "ascii" || req.Format == "geojson" || req.Format == errorOverlayFormat) {
+		c.JSON(400, gin.H{"error": "border cannot be combined with video, layers, contactsheet or format json, lottie, ascii, geojson or error-overlay"})
+		return false
+	}
+	if _, _, err := req.canvasSize(); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return false
+	}
+	if req.Canvas != "" && (req.Native || req.Compare || req.Video || req.Layers > 0 || req.TopK > 0 || req.ContactSheet || !slices.Contains([]string{"jpeg", "jpg", "png"}, req.Format)) {
+		c.JSON(400, gin.H{"error": "canvas needs format jpeg or png and cannot be combined with native, compare, video, layers, topk or contactsheet"})
+		return false
+	}
+	if req.FixedColor != "" && !isHexColor(req.FixedColor) {
+		c.JSON(400, gin.H{"error": "fixedColor must be a hex color such as #000000"})
+		return false
+	}
+	if req.WireframeColor != "" && !isHexColor(req.WireframeColor) {
+		c.JSON(400, gin.H{"error": "wireframeColor must be a hex color such as #000000"})
+		return false
+	}
+	if req.Halftone < 0 || req.Halftone > 64 {
+		c.JSON(400, gin.H{"error": "halftone must be between 0 and 64"})
+		return false
+	}
+	if math.IsNaN(req.HalftoneAngle) || math.IsInf(req.HalftoneAngle, 0) {
+		c.JSON(400, gin.H{"error": "halftoneAngle must be a number of degrees"})
+		return false
+	}
+	if req.BgAlpha < 0 || req.BgAlpha > 255 {
+		c.JSON(400, gin.H{"error": "bgAlpha must be between 0 and 255"})
+		return false
+	}
+	if req.BgAlpha > 0 && (!req.PreserveAlpha || req.Format != "png") {
+		c.JSON(400, gin.H{"error": "bgAlpha needs preserveAlpha and format png"})
+		return false
+	}
+	if req.DPI < 1 || req.DPI > maxDPI {
+		c.JSON(400, gin.H{"error": fmt.Sprintf("dpi must be between 1 and %d", maxDPI)})
+		return false
+	}
+	if !slices.Contains(detailSizes, req.Detail) {
+		c.JSON(400, gin.H{"error": fmt.Sprintf("detail must be one of %v", detailSizes)})
+		return false
+	}
+	if !slices.Contains(orientations, req.Orient) {
+		c.JSON(400, gin.H{"error": "orient must be auto, landscape or portrait"})
+		return false
+	}
+	if req.Resample != "bilinear" && req.Resample != "area" {
+		c.JSON(400, gin.H{"error": "resample must be bilinear or area"})
+		return false
+	}
+	if req.BgStat != "mean" && req.BgStat != "median" && req.BgStat != "corners" && req.BgStat != "optimize" {
+		c.JSON(400, gin.H{"error": "bgStat must be mean, median, corners or optimize"})
+		return false
+	}
+	if _, ok := primitive.LookupEncoder(req.Format); !ok && req.Format != errorOverlayFormat {
+		c.JSON(400, gin.H{"error": fmt.Sprintf("format must be one of %s", strings.Join(append(primitive.EncoderNames(), errorOverlayFormat), ", "))})
+		return false
 	}
+	if req.Focus != nil && (len(req.Focus) != 4 || req.Focus[2] <= 0 || req.Focus[3] <= 0) {
+		c.JSON(400, gin.H{"error": "focus must be x,y,w,h with a positive width and height"})
+		return false
+	}
+	if req.FocusPoints != nil && (len(req.FocusPoints) == 0 || len(req.FocusPoints)%2 != 0 || len(req.FocusPoints) > maxFocusPoints*2) {
+		c.JSON(400, gin.H{"error": fmt.Sprintf("focusPoints must be x,y pairs, at most %d of them", maxFocusPoints)})
+		return false
+	}
+	return true
+}
 
-	// Parse parameters from form data
-	req := ProcessRequest{
-		Count: 100, // default
-		Mode:  1,   // triangles default
-		Alpha: 128, // default
+func handleProcessImage(c *gin.Context) {
+	log.Printf("Received process request from %s", c.ClientIP())
+
+	var upload io.ReadSeekCloser
+	var req ProcessRequest
+	var ok bool
+	if c.ContentType() == "application/json" {
+		upload, req, ok = readJSONRequest(c)
+	} else {
+		upload, req, ok = readMultipartRequest(c)
+	}
+	if !ok {
+		return
+	}
+	defer upload.Close()
+	if !validateRequest(c, req) {
+		return
 	}
 
-	if countStr := c.PostForm("count"); countStr != "" {
-		if count, err := strconv.Atoi(countStr); err == nil {
-			req.Count = count
-		}
+	log.Printf("Processing image: count=%d, mode=%d, alpha=%d, attempts=%d, aa=%d", req.Count, req.Mode, req.Alpha, req.Attempts, req.AA)
+
+	config, ok := checkFormat(c, upload)
+	if !ok {
+		return
 	}
-	if modeStr := c.PostForm("mode"); modeStr != "" {
-		if mode, err := strconv.Atoi(modeStr); err == nil {
-			req.Mode = mode
-		}
+
+	// a client that already has this result gets a 304 without a search
+	etag, err := requestETag(upload, req)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to read image"})
+		return
 	}
-	if alphaStr := c.PostForm("alpha"); alphaStr != "" {
-		if alpha, err := strconv.Atoi(alphaStr); err == nil {
-			req.Alpha = alpha
-		}
+	if match := c.GetHeader("If-None-Match"); match != "" && etagMatches(match, etag) {
+		log.Printf("ETag matches, returning 304")
+		c.Header("ETag", etag)
+		c.Status(304)
+		return
 	}
 
-	log.Printf("Processing image: count=%d, mode=%d, alpha=%d", req.Count, req.Mode, req.Alpha)
+	eta := estimateETA(config, req)
+	c.Header("X-Primitive-ETA", strconv.FormatInt(eta.Milliseconds(), 10))
 
 	// Process image synchronously - no jobs, no WebSockets, just pure speed
-	resultData, err := processImageSync(fileData, req.Count, req.Mode, req.Alpha)
+	result, err := processImageSync(upload, req)
+	var reqErr requestError
+	if errors.As(err, &reqErr) {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
 	if err != nil {
 		c.JSON(500, gin.H{"error": err.Error()})
 		return
 	}
 
-	log.Printf("Processing complete, returning image (%d bytes)", len(resultData))
+	c.Header("ETag", etag)
+	c.Header("X-Primitive-Seed", strconv.FormatInt(result.Metrics.Seed, 10))
+	if result.Metrics.CoverageCapped {
+		c.Header("X-Primitive-Coverage-Capped", "1")
+	}
+
+	// Return only the stats when metrics are requested
+	if req.Metrics {
+		log.Printf("Processing complete, returning metrics")
+		c.JSON(200, result.Metrics)
+		return
+	}
+
+	log.Printf("Processing complete, returning image (%d bytes)", len(result.Data))
+	// metrics=1 returns the debug output in its JSON; with an image it goes
+	// in a header, base64 encoded as the log lines are not all ASCII
+	if debug := result.Metrics.Debug; debug != nil {
+		c.Header("X-Primitive-Debug", debug.header())
+	}
+
+	if req.Thumb > 0 {
+		body, err := thumbEnvelope(result, req.Thumb, req.DataURI)
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(200, body)
+		return
+	}
+
+	if req.DataURI {
+		// media types in data URIs take no spaces before their parameters
+		mediaType := strings.ReplaceAll(result.ContentType, " ", "")
+		c.JSON(200, gin.H{"dataUri": "data:" + mediaType + ";base64," + base64.StdEncoding.EncodeToString(result.Data)})
+		return
+	}
 
 	// Return the processed image directly
-	c.Data(200, "image/jpeg", resultData)
+	c.Data(200, result.ContentType, result.Data)
 }
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+)
+
+// Mask levels for the focus box and points. Pixels inside the box or at a
+// point count four times as much toward the error as those elsewhere.
+const (
+	focusInside  = 255
+	focusOutside = 64
+)
+
+// focusPointSigma is the standard deviation of the falloff around a focus
+// point, as a fraction of the working image's longer side.
+const focusPointSigma = 0.08
+
+// maxFocusPoints bounds the focusPoints of a request.
+const maxFocusPoints = 32
+
+// focusMask builds a weight mask at the size of the working image from a
+// focus box and focus points, given in original input pixels. Each point
+// raises the weight with a gaussian falloff, and where the box and points
+// overlap the highest weight wins. It returns nil when there is neither box
+// nor points, or the box misses the image and there are no points.
+func focusMask(focus, points []int, original image.Point, working image.Rectangle) image.Image {
+	sx := float64(working.Dx()) / float64(original.X)
+	sy := float64(working.Dy()) / float64(original.Y)
+	bounds := image.Rect(0, 0, working.Dx(), working.Dy())
+	var r image.Rectangle
+	if len(focus) == 4 {
+		r = image.Rect(
+			int(float64(focus[0])*sx), int(float64(focus[1])*sy),
+			int(float64(focus[0]+focus[2])*sx+0.5), int(float64(focus[1]+focus[3])*sy+0.5))
+		r = r.Intersect(bounds)
+	}
+	if r.Empty() && len(points) < 2 {
+		return nil
+	}
+	mask := image.NewGray(bounds)
+	draw.Draw(mask, bounds, &image.Uniform{color.Gray{focusOutside}}, image.Point{}, draw.Src)
+	draw.Draw(mask, r, &image.Uniform{color.Gray{focusInside}}, image.Point{}, draw.Src)
+
+	sigma := focusPointSigma * float64(max(bounds.Dx(), bounds.Dy()))
+	for i := 0; i+1 < len(points); i += 2 {
+		px := (float64(points[i]) + 0.5) * sx
+		py := (float64(points[i+1]) + 0.5) * sy
+		for y := 0; y < bounds.Dy(); y++ {
+			for x := 0; x < bounds.Dx(); x++ {
+				dx, dy := float64(x)+0.5-px, float64(y)+0.5-py
+				g := math.Exp(-(dx*dx + dy*dy) / (2 * sigma * sigma))
+				v := uint8(focusOutside + (focusInside-focusOutside)*g + 0.5)
+				j := y*mask.Stride + x
+				mask.Pix[j] = max(mask.Pix[j], v)
+			}
+		}
+	}
+	return mask
+}
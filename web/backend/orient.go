@@ -0,0 +1,54 @@
+package main
+
+import (
+	"image"
+	"image/draw"
+)
+
+// orientations are the values of the orient param. auto keeps the input as
+// it is; the others turn it a quarter turn clockwise when its orientation
+// is the other one. Square inputs are never turned.
+var orientations = []string{"auto", "landscape", "portrait"}
+
+// needsTurn reports whether an image of the given size must be turned to
+// have orientation orient.
+func needsTurn(orient string, size image.Point) bool {
+	switch orient {
+	case "landscape":
+		return size.Y > size.X
+	case "portrait":
+		return size.X > size.Y
+	}
+	return false
+}
+
+// turnClockwise returns im turned a quarter turn clockwise.
+func turnClockwise(im image.Image) image.Image {
+	b := im.Bounds()
+	src := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(src, src.Rect, im, b.Min, draw.Src)
+	w, h := b.Dx(), b.Dy()
+	dst := image.NewNRGBA(image.Rect(0, 0, h, w))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			i := src.PixOffset(x, y)
+			j := dst.PixOffset(h-1-y, x)
+			copy(dst.Pix[j:j+4], src.Pix[i:i+4])
+		}
+	}
+	return dst
+}
+
+// turnFocus maps a focus box and focus points, in pixels of an input h
+// pixels tall, to where turnClockwise puts them.
+func turnFocus(focus, points []int, h int) ([]int, []int) {
+	var f, p []int
+	if len(focus) == 4 {
+		x, y, fw, fh := focus[0], focus[1], focus[2], focus[3]
+		f = []int{h - y - fh, x, fh, fw}
+	}
+	for i := 0; i+1 < len(points); i += 2 {
+		p = append(p, h-1-points[i+1], points[i])
+	}
+	return f, p
+}
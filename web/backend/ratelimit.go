@@ -0,0 +1,135 @@
+package main
+
+import (
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Defaults for the per-client rate limit. RATE_LIMIT_PER_MINUTE and
+// RATE_LIMIT_BURST override them; a rate of 0 turns limiting off.
+const (
+	defaultRatePerMinute = 10
+	defaultRateBurst     = 5
+)
+
+// idleBucketAge is how long a client must be idle before its bucket is
+// forgotten. A full bucket carries no state, so this only bounds memory.
+const idleBucketAge = 10 * time.Minute
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter is a token bucket per client IP. Each client may make burst
+// requests at once and then rate per second.
+type rateLimiter struct {
+	mu        sync.Mutex
+	rate      float64
+	burst     float64
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+func newRateLimiter(perMinute, burst int) *rateLimiter {
+	return &rateLimiter{
+		rate:    float64(perMinute) / 60,
+		burst:   float64(max(burst, 1)),
+		buckets: make(map[string]*tokenBucket),
+	}
+}
+
+// rateLimiterFromEnv builds the limiter from the environment. It returns nil
+// when limiting is turned off.
+func rateLimiterFromEnv() *rateLimiter {
+	perMinute := envInt("RATE_LIMIT_PER_MINUTE", defaultRatePerMinute)
+	burst := envInt("RATE_LIMIT_BURST", defaultRateBurst)
+	if perMinute <= 0 {
+		log.Printf("Rate limiting disabled")
+		return nil
+	}
+	log.Printf("Rate limiting to %d requests per minute per client (burst %d)", perMinute, burst)
+	return newRateLimiter(perMinute, burst)
+}
+
+// trustedProxiesFromEnv returns the proxies whose X-Forwarded-For and
+// X-Real-IP headers gin may take the client's IP from, as TRUSTED_PROXIES
+// lists them: comma separated IPs or CIDR ranges. Without it none are
+// trusted and the client is the address that connected, so clients cannot
+// pick their own rate limit key by sending those headers.
+func trustedProxiesFromEnv() []string {
+	var proxies []string
+	for _, p := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			proxies = append(proxies, p)
+		}
+	}
+	if len(proxies) == 0 {
+		log.Printf("Trusting no proxies, clients are keyed by their own address")
+	} else {
+		log.Printf("Trusting forwarded client addresses from %s", strings.Join(proxies, ", "))
+	}
+	return proxies
+}
+
+func envInt(name string, fallback int) int {
+	if str := os.Getenv(name); str != "" {
+		if n, err := strconv.Atoi(str); err == nil {
+			return n
+		}
+		log.Printf("Ignoring invalid %s=%q", name, str)
+	}
+	return fallback
+}
+
+// allow takes a token for key. When none is left it returns false and how
+// long until one will be.
+func (l *rateLimiter) allow(key string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.lastSweep) > idleBucketAge {
+		for k, b := range l.buckets {
+			if now.Sub(b.last) > idleBucketAge {
+				delete(l.buckets, k)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	wait := time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	return false, wait
+}
+
+// middleware rejects requests over the limit with 429 and a Retry-After
+// header in whole seconds.
+func (l *rateLimiter) middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ok, wait := l.allow(c.ClientIP(), time.Now())
+		if !ok {
+			seconds := int(math.Ceil(wait.Seconds()))
+			c.Header("Retry-After", strconv.Itoa(seconds))
+			c.AbortWithStatusJSON(429, gin.H{"error": "Too many requests"})
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRateLimiterRefills(t *testing.T) {
+	l := newRateLimiter(60, 2)
+	now := time.Now()
+	for i := 0; i < 2; i++ {
+		if ok, _ := l.allow("a", now); !ok {
+			t.Fatalf("request %d within the burst was refused", i+1)
+		}
+	}
+	ok, wait := l.allow("a", now)
+	if ok {
+		t.Fatal("request past the burst was allowed")
+	}
+	if wait <= 0 || wait > time.Second {
+		t.Fatalf("wait = %v, want up to a second at 60 per minute", wait)
+	}
+	if ok, _ := l.allow("b", now); !ok {
+		t.Fatal("another client was refused")
+	}
+	if ok, _ := l.allow("a", now.Add(wait)); !ok {
+		t.Fatal("request after the wait was refused")
+	}
+}
+
+// limitedRouter serves /health unlimited and /api/ping behind the
+// limiter, as main does, trusting no proxies.
+func limitedRouter(l *rateLimiter) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	if err := r.SetTrustedProxies(nil); err != nil {
+		panic(err)
+	}
+	r.GET("/health", func(c *gin.Context) { c.Status(200) })
+	api := r.Group("/api")
+	api.Use(l.middleware())
+	api.GET("/ping", func(c *gin.Context) { c.Status(200) })
+	return r
+}
+
+func TestRateLimiterMiddleware(t *testing.T) {
+	r := limitedRouter(newRateLimiter(1, 3))
+	get := func(path, forwardedFor string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.RemoteAddr = "203.0.113.7:1234"
+		if forwardedFor != "" {
+			req.Header.Set("X-Forwarded-For", forwardedFor)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	for i := 0; i < 3; i++ {
+		if w := get("/api/ping", ""); w.Code != 200 {
+			t.Fatalf("request %d within the burst: status %d", i+1, w.Code)
+		}
+	}
+	w := get("/api/ping", "")
+	if w.Code != 429 {
+		t.Fatalf("request past the burst: status %d, want 429", w.Code)
+	}
+	if retry := w.Header().Get("Retry-After"); retry != "60" {
+		t.Fatalf("Retry-After = %q, want 60 at one per minute", retry)
+	}
+
+	// a forged X-Forwarded-For does not give the client a fresh bucket
+	if w := get("/api/ping", "198.51.100.1"); w.Code != 429 {
+		t.Fatalf("request with a forged X-Forwarded-For: status %d, want 429", w.Code)
+	}
+
+	if w := get("/health", ""); w.Code != 200 {
+		t.Fatalf("/health over the limit: status %d, want 200", w.Code)
+	}
+}
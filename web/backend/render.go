@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"io"
+	"log"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/fogleman/primitive/primitive"
+)
+
+// RenderRequest holds the options of POST /api/render. They sit alongside
+// the shape list's own fields in the body, so a format=json result can be
+// posted back with only the options added.
+type RenderRequest struct {
+	// Size is the output's longer side. The aspect ratio is the shape
+	// list's.
+	Size   int    `json:"size"`
+	Format string `json:"format"`
+	DPI    int    `json:"dpi"`
+}
+
+// maxRenderShapes bounds the shapes in a render request. Searches never
+// produce nearly this many, so larger lists are not exports.
+const maxRenderShapes = 10000
+
+// handleRender redraws a shape list exported with format=json, without
+// searching, so clients can keep the small JSON and render it at any size.
+func handleRender(c *gin.Context) {
+	data, err := io.ReadAll(io.LimitReader(c.Request.Body, maxUploadSize+1))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Failed to read body"})
+		return
+	}
+	if len(data) > maxUploadSize {
+		c.JSON(400, gin.H{"error": fmt.Sprintf("body exceeds %d bytes", maxUploadSize)})
+		return
+	}
+
+	req := RenderRequest{Size: 1024, Format: "jpeg", DPI: defaultDPI}
+	if err := json.Unmarshal(data, &req); err != nil {
+		c.JSON(400, gin.H{"error": "Failed to parse JSON body"})
+		return
+	}
+	if req.Size < 1 || req.Size > maxNativeSize {
+		c.JSON(400, gin.H{"error": fmt.Sprintf("size must be between 1 and %d", maxNativeSize)})
+		return
+	}
+	if req.DPI < 1 || req.DPI > maxDPI {
+		c.JSON(400, gin.H{"error": fmt.Sprintf("dpi must be between 1 and %d", maxDPI)})
+		return
+	}
+	encoder, ok := lookupEncoder(req.Format, req.DPI)
+	if !ok {
+		c.JSON(400, gin.H{"error": fmt.Sprintf("format must be one of %s", strings.Join(primitive.EncoderNames(), ", "))})
+		return
+	}
+
+	list, err := primitive.UnmarshalShapes(data)
+	if err != nil {
+		c.JSON(400, gin.H{"error": fmt.Sprintf("Invalid shape list: %v", err)})
+		return
+	}
+	if len(list.Shapes) > maxRenderShapes {
+		c.JSON(400, gin.H{"error": fmt.Sprintf("at most %d shapes can be rendered", maxRenderShapes)})
+		return
+	}
+	if list.Width > maxNoResizeSize || list.Height > maxNoResizeSize {
+		c.JSON(400, gin.H{"error": fmt.Sprintf("width and height must be at most %d", maxNoResizeSize)})
+		return
+	}
+
+	// The model only needs a canvas of the working size; its target is
+	// never searched.
+	target := image.NewNRGBA(image.Rect(0, 0, list.Width, list.Height))
+	model := primitive.NewModel(target, primitive.MakeHexColor(list.Background), req.Size, 1)
+	if err := model.AddShapeList(list); err != nil {
+		c.JSON(400, gin.H{"error": fmt.Sprintf("Invalid shape list: %v", err)})
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := encoder.Encode(&buf, model); err != nil {
+		c.JSON(500, gin.H{"error": fmt.Sprintf("failed to encode result: %v", err)})
+		return
+	}
+	log.Printf("Rendered %d shapes at %dx%d as %s (%d bytes)", len(list.Shapes), model.Sw, model.Sh, req.Format, buf.Len())
+	c.Data(200, encoder.ContentType(), buf.Bytes())
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"image"
+	"image/draw"
+	"math"
+
+	"github.com/nfnt/resize"
+)
+
+// thumbnail shrinks img to fit a size x size box, keeping its aspect ratio,
+// as resize.Thumbnail does. With resample "area" each pixel is the mean of
+// the input area it covers, counting the pixels on its edges by how much of
+// them it covers, which keeps the average color of fine detail that the
+// default bilinear filter can shift. Images that already fit are returned
+// as they are.
+func thumbnail(img image.Image, size int, resample string) image.Image {
+	if resample != "area" {
+		return resize.Thumbnail(uint(size), uint(size), img, resize.Bilinear)
+	}
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w <= size && h <= size {
+		return img
+	}
+	if w > size {
+		h = max(h*size/w, 1)
+		w = size
+	}
+	if h > size {
+		w = max(w*size/h, 1)
+		h = size
+	}
+	return areaResize(img, w, h)
+}
+
+// areaSpan is the run of source pixels one output pixel covers along a
+// side, from start, with the share of the output pixel each covers.
+type areaSpan struct {
+	start   int
+	weights []float64
+}
+
+// areaSpans divides a side of src pixels into n output pixels.
+func areaSpans(src, n int) []areaSpan {
+	scale := float64(src) / float64(n)
+	spans := make([]areaSpan, n)
+	for i := range spans {
+		x0, x1 := float64(i)*scale, float64(i+1)*scale
+		start, end := int(x0), min(int(math.Ceil(x1)), src)
+		weights := make([]float64, end-start)
+		for j := range weights {
+			p := float64(start + j)
+			weights[j] = (math.Min(x1, p+1) - math.Max(x0, p)) / scale
+		}
+		spans[i] = areaSpan{start, weights}
+	}
+	return spans
+}
+
+// areaResize shrinks img to w x h by area averaging, rows then columns, in
+// premultiplied RGBA so that transparent pixels add no color.
+func areaResize(img image.Image, w, h int) *image.RGBA {
+	b := img.Bounds()
+	src := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(src, src.Rect, img, b.Min, draw.Src)
+	sw, sh := src.Rect.Dx(), src.Rect.Dy()
+
+	// rows holds the source rows averaged across to w columns
+	rows := make([]float64, sh*w*4)
+	cols := areaSpans(sw, w)
+	for y := 0; y < sh; y++ {
+		for x, span := range cols {
+			k := (y*w + x) * 4
+			for j, weight := range span.weights {
+				i := src.PixOffset(span.start+j, y)
+				for c := 0; c < 4; c++ {
+					rows[k+c] += weight * float64(src.Pix[i+c])
+				}
+			}
+		}
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y, span := range areaSpans(sh, h) {
+		for x := 0; x < w; x++ {
+			var sum [4]float64
+			for j, weight := range span.weights {
+				k := ((span.start+j)*w + x) * 4
+				for c := 0; c < 4; c++ {
+					sum[c] += weight * rows[k+c]
+				}
+			}
+			i := dst.PixOffset(x, y)
+			for c := 0; c < 4; c++ {
+				dst.Pix[i+c] = uint8(math.Min(math.Round(sum[c]), 255))
+			}
+		}
+	}
+	return dst
+}
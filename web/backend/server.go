@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// defaultMaxProcessingSeconds is how long a request is expected to take at
+// most. MAX_PROCESSING_SECONDS overrides it.
+const defaultMaxProcessingSeconds = 120
+
+// serve runs the server until SIGTERM or SIGINT, then stops accepting
+// connections and waits up to the max processing duration for requests in
+// flight to finish, so a deploy does not cut off renders part way.
+func serve(handler http.Handler, addr string) {
+	drain := time.Duration(envInt("MAX_PROCESSING_SECONDS", defaultMaxProcessingSeconds)) * time.Second
+	server := &http.Server{
+		Addr:    addr,
+		Handler: handler,
+	}
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- server.ListenAndServe()
+	}()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	select {
+	case err := <-errs:
+		log.Fatalf("Server failed: %v", err)
+	case sig := <-signals:
+		log.Printf("Received %v, waiting up to %v for requests in flight", sig, drain)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), drain)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		log.Printf("Shutdown did not finish cleanly: %v", err)
+		return
+	}
+	if err := <-errs; err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Printf("Server error: %v", err)
+	}
+	log.Printf("Server stopped")
+}
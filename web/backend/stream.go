@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"mime/multipart"
+	"net/textproto"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/fogleman/primitive/primitive"
+)
+
+// previewShapes is the number of shapes in the preview that POST
+// /api/stream sends before the final image.
+const previewShapes = 20
+
+// streamWriter writes JPEG parts of a multipart/x-mixed-replace response,
+// flushing each so the client can show it at once.
+type streamWriter struct {
+	c       *gin.Context
+	parts   *multipart.Writer
+	started bool
+}
+
+// writePart writes one JPEG part, sending the response headers first if
+// this is the first part.
+func (s *streamWriter) writePart(data []byte) error {
+	if !s.started {
+		s.c.Header("Content-Type", "multipart/x-mixed-replace; boundary="+s.parts.Boundary())
+		s.c.Status(200)
+		s.started = true
+	}
+	part, err := s.parts.CreatePart(textproto.MIMEHeader{
+		"Content-Type":   {"image/jpeg"},
+		"Content-Length": {strconv.Itoa(len(data))},
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := part.Write(data); err != nil {
+		return err
+	}
+	s.c.Writer.Flush()
+	return nil
+}
+
+// handleStream renders an upload like POST /api/process but answers with a
+// multipart/x-mixed-replace stream: a JPEG of the first previewShapes
+// shapes as soon as they are found, then the final JPEG from the same
+// model, so the client can show the preview and swap in the result.
+// Cached results, and runs of no more shapes than the preview, are sent
+// as the final part alone.
+func handleStream(c *gin.Context) {
+	if c.ContentType() == "application/json" {
+		c.JSON(400, gin.H{"error": "stream takes a multipart form"})
+		return
+	}
+	upload, req, ok := readMultipartRequest(c)
+	if !ok {
+		return
+	}
+	defer upload.Close()
+	if req.Video || req.Layers > 0 || req.ContactSheet || req.Compare || req.Metrics || req.TopK > 0 || req.Canvas != "" || req.Attempts > 1 {
+		c.JSON(400, gin.H{"error": "stream cannot be combined with video, layers, contactsheet, compare, metrics, topk, canvas or attempts"})
+		return
+	}
+	req.Format = "jpeg"
+	if !validateRequest(c, req) {
+		return
+	}
+	if _, ok := checkFormat(c, upload); !ok {
+		return
+	}
+
+	stream := &streamWriter{c: c, parts: multipart.NewWriter(c.Writer)}
+	req.onPreview = func(model *primitive.Model) {
+		var buf bytes.Buffer
+		if err := primitive.EncodeJPEG(&buf, model.Render(), 95, req.DPI); err != nil {
+			log.Printf("Failed to encode preview: %v", err)
+			return
+		}
+		if err := stream.writePart(buf.Bytes()); err != nil {
+			log.Printf("Failed to send preview: %v", err)
+		}
+	}
+	log.Printf("Streaming image: count=%d, mode=%d, alpha=%d", req.Count, req.Mode, req.Alpha)
+	result, err := processImageSync(upload, req)
+	if err != nil {
+		if stream.started {
+			// the preview is out, so the status can no longer change
+			log.Printf("Stream failed after the preview: %v", err)
+			return
+		}
+		var reqErr requestError
+		if errors.As(err, &reqErr) {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if err := stream.writePart(result.Data); err != nil {
+		log.Printf("Failed to send result: %v", err)
+		return
+	}
+	if err := stream.parts.Close(); err != nil {
+		log.Printf("Failed to close stream: %v", err)
+	}
+	log.Printf("Stream complete, final image %d bytes", len(result.Data))
+}
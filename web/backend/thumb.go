@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"strings"
+
+	"github.com/fogleman/primitive/primitive"
+	"github.com/gin-gonic/gin"
+)
+
+// maxThumbSize bounds the thumb param, in pixels.
+const maxThumbSize = 512
+
+// thumbEnvelope returns the JSON body for thumb=SIZE: the result and a copy
+// of it shrunk to fit a size x size box, both base64 encoded, the thumbnail
+// in the result's own format. With dataURI both are data URIs instead. The
+// result must be a JPEG or PNG.
+func thumbEnvelope(result *ProcessResult, size int, dataURI bool) (gin.H, error) {
+	img, _, err := image.Decode(bytes.NewReader(result.Data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode result for thumbnail: %v", err)
+	}
+	thumb := thumbnail(img, size, "bilinear")
+	var buf bytes.Buffer
+	if result.ContentType == "image/png" {
+		err = primitive.EncodePNG(&buf, thumb, 0)
+	} else {
+		err = primitive.EncodeJPEG(&buf, thumb, 95, 0)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode thumbnail: %v", err)
+	}
+	encode := func(data []byte) string {
+		s := base64.StdEncoding.EncodeToString(data)
+		if dataURI {
+			// as for datauri=1, with no spaces in the media type
+			s = "data:" + strings.ReplaceAll(result.ContentType, " ", "") + ";base64," + s
+		}
+		return s
+	}
+	b := thumb.Bounds()
+	return gin.H{
+		"contentType": result.ContentType,
+		"image":       encode(result.Data),
+		"thumb":       encode(buf.Bytes()),
+		"thumbWidth":  b.Dx(),
+		"thumbHeight": b.Dy(),
+	}, nil
+}
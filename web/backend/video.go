@@ -0,0 +1,56 @@
+package main
+
+import (
+	"archive/zip"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/fogleman/primitive/primitive"
+)
+
+// maxVideoFrames bounds the frames in a video=1 response. Long runs add
+// several shapes per frame to stay under it.
+const maxVideoFrames = 100
+
+// writeFramesZip writes the model's frames to w as a ZIP of numbered PNGs.
+func writeFramesZip(w io.Writer, model *primitive.Model) error {
+	dir, err := os.MkdirTemp("", "primitive-frames")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	everyN := (len(model.Shapes) + maxVideoFrames - 1) / maxVideoFrames
+	if err := model.WriteFrames(dir, everyN); err != nil {
+		return err
+	}
+	names, err := filepath.Glob(filepath.Join(dir, "*.png"))
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	zw := zip.NewWriter(w)
+	for _, name := range names {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		if err := storeFile(zw, filepath.Base(name), data, now); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+// storeFile adds a file to zw uncompressed, since PNGs already are.
+func storeFile(zw *zip.Writer, name string, data []byte, modified time.Time) error {
+	f, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Store, Modified: modified})
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	return err
+}